@@ -0,0 +1,113 @@
+package bot
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Arun445/tcp-go/internal/transport"
+)
+
+type recorder struct {
+	posts chan string
+}
+
+func (r *recorder) Post(text string) {
+	r.posts <- text
+}
+
+func (r *recorder) next(t *testing.T) string {
+	t.Helper()
+	select {
+	case text := <-r.posts:
+		return text
+	case <-time.After(time.Second):
+		t.Fatal("Nothing posted")
+		return ""
+	}
+}
+
+func TestRun(t *testing.T) {
+	server, client := transport.Pipe("bot")
+	defer server.Close()
+	go Run(client, "ops", Echo())
+
+	for _, want := range []string{"/proto json\n", "/join ops\n"} {
+		if frame, _ := server.ReadFrame(); string(frame) != want {
+			t.Errorf("Expected %q, got %q", want, frame)
+		}
+	}
+
+	// Lines split across frames are reassembled.
+	server.WriteFrame([]byte(`{"type":"notice","text":"Joined #ops"}` + "\n" + `{"type":"message","id":1,`))
+	server.WriteFrame([]byte(`"room":"ops","from":"alice","body":"!echo hi there"}` + "\n"))
+	if frame, _ := server.ReadFrame(); string(frame) != "hi there\n" {
+		t.Errorf("Expected echo, got %q", frame)
+	}
+}
+
+func TestRun_PostsDoNotBlock(t *testing.T) {
+	server, client := transport.Pipe("bot")
+	defer server.Close()
+	handled := make(chan struct{}, 2)
+	go Run(client, "", HandlerFunc(func(m Message, poster Poster) {
+		for i := 0; i < 2*postQueue; i++ {
+			poster.Post(m.Body)
+		}
+		handled <- struct{}{}
+	}))
+
+	// Nobody reads the bot's posts, yet it keeps handling messages.
+	for i := 0; i < 2; i++ {
+		server.WriteFrame([]byte(`{"type":"message","body":"flood"}` + "\n"))
+		select {
+		case <-handled:
+		case <-time.After(time.Second):
+			t.Fatal("Handler blocked on a full post queue")
+		}
+	}
+}
+
+func TestReminder(t *testing.T) {
+	poster := &recorder{posts: make(chan string, 10)}
+	reminder := Reminder()
+
+	reminder.HandleMessage(Message{From: "alice", Body: "!remind soon stretch"}, poster)
+	if reply := poster.next(t); !strings.Contains(reply, "usage") {
+		t.Errorf("Expected usage, got %q", reply)
+	}
+
+	reminder.HandleMessage(Message{From: "alice", Body: "!remind 10ms stretch"}, poster)
+	poster.next(t)
+	if reply := poster.next(t); reply != "@alice reminder: stretch" {
+		t.Errorf("Expected reminder, got %q", reply)
+	}
+}
+
+func TestStandup(t *testing.T) {
+	poster := &recorder{posts: make(chan string, 10)}
+	standup := Standup()
+
+	standup.HandleMessage(Message{From: "alice", Body: "!update nothing"}, poster)
+	if reply := poster.next(t); !strings.Contains(reply, "No standup is running") {
+		t.Errorf("Expected update outside standup to be refused, got %q", reply)
+	}
+
+	standup.HandleMessage(Message{From: "alice", Body: "!standup"}, poster)
+	poster.next(t)
+	standup.HandleMessage(Message{From: "bob", Body: "!update fixed the build"}, poster)
+	standup.HandleMessage(Message{From: "alice", Body: "!update wrote docs"}, poster)
+	standup.HandleMessage(Message{From: "bob", Body: "!update fixed the build, blocked on review"}, poster)
+	standup.HandleMessage(Message{From: "alice", Body: "!standup done"}, poster)
+
+	expected := []string{
+		"Standup summary (2 updates):",
+		"bob: fixed the build, blocked on review",
+		"alice: wrote docs",
+	}
+	for _, want := range expected {
+		if reply := poster.next(t); reply != want {
+			t.Errorf("Expected %q, got %q", want, reply)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Builtin returns one of the bots that ship with the server: echo, reminder
+// or standup.
+func Builtin(name string) (Handler, bool) {
+	switch name {
+	case "echo":
+		return Echo(), true
+	case "reminder":
+		return Reminder(), true
+	case "standup":
+		return Standup(), true
+	}
+	return nil, false
+}
+
+// Echo repeats "!echo <text>".
+func Echo() Handler {
+	return HandlerFunc(func(m Message, poster Poster) {
+		if text, ok := strings.CutPrefix(m.Body, "!echo "); ok {
+			poster.Post(text)
+		}
+	})
+}
+
+// Reminder answers "!remind <duration> <text>" by mentioning the sender with
+// the text once the duration has passed.
+func Reminder() Handler {
+	return reminder{}
+}
+
+func (reminder) HandleMessage(m Message, poster Poster) {
+	args, ok := strings.CutPrefix(m.Body, "!remind ")
+	if !ok {
+		return
+	}
+	fields := strings.SplitN(args, " ", 2)
+	delay, err := time.ParseDuration(fields[0])
+	if len(fields) != 2 || err != nil || delay <= 0 || delay > maxReminder {
+		poster.Post(fmt.Sprintf("@%s usage: !remind <duration up to %s> <text>", m.From, maxReminder))
+		return
+	}
+
+	text := fields[1]
+	poster.Post(fmt.Sprintf("@%s I will remind you in %s", m.From, delay))
+	time.AfterFunc(delay, func() {
+		poster.Post(fmt.Sprintf("@%s reminder: %s", m.From, text))
+	})
+}
+
+// Standup runs a standup: "!standup" starts it, "!update <text>" records
+// each member's update and "!standup done" posts the summary.
+func Standup() Handler {
+	return &standup{}
+}
+
+func (s *standup) HandleMessage(m Message, poster Poster) {
+	switch {
+	case m.Body == "!standup":
+		s.running = true
+		s.names = nil
+		s.updates = make(map[string]string)
+		poster.Post("Standup time! Reply with !update <done, next, blockers>, finish with !standup done")
+
+	case m.Body == "!standup done":
+		if !s.running {
+			poster.Post("No standup is running, start one with !standup")
+			return
+		}
+		s.running = false
+		if len(s.names) == 0 {
+			poster.Post("Standup finished without updates")
+			return
+		}
+		poster.Post(fmt.Sprintf("Standup summary (%d updates):", len(s.names)))
+		for _, name := range s.names {
+			poster.Post(fmt.Sprintf("%s: %s", name, s.updates[name]))
+		}
+
+	case strings.HasPrefix(m.Body, "!update "):
+		if !s.running {
+			poster.Post("No standup is running, start one with !standup")
+			return
+		}
+		if _, ok := s.updates[m.From]; !ok {
+			s.names = append(s.names, m.From)
+		}
+		s.updates[m.From] = strings.TrimPrefix(m.Body, "!update ")
+	}
+}
@@ -0,0 +1,61 @@
+// Package bot runs chat bots in the server process. A bot is a session like
+// any other: it reads the room through the structured protocol and posts
+// plain lines, so its replies take the normal broadcast path.
+package bot
+
+import "time"
+
+// Message is a broadcast seen by a bot.
+type Message struct {
+	ID   uint64
+	Room string
+	From string
+	Body string
+	Time time.Time
+}
+
+// Poster sends a line to the bot's room. It is safe to use from any
+// goroutine, such as a timer.
+type Poster interface {
+	Post(text string)
+}
+
+// Handler reacts to messages. HandleMessage is called for one message at a
+// time.
+type Handler interface {
+	HandleMessage(m Message, poster Poster)
+}
+
+// HandlerFunc adapts a function to Handler.
+type HandlerFunc func(m Message, poster Poster)
+
+func (f HandlerFunc) HandleMessage(m Message, poster Poster) {
+	f(m, poster)
+}
+
+// Conn is the bot's end of its connection to the server. Every frame written
+// is one line.
+type Conn interface {
+	ReadFrame() ([]byte, error)
+	WriteFrame(frame []byte) error
+}
+
+// postQueue is how many posts may wait to reach the room.
+const postQueue = 64
+
+type poster struct {
+	posts chan string
+	done  chan struct{}
+}
+
+// reminder posts its text after a delay. maxReminder caps the delay.
+type reminder struct{}
+
+const maxReminder = 24 * time.Hour
+
+// standup collects updates between "!standup" and "!standup done".
+type standup struct {
+	running bool
+	names   []string
+	updates map[string]string
+}
@@ -0,0 +1,80 @@
+package bot
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+
+	"github.com/Arun445/tcp-go/internal/message"
+)
+
+// Run drives handler over conn until the connection closes. The bot joins
+// room first unless room is empty.
+func Run(conn Conn, room string, handler Handler) error {
+	p := &poster{posts: make(chan string, postQueue), done: make(chan struct{})}
+	defer close(p.done)
+	go p.write(conn)
+
+	p.Post("/proto json")
+	if room != "" {
+		p.Post("/join " + room)
+	}
+
+	var pending []byte
+	for {
+		frame, err := conn.ReadFrame()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		pending = append(pending, frame...)
+		for {
+			end := bytes.IndexByte(pending, '\n')
+			if end < 0 {
+				break
+			}
+			line := pending[:end]
+			pending = pending[end+1:]
+
+			var event message.Event
+			if err := json.Unmarshal(line, &event); err != nil || event.Type != "message" {
+				continue
+			}
+			m := Message{ID: event.ID, Room: event.Room, From: event.From, Body: event.Body}
+			if event.Time != nil {
+				m.Time = *event.Time
+			}
+			handler.HandleMessage(m, p)
+		}
+	}
+}
+
+// Post queues text without waiting, so a handler never holds up the bot
+// while the room is busy. Posts beyond postQueue are dropped.
+func (p *poster) Post(text string) {
+	select {
+	case p.posts <- text:
+	case <-p.done:
+	default:
+		log.Printf("Bot posts are not reaching the room, dropping %q", text)
+	}
+}
+
+// write sends one line per write, so every post reaches the room as its own
+// message.
+func (p *poster) write(conn Conn) {
+	for {
+		select {
+		case text := <-p.posts:
+			if err := conn.WriteFrame([]byte(text + "\n")); err != nil {
+				return
+			}
+		case <-p.done:
+			return
+		}
+	}
+}
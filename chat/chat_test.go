@@ -0,0 +1,367 @@
+package chat
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChain(t *testing.T) {
+	var calls []string
+	hook := func(name string, err error) Hooks {
+		return Hooks{
+			OnConnect: func(net.Conn) error {
+				calls = append(calls, name)
+				return err
+			},
+			OnMessage: func(_ *Room, _ *Session, m *Message) error {
+				m.Body = append(m.Body, name...)
+				return err
+			},
+		}
+	}
+
+	chain := Chain(hook("a", nil), Hooks{}, hook("b", errors.New("stop")), hook("c", nil))
+	if err := chain.OnConnect(nil); err == nil || err.Error() != "stop" {
+		t.Errorf("Expected chain to stop at b, got %v", err)
+	}
+	if strings.Join(calls, "") != "ab" {
+		t.Errorf("Expected a then b, got %v", calls)
+	}
+
+	m := &Message{}
+	chain.OnMessage(nil, nil, m)
+	if string(m.Body) != "ab" {
+		t.Errorf("Expected each hook to see the previous change, got %q", m.Body)
+	}
+	if chain.OnRegister != nil {
+		t.Error("Expected unused hooks to stay nil")
+	}
+}
+
+func TestServer_Hooks(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	registered := make(chan string, 10)
+	server := NewServer(&ServerConfig{}, &RoomConfig{Name: "lobby", ByteLimit: 1000})
+	server.Use(Hooks{
+		OnRegister: func(room *Room, session *Session) {
+			registered <- room.Name()
+		},
+		OnMessage: func(room *Room, session *Session, m *Message) error {
+			if bytes.Contains(m.Body, []byte("secret")) {
+				return errors.New("no secrets here")
+			}
+			m.Body = bytes.ToUpper(m.Body)
+			return nil
+		},
+	})
+	go server.Serve(listener)
+
+	dial := func() (net.Conn, *bufio.Reader) {
+		t.Helper()
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		select {
+		case <-registered:
+		case <-time.After(time.Second):
+			t.Fatal("Session was not registered")
+		}
+		return conn, bufio.NewReader(conn)
+	}
+	readLine := func(conn net.Conn, reader *bufio.Reader) string {
+		t.Helper()
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read: %v", err)
+		}
+		return line
+	}
+
+	alice, aliceReader := dial()
+	defer alice.Close()
+	bob, bobReader := dial()
+	defer bob.Close()
+
+	alice.Write([]byte("hello\n"))
+	if line := readLine(bob, bobReader); line != "HELLO\n" {
+		t.Errorf("Expected hook to change the message, got %q", line)
+	}
+
+	alice.Write([]byte("my secret\n"))
+	if line := readLine(alice, aliceReader); line != "no secrets here\n" {
+		t.Errorf("Expected rejection notice, got %q", line)
+	}
+}
+
+func TestServer_Bots(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	registered := make(chan struct{}, 10)
+	server := NewServer(&ServerConfig{Bots: []string{"echo"}, BotsExempt: true}, &RoomConfig{Name: "lobby", ByteLimit: 1000})
+	server.Use(Hooks{
+		OnRegister: func(*Room, *Session) { registered <- struct{}{} },
+	})
+	go server.Serve(listener)
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	awaitRegistered(t, registered, 2)
+
+	// The bot switches to the structured protocol once registered and skips
+	// what reaches it before, so ping until it answers.
+	reader := bufio.NewReader(conn)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn.Write([]byte("!echo ping\n"))
+		conn.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+		line, err := reader.ReadString('\n')
+		if err == nil {
+			if line != "ping\n" {
+				t.Errorf("Expected the echo bot to answer, got %q", line)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the echo bot to answer, got %v", err)
+		}
+	}
+}
+
+func TestServer_BotUnderLoad(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	registered := make(chan struct{}, 10)
+	server := NewServer(&ServerConfig{Bots: []string{"echo"}, BotsExempt: true}, &RoomConfig{Name: "lobby", ByteLimit: 1 << 20})
+	server.Use(Hooks{
+		OnRegister: func(*Room, *Session) { registered <- struct{}{} },
+	})
+	go server.Serve(listener)
+
+	dial := func() net.Conn {
+		t.Helper()
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		return conn
+	}
+	alice := dial()
+	defer alice.Close()
+	go io.Copy(io.Discard, alice)
+	bob := dial()
+	defer bob.Close()
+	awaitRegistered(t, registered, 3)
+
+	// Every line makes the bot post while the room keeps sending it more,
+	// which must not stall the room.
+	go func() {
+		for i := 0; i < 1000; i++ {
+			fmt.Fprintf(alice, "!echo %d\n", i)
+		}
+		alice.Write([]byte("last\n"))
+	}()
+
+	reader := bufio.NewReader(bob)
+	bob.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Room stalled under bot traffic: %v", err)
+		}
+		if line == "last\n" {
+			break
+		}
+	}
+}
+
+func TestServer_AuditsDeniedConnections(t *testing.T) {
+	dir := t.TempDir()
+	aclFile := filepath.Join(dir, "acl.json")
+	auditFile := filepath.Join(dir, "audit.jsonl")
+	os.WriteFile(aclFile, []byte(`[{"action": "deny", "cidr": "127.0.0.0/8"}]`), 0o600)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	server := NewServer(&ServerConfig{ACLFile: aclFile, AuditFile: auditFile}, &RoomConfig{Name: "lobby", ByteLimit: 1000})
+	served := make(chan error)
+	go func() { served <- server.Serve(listener) }()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if line, _ := bufio.NewReader(conn).ReadString('\n'); !strings.Contains(line, "access denied") {
+		t.Fatalf("Expected the connection to be denied, got %q", line)
+	}
+
+	// Records are written in the background, and all of them once Serve
+	// has returned.
+	listener.Close()
+	select {
+	case <-served:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Serve did not return once the listener closed")
+	}
+	data, _ := os.ReadFile(auditFile)
+	if !strings.Contains(string(data), `"action":"connection_denied","addr":"`+conn.LocalAddr().String()+`"`) {
+		t.Errorf("Expected the denied connection in the audit log, got %s", data)
+	}
+}
+
+func TestServer_RoomOperations(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	server := NewServer(&ServerConfig{}, &RoomConfig{Name: "lobby", ByteLimit: 1000})
+	server.Use(Hooks{
+		OnRegister: func(room *Room, session *Session) {
+			room.Post("greeter", []byte(fmt.Sprintf("%d here", len(room.Members()))))
+		},
+	})
+	go server.Serve(listener)
+
+	dial := func() (net.Conn, *bufio.Reader) {
+		t.Helper()
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		return conn, bufio.NewReader(conn)
+	}
+
+	alice, aliceReader := dial()
+	defer alice.Close()
+	if line, _ := aliceReader.ReadString('\n'); line != "1 here\n" {
+		t.Errorf("Expected the hook to post to alice, got %q", line)
+	}
+	bob, bobReader := dial()
+	defer bob.Close()
+	for _, reader := range []*bufio.Reader{aliceReader, bobReader} {
+		if line, _ := reader.ReadString('\n'); line != "2 here\n" {
+			t.Errorf("Expected the hook to post to everyone, got %q", line)
+		}
+	}
+}
+
+func TestServer_ServeStops(t *testing.T) {
+	before := runtime.NumGoroutine()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	registered := make(chan struct{}, 10)
+	server := NewServer(&ServerConfig{Bots: []string{"echo"}, MetricsAddr: "127.0.0.1:0"}, &RoomConfig{
+		Name:      "lobby",
+		ByteLimit: 1000,
+		Auth: &AuthConfig{
+			Mode:          AuthRequired,
+			UsersFile:     filepath.Join(t.TempDir(), "users.json"),
+			AllowRegister: true,
+		},
+	})
+	server.Use(Hooks{
+		OnRegister: func(*Room, *Session) { registered <- struct{}{} },
+	})
+	served := make(chan error)
+	go func() { served <- server.Serve(listener) }()
+
+	dial := func() (net.Conn, *bufio.Reader) {
+		t.Helper()
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		reader := bufio.NewReader(conn)
+		reader.ReadString('\n')
+		return conn, reader
+	}
+	alice, aliceReader := dial()
+	defer alice.Close()
+	alice.Write([]byte("REGISTER alice secret\n"))
+	awaitRegistered(t, registered, 2)
+	// bob stays at the login prompt.
+	bob, bobReader := dial()
+	defer bob.Close()
+
+	listener.Close()
+	select {
+	case err := <-served:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Errorf("Expected Serve to return the listener error, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Serve did not return once the listener closed")
+	}
+
+	for _, conn := range []net.Conn{alice, bob} {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	}
+	if data, err := io.ReadAll(aliceReader); err != nil || !strings.Contains(string(data), "Server is shutting down") {
+		t.Errorf("Expected alice to be told and disconnected, got %q %v", data, err)
+	}
+	if _, err := io.ReadAll(bobReader); err != nil {
+		t.Errorf("Expected bob to be disconnected, got %v", err)
+	}
+
+	// Goroutines Serve waited for may still be returning, so let them run
+	// before counting again.
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected Serve to stop its goroutines, %d left of %d", runtime.NumGoroutine(), before)
+		}
+		runtime.Gosched()
+	}
+}
+
+// awaitRegistered waits until the OnRegister hook reported n sessions.
+func awaitRegistered(t *testing.T, registered <-chan struct{}, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		select {
+		case <-registered:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected %d sessions registered, got %d", n, i)
+		}
+	}
+}
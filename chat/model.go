@@ -0,0 +1,170 @@
+// Package chat embeds the chat server in other programs. Hooks observe and
+// change what the server does without forking it.
+package chat
+
+import (
+	"net"
+	"time"
+
+	"github.com/Arun445/tcp-go/bot"
+	"github.com/Arun445/tcp-go/internal/config"
+	"github.com/Arun445/tcp-go/internal/room"
+	"github.com/Arun445/tcp-go/internal/session"
+)
+
+// ServerConfig holds the settings of the listener and the services shared
+// by every room. FromEnv reads the ones the tcp command uses.
+type ServerConfig struct {
+	Port        string
+	ACLFile     string
+	FilterFile  string
+	MetricsAddr string
+	// AuditFile is the append-only audit log. Empty disables it.
+	AuditFile string
+	// TLSCertFile and TLSKeyFile serve clients over TLS when both are set.
+	TLSCertFile string
+	TLSKeyFile  string
+	// Compression lets clients negotiate DEFLATE.
+	Compression bool
+	// Bots names built-in bots to run in the lobby. BotsExempt spares them
+	// the byte limits and filters.
+	Bots       []string
+	BotsExempt bool
+	// MOTDFile holds the message of the day. A missing file disables it.
+	MOTDFile string
+	// Broker is "memory" or "redis".
+	Broker    string
+	RedisAddr string
+
+	// Connection limits, zero disables the respective check.
+	MaxConnections int
+	MaxPerIP       int
+	MaxPerCIDR     int
+	CIDRPrefixV4   int
+	CIDRPrefixV6   int
+	AcceptRate     float64
+	AcceptBurst    int
+
+	// Cluster runs the server as a cluster node when set.
+	Cluster *ClusterConfig
+}
+
+// RoomConfig holds the settings of the lobby, which the rooms opened from
+// it share.
+type RoomConfig struct {
+	Name      string
+	ByteLimit int
+	// LimitCompressed counts ByteLimit on the connection, after compression,
+	// instead of on the messages.
+	LimitCompressed bool
+
+	// StateDir persists room settings such as room roles. Empty disables it.
+	StateDir  string
+	RolesFile string
+
+	// MailboxDir enables offline delivery for registered accounts when set.
+	MailboxDir    string
+	MailboxSize   int
+	MailboxMaxAge time.Duration
+
+	// MaxRooms caps how many rooms are open, the lobby included. Zero
+	// removes the cap.
+	MaxRooms int
+
+	// HistorySize is how many broadcasts are kept for /resend and /delivery.
+	HistorySize int
+
+	// BroadcastShards hands fan-out to that many goroutines. Zero fans out
+	// from the room loop.
+	BroadcastShards int
+
+	// WriteBatchBytes and WriteFlushDelay let each session's writer gather
+	// queued messages into one write.
+	WriteBatchBytes int
+	WriteFlushDelay time.Duration
+
+	// TransferLimit caps file transfer bytes per session in each direction.
+	// Zero removes the cap.
+	TransferLimit int
+	TransferTTL   time.Duration
+
+	// ResumeGrace is how long a dropped session can be resumed with its token.
+	ResumeGrace time.Duration
+	ResumeQueue int
+
+	// Auth enables the login handshake when set. Nil keeps connections
+	// anonymous.
+	Auth *AuthConfig
+}
+
+// AuthGuest lets clients in as guests next to registered accounts,
+// AuthRequired only lets accounts in.
+const (
+	AuthGuest    = config.AuthGuest
+	AuthRequired = config.AuthRequired
+)
+
+type AuthConfig struct {
+	Mode          string
+	UsersFile     string
+	AllowRegister bool
+	MaxFailures   int
+	Lockout       time.Duration
+}
+
+type ClusterConfig struct {
+	NodeID string
+	// Addr is where this node accepts peers.
+	Addr  string
+	Peers []string
+	// Secret is shared by every node of the cluster and must be set.
+	Secret string
+}
+
+type Server struct {
+	config     *ServerConfig
+	roomConfig *RoomConfig
+	hooks      []Hooks
+	bots       []botSpec
+}
+
+type botSpec struct {
+	Name    string
+	Room    string
+	Handler bot.Handler
+	Exempt  bool
+}
+
+// Hooks are called as connections and sessions come and go. Any of them may
+// be nil. OnRegister, OnMessage and OnUnregister run on the room's event
+// loop and must not block; the room waits for them.
+type Hooks struct {
+	// OnConnect runs for every accepted connection before the login. An
+	// error refuses the connection with the error text.
+	OnConnect func(conn net.Conn) error
+	// OnRegister and OnUnregister run when a session enters or leaves a
+	// room, including moves between rooms.
+	OnRegister   func(room *Room, session *Session)
+	OnUnregister func(room *Room, session *Session)
+	// OnMessage runs for each message before it is broadcast. It may change
+	// the message. An error rejects it and is sent to the sender.
+	OnMessage func(room *Room, session *Session, m *Message) error
+	// OnDisconnect runs once the connection is closed.
+	OnDisconnect func(conn net.Conn)
+}
+
+// Room is the room a hook runs for. It is only valid during the hook call.
+type Room struct {
+	room *room.Room
+}
+
+// Session is a connected client as seen by a hook. It is only valid during
+// the hook call.
+type Session struct {
+	session *session.Session
+}
+
+type Message struct {
+	From string
+	Body []byte
+}
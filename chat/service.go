@@ -0,0 +1,566 @@
+package chat
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Arun445/tcp-go/bot"
+	"github.com/Arun445/tcp-go/internal/acl"
+	"github.com/Arun445/tcp-go/internal/audit"
+	"github.com/Arun445/tcp-go/internal/broker"
+	"github.com/Arun445/tcp-go/internal/cluster"
+	"github.com/Arun445/tcp-go/internal/config"
+	"github.com/Arun445/tcp-go/internal/filter"
+	"github.com/Arun445/tcp-go/internal/limiter"
+	"github.com/Arun445/tcp-go/internal/message"
+	"github.com/Arun445/tcp-go/internal/metrics"
+	"github.com/Arun445/tcp-go/internal/room"
+	"github.com/Arun445/tcp-go/internal/session"
+	"github.com/Arun445/tcp-go/internal/transport"
+)
+
+// FromEnv reads the server and lobby settings from environment variables.
+func FromEnv() (*ServerConfig, *RoomConfig) {
+	s, r := config.Server(), config.Room()
+	serverConfig := &ServerConfig{
+		Port:           s.Port,
+		ACLFile:        s.ACLFile,
+		FilterFile:     s.FilterFile,
+		MetricsAddr:    s.MetricsAddr,
+		AuditFile:      s.AuditFile,
+		TLSCertFile:    s.TLSCertFile,
+		TLSKeyFile:     s.TLSKeyFile,
+		Compression:    s.Compression,
+		Bots:           s.Bots,
+		BotsExempt:     s.BotsExempt,
+		MOTDFile:       s.MOTDFile,
+		Broker:         s.Broker,
+		RedisAddr:      s.RedisAddr,
+		MaxConnections: s.MaxConnections,
+		MaxPerIP:       s.MaxPerIP,
+		MaxPerCIDR:     s.MaxPerCIDR,
+		CIDRPrefixV4:   s.CIDRPrefixV4,
+		CIDRPrefixV6:   s.CIDRPrefixV6,
+		AcceptRate:     s.AcceptRate,
+		AcceptBurst:    s.AcceptBurst,
+	}
+	if c := config.Cluster(); c != nil {
+		serverConfig.Cluster = &ClusterConfig{NodeID: c.NodeID, Addr: c.Addr, Peers: c.Peers, Secret: c.Secret}
+	}
+	roomConfig := &RoomConfig{
+		Name:            r.Name,
+		ByteLimit:       r.ByteLimit,
+		LimitCompressed: r.LimitCompressed,
+		StateDir:        r.StateDir,
+		RolesFile:       r.RolesFile,
+		MailboxDir:      r.MailboxDir,
+		MailboxSize:     r.MailboxSize,
+		MailboxMaxAge:   r.MailboxMaxAge,
+		MaxRooms:        r.MaxRooms,
+		HistorySize:     r.HistorySize,
+		BroadcastShards: r.BroadcastShards,
+		WriteBatchBytes: r.WriteBatchBytes,
+		WriteFlushDelay: r.WriteFlushDelay,
+		TransferLimit:   r.TransferLimit,
+		TransferTTL:     r.TransferTTL,
+		ResumeGrace:     r.ResumeGrace,
+		ResumeQueue:     r.ResumeQueue,
+	}
+	if a := r.Auth; a != nil {
+		roomConfig.Auth = &AuthConfig{Mode: a.Mode, UsersFile: a.UsersFile, AllowRegister: a.AllowRegister, MaxFailures: a.MaxFailures, Lockout: a.Lockout}
+	}
+	return serverConfig, roomConfig
+}
+
+// internal converts the settings to the ones the server runs on.
+func (c *ServerConfig) internal() *config.ServerConfig {
+	return &config.ServerConfig{
+		Port:           c.Port,
+		ACLFile:        c.ACLFile,
+		FilterFile:     c.FilterFile,
+		MetricsAddr:    c.MetricsAddr,
+		AuditFile:      c.AuditFile,
+		TLSCertFile:    c.TLSCertFile,
+		TLSKeyFile:     c.TLSKeyFile,
+		Compression:    c.Compression,
+		Bots:           c.Bots,
+		BotsExempt:     c.BotsExempt,
+		MOTDFile:       c.MOTDFile,
+		Broker:         c.Broker,
+		RedisAddr:      c.RedisAddr,
+		MaxConnections: c.MaxConnections,
+		MaxPerIP:       c.MaxPerIP,
+		MaxPerCIDR:     c.MaxPerCIDR,
+		CIDRPrefixV4:   c.CIDRPrefixV4,
+		CIDRPrefixV6:   c.CIDRPrefixV6,
+		AcceptRate:     c.AcceptRate,
+		AcceptBurst:    c.AcceptBurst,
+	}
+}
+
+func (c *ClusterConfig) internal() *config.ClusterConfig {
+	if c == nil {
+		return nil
+	}
+	return &config.ClusterConfig{NodeID: c.NodeID, Addr: c.Addr, Peers: c.Peers, Secret: c.Secret}
+}
+
+func (c *RoomConfig) internal() *config.RoomConfig {
+	roomConfig := &config.RoomConfig{
+		Name:            c.Name,
+		ByteLimit:       c.ByteLimit,
+		LimitCompressed: c.LimitCompressed,
+		StateDir:        c.StateDir,
+		RolesFile:       c.RolesFile,
+		MailboxDir:      c.MailboxDir,
+		MailboxSize:     c.MailboxSize,
+		MailboxMaxAge:   c.MailboxMaxAge,
+		MaxRooms:        c.MaxRooms,
+		HistorySize:     c.HistorySize,
+		BroadcastShards: c.BroadcastShards,
+		WriteBatchBytes: c.WriteBatchBytes,
+		WriteFlushDelay: c.WriteFlushDelay,
+		TransferLimit:   c.TransferLimit,
+		TransferTTL:     c.TransferTTL,
+		ResumeGrace:     c.ResumeGrace,
+		ResumeQueue:     c.ResumeQueue,
+	}
+	if a := c.Auth; a != nil {
+		roomConfig.Auth = &config.AuthConfig{Mode: a.Mode, UsersFile: a.UsersFile, AllowRegister: a.AllowRegister, MaxFailures: a.MaxFailures, Lockout: a.Lockout}
+	}
+	return roomConfig
+}
+
+func NewServer(serverConfig *ServerConfig, roomConfig *RoomConfig) *Server {
+	return &Server{
+		config:     serverConfig,
+		roomConfig: roomConfig,
+	}
+}
+
+// Use adds hooks, which run after the ones added before them. It must be
+// called before the server is started.
+func (server *Server) Use(hooks ...Hooks) {
+	server.hooks = append(server.hooks, hooks...)
+}
+
+// AddBot runs handler as a session named name in room, or in the lobby when
+// room is empty. Exempt bots skip byte limits and filters. It must be called
+// before the server is started.
+func (server *Server) AddBot(name string, room string, handler bot.Handler, exempt bool) {
+	server.bots = append(server.bots, botSpec{Name: name, Room: room, Handler: handler, Exempt: exempt})
+}
+
+func (server *Server) ListenAndServe() error {
+	listener, err := net.Listen("tcp", server.config.Port)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	return server.Serve(listener)
+}
+
+// Serve accepts connections on listener until it is closed. Sessions and
+// bots are then disconnected, and Serve returns once they and everything it
+// started have stopped.
+func (server *Server) Serve(listener net.Listener) error {
+	serverConfig := server.config.internal()
+	clusterConfig := server.config.Cluster.internal()
+
+	accessList, err := acl.Load(serverConfig.ACLFile)
+	if err != nil {
+		return fmt.Errorf("load access list from %s: %w", serverConfig.ACLFile, err)
+	}
+	pipeline, err := filter.Load(serverConfig.FilterFile)
+	if err != nil {
+		return fmt.Errorf("load filters from %s: %w", serverConfig.FilterFile, err)
+	}
+	var tlsConfig *tls.Config
+	if serverConfig.TLSCertFile != "" && serverConfig.TLSKeyFile != "" {
+		certificate, err := tls.LoadX509KeyPair(serverConfig.TLSCertFile, serverConfig.TLSKeyFile)
+		if err != nil {
+			return fmt.Errorf("load TLS certificate from %s: %w", serverConfig.TLSCertFile, err)
+		}
+		tlsConfig = &tls.Config{Certificates: []tls.Certificate{certificate}}
+	}
+	bots := server.bots
+	for _, name := range serverConfig.Bots {
+		handler, ok := bot.Builtin(name)
+		if !ok {
+			return fmt.Errorf("unknown bot %q", name)
+		}
+		bots = append(bots, botSpec{Name: name + "-bot", Handler: handler, Exempt: serverConfig.BotsExempt})
+	}
+
+	// Redis and the cluster both carry rooms between servers, and every
+	// message would arrive twice with both.
+	if clusterConfig != nil && serverConfig.Broker == "redis" {
+		return errors.New("BROKER=redis and CLUSTER_ADDR both share rooms between servers, set only one")
+	}
+	if clusterConfig != nil && clusterConfig.Secret == "" {
+		return errors.New("CLUSTER_SECRET must be set to run a cluster node")
+	}
+	var clusterListener net.Listener
+	if clusterConfig != nil {
+		clusterListener, err = net.Listen("tcp", clusterConfig.Addr)
+		if err != nil {
+			return fmt.Errorf("listen for cluster peers on %s: %w", clusterConfig.Addr, err)
+		}
+		defer clusterListener.Close()
+	}
+	var auditLog *audit.Log
+	if serverConfig.AuditFile != "" {
+		auditLog, err = audit.Load(serverConfig.AuditFile)
+		if err != nil {
+			return fmt.Errorf("load audit log from %s: %w", serverConfig.AuditFile, err)
+		}
+		go auditLog.Open()
+	}
+
+	lobby := room.NewRoom(server.roomConfig.internal())
+	lobby.SetACL(accessList)
+	lobby.SetAudit(auditLog)
+	lobby.SetFilter(pipeline)
+	lobby.SetMOTD(loadMOTD(serverConfig.MOTDFile))
+	if serverConfig.Broker == "redis" {
+		host, _ := os.Hostname()
+		message.SetOrigin(fmt.Sprintf("%s-%d-%d", host, os.Getpid(), time.Now().UnixNano()))
+		redis := broker.NewRedis(serverConfig.RedisAddr)
+		go redis.Open()
+		lobby.SetBroker(redis)
+	}
+	limiter := limiter.NewLimiter(serverConfig)
+
+	var node *cluster.Node
+	if clusterConfig != nil {
+		message.SetOrigin(clusterConfig.NodeID)
+		node = cluster.NewNode(clusterConfig)
+		go node.Open()
+		go node.Serve(clusterListener)
+		node.Connect()
+		lobby.SetCluster(node)
+	}
+
+	hooks := Chain(server.hooks...)
+	lobby.SetHooks(roomHooks(hooks))
+	hub := room.NewHub(lobby)
+
+	go accessList.Open()
+	go pipeline.Open()
+	go lobby.Open()
+	go hub.Open()
+	go limiter.Open()
+
+	// Every connection and bot reports on ended when it is done, so Serve
+	// knows when nothing uses what it started any more. stop closes the
+	// connections still logging in once the rooms have closed.
+	ended := make(chan struct{})
+	stop := make(chan struct{})
+	running := 0
+
+	for _, spec := range bots {
+		running++
+		go func() {
+			defer func() { ended <- struct{}{} }()
+			conn := hub.Attach(spec.Name, spec.Exempt)
+			defer conn.Close()
+			if err := bot.Run(conn, spec.Room, spec.Handler); err != nil {
+				log.Printf("Bot %s stopped: %v", spec.Name, err)
+			}
+		}()
+	}
+
+	var metricsServer *http.Server
+	if serverConfig.MetricsAddr != "" {
+		metricsServer = &http.Server{Addr: serverConfig.MetricsAddr}
+		go func() {
+			log.Printf("Metrics listening on %s", serverConfig.MetricsAddr)
+			if err := metricsServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Printf("Metrics server error: %v", err)
+			}
+		}()
+	}
+
+	accepted := make(chan net.Conn)
+	failed := make(chan error)
+	go accept(listener, accepted, failed)
+
+	for {
+		var conn net.Conn
+		select {
+		case conn = <-accepted:
+		case <-ended:
+			running--
+			continue
+		case err := <-failed:
+			hub.Shutdown()
+			close(stop)
+			for ; running > 0; running-- {
+				<-ended
+			}
+			hub.Close()
+			if node != nil {
+				node.Close()
+			}
+			limiter.Close()
+			pipeline.Close()
+			accessList.Close()
+			auditLog.Close()
+			if metricsServer != nil {
+				metricsServer.Close()
+			}
+			return err
+		}
+
+		if allowed, reason := accessList.Check(remoteIP(conn)); !allowed {
+			log.Printf("Denied %s: %s", conn.RemoteAddr(), reason)
+			metrics.ConnectionsDenied.Add(reason, 1)
+			auditLog.Record(audit.Record{Action: audit.ConnectionDenied, Addr: conn.RemoteAddr().String(), Detail: reason})
+			go reject(conn, "access denied")
+			continue
+		}
+
+		release, err := limiter.Acquire(conn.RemoteAddr())
+		if err != nil {
+			log.Printf("Rejected %s: %v", conn.RemoteAddr(), err)
+			metrics.ConnectionsLimited.Add(err.Error(), 1)
+			auditLog.Record(audit.Record{Action: audit.ConnectionLimited, Addr: conn.RemoteAddr().String(), Detail: err.Error()})
+			go reject(conn, err.Error())
+			continue
+		}
+		metrics.ConnectionsAccepted.Add(1)
+		running++
+		go func() {
+			defer func() { ended <- struct{}{} }()
+			defer release()
+
+			finished := make(chan struct{})
+			defer close(finished)
+			go func() {
+				select {
+				case <-stop:
+					conn.Close()
+				case <-finished:
+				}
+			}()
+
+			if hooks.OnConnect != nil {
+				if err := hooks.OnConnect(conn); err != nil {
+					reject(conn, err.Error())
+					return
+				}
+			}
+			var t transport.Transport
+			if tlsConfig != nil {
+				t = transport.TLS(conn, tlsConfig)
+			} else {
+				t = transport.Conn(conn)
+			}
+			if serverConfig.Compression {
+				t = transport.Compressible(t)
+			}
+			hub.NewSession(t)
+			if hooks.OnDisconnect != nil {
+				hooks.OnDisconnect(conn)
+			}
+		}()
+	}
+}
+
+// accept passes the connections accepted on listener to accepted until it
+// is closed, and then why to failed.
+func accept(listener net.Listener, accepted chan<- net.Conn, failed chan<- error) {
+	for {
+		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			failed <- err
+			return
+		}
+		if err != nil {
+			log.Printf("Accept error: %v", err)
+			continue
+		}
+		accepted <- conn
+	}
+}
+
+// Chain combines hooks into one, calling each in order. OnConnect and
+// OnMessage stop at the first error.
+func Chain(hooks ...Hooks) Hooks {
+	var chain Hooks
+	for _, h := range hooks {
+		if h.OnConnect != nil {
+			chain.OnConnect = chainConnect(chain.OnConnect, h.OnConnect)
+		}
+		if h.OnRegister != nil {
+			chain.OnRegister = chainSession(chain.OnRegister, h.OnRegister)
+		}
+		if h.OnUnregister != nil {
+			chain.OnUnregister = chainSession(chain.OnUnregister, h.OnUnregister)
+		}
+		if h.OnMessage != nil {
+			chain.OnMessage = chainMessage(chain.OnMessage, h.OnMessage)
+		}
+		if h.OnDisconnect != nil {
+			chain.OnDisconnect = chainDisconnect(chain.OnDisconnect, h.OnDisconnect)
+		}
+	}
+	return chain
+}
+
+func chainConnect(first, next func(net.Conn) error) func(net.Conn) error {
+	if first == nil {
+		return next
+	}
+	return func(conn net.Conn) error {
+		if err := first(conn); err != nil {
+			return err
+		}
+		return next(conn)
+	}
+}
+
+func chainSession(first, next func(*Room, *Session)) func(*Room, *Session) {
+	if first == nil {
+		return next
+	}
+	return func(r *Room, s *Session) {
+		first(r, s)
+		next(r, s)
+	}
+}
+
+func chainMessage(first, next func(*Room, *Session, *Message) error) func(*Room, *Session, *Message) error {
+	if first == nil {
+		return next
+	}
+	return func(r *Room, s *Session, m *Message) error {
+		if err := first(r, s, m); err != nil {
+			return err
+		}
+		return next(r, s, m)
+	}
+}
+
+func chainDisconnect(first, next func(net.Conn)) func(net.Conn) {
+	if first == nil {
+		return next
+	}
+	return func(conn net.Conn) {
+		first(conn)
+		next(conn)
+	}
+}
+
+// roomHooks adapts hooks to the room's internal types.
+func roomHooks(hooks Hooks) room.Hooks {
+	var adapted room.Hooks
+	if hooks.OnRegister != nil {
+		adapted.Register = func(r *room.Room, s *session.Session) {
+			hooks.OnRegister(&Room{room: r}, &Session{session: s})
+		}
+	}
+	if hooks.OnUnregister != nil {
+		adapted.Unregister = func(r *room.Room, s *session.Session) {
+			hooks.OnUnregister(&Room{room: r}, &Session{session: s})
+		}
+	}
+	if hooks.OnMessage != nil {
+		adapted.Message = func(r *room.Room, s *session.Session, m *message.Message) error {
+			public := &Message{From: m.From, Body: m.Body}
+			if err := hooks.OnMessage(&Room{room: r}, &Session{session: s}, public); err != nil {
+				return err
+			}
+			m.Body = public.Body
+			return nil
+		}
+	}
+	return adapted
+}
+
+func (r *Room) Name() string {
+	return r.room.Name()
+}
+
+// Members returns the sessions in the room.
+func (r *Room) Members() []*Session {
+	var members []*Session
+	for _, s := range r.room.Members() {
+		members = append(members, &Session{session: s})
+	}
+	return members
+}
+
+// Post sends body to everyone in the room as a message from from. It does
+// not go through the filters or OnMessage.
+func (r *Room) Post(from string, body []byte) error {
+	return r.room.Post(from, body)
+}
+
+func (s *Session) ID() string {
+	return s.session.ID
+}
+
+// Name is the identity of the session, or its ID before it has one.
+func (s *Session) Name() string {
+	return s.session.DisplayName()
+}
+
+func (s *Session) Guest() bool {
+	return s.session.Guest
+}
+
+func (s *Session) Role() string {
+	return string(s.session.Role)
+}
+
+func (s *Session) RemoteAddr() net.Addr {
+	if s.session.Transport == nil {
+		return nil
+	}
+	return s.session.Transport.RemoteAddr()
+}
+
+// Notice sends a line of server text to the session.
+func (s *Session) Notice(text string) {
+	s.session.Notice(text)
+}
+
+// Disconnect closes the session after telling it why.
+func (s *Session) Disconnect(reason string) {
+	s.session.Disconnect(reason)
+}
+
+// reject tells a refused client why and closes the connection. The accept
+// loop runs it in its own goroutine, so a client that does not read cannot
+// hold up the next accept.
+func reject(conn net.Conn, reason string) {
+	conn.SetWriteDeadline(time.Now().Add(time.Second))
+	conn.Write([]byte("Connection refused: " + reason + "\n"))
+	conn.Close()
+}
+
+func remoteIP(conn net.Conn) net.IP {
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		return addr.IP
+	}
+	return nil
+}
+
+func loadMOTD(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("MOTD disabled: %v", err)
+		}
+		return ""
+	}
+	return strings.TrimRight(string(data), "\r\n")
+}
@@ -0,0 +1,35 @@
+// Command auditcheck verifies the hash chain of an audit log and prints the
+// hash of its last record, which can be kept elsewhere to detect truncation.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/Arun445/tcp-go/internal/audit"
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "Usage: auditcheck <audit file>")
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	file, err := os.Open(flag.Arg(0))
+	if err != nil {
+		log.Fatalf("Failed to open audit log: %v", err)
+	}
+	defer file.Close()
+
+	last, count, err := audit.Verify(file)
+	if err != nil {
+		log.Fatalf("Audit log is not intact after %d records: %v", count, err)
+	}
+	fmt.Printf("%d records ok, last hash %s\n", count, last)
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// prefix marks benchmark messages, which carry their send time so receivers
+// can measure fan-out latency.
+const prefix = "bench "
+
+type Options struct {
+	Addr    string
+	Clients int
+	// Rate is messages per second per client, Size the bytes per message.
+	Rate     float64
+	Size     int
+	Duration time.Duration
+	// Drain is how long clients keep reading after they stop sending.
+	Drain       time.Duration
+	DialTimeout time.Duration
+	// Guest logs every client in as a guest named bench-<n> before it sends,
+	// for servers that require a login.
+	Guest bool
+}
+
+type Report struct {
+	Clients       int       `json:"clients"`
+	Connected     int       `json:"connected"`
+	DialErrors    int       `json:"dial_errors"`
+	Refused       int       `json:"refused"`
+	LoginErrors   int       `json:"login_errors"`
+	Seconds       float64   `json:"seconds"`
+	Sent          int       `json:"sent"`
+	Received      int       `json:"received"`
+	SentPerSec    float64   `json:"sent_per_sec"`
+	RecvPerSec    float64   `json:"received_per_sec"`
+	BytesReceived int64     `json:"bytes_received"`
+	BytesPerSec   float64   `json:"bytes_per_sec"`
+	Disconnects   int       `json:"disconnects"`
+	LimitHits     int       `json:"limit_hits"`
+	Latency       Latencies `json:"latency_ms"`
+}
+
+// Latencies are fan-out latencies in milliseconds.
+type Latencies struct {
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	P99 float64 `json:"p99"`
+	Max float64 `json:"max"`
+}
+
+// result is what one client saw.
+type result struct {
+	dialed bool
+	// refused is set when the server turned the connection away, login when
+	// it asked for a login and welcomed once it accepted one.
+	refused      bool
+	login        bool
+	welcomed     bool
+	sent         int
+	received     int
+	bytes        int64
+	disconnected bool
+	limitHit     bool
+	latencies    []time.Duration
+}
+
+// Run connects the clients, lets them send for the configured duration and
+// collects what they received.
+func Run(options Options) Report {
+	results := make(chan result)
+	start := time.Now()
+	stop := start.Add(options.Duration)
+	for i := 0; i < options.Clients; i++ {
+		go func() {
+			results <- runClient(options, i, stop)
+		}()
+	}
+
+	report := Report{Clients: options.Clients}
+	var latencies []time.Duration
+	for i := 0; i < options.Clients; i++ {
+		r := <-results
+		switch {
+		case !r.dialed:
+			report.DialErrors++
+			continue
+		case r.refused:
+			report.Refused++
+			continue
+		case r.login && !r.welcomed:
+			report.LoginErrors++
+			continue
+		}
+		report.Connected++
+		report.Sent += r.sent
+		report.Received += r.received
+		report.BytesReceived += r.bytes
+		if r.disconnected {
+			report.Disconnects++
+		}
+		if r.limitHit {
+			report.LimitHits++
+		}
+		latencies = append(latencies, r.latencies...)
+	}
+
+	report.Seconds = options.Duration.Seconds()
+	if report.Seconds > 0 {
+		report.SentPerSec = float64(report.Sent) / report.Seconds
+		report.RecvPerSec = float64(report.Received) / report.Seconds
+		report.BytesPerSec = float64(report.BytesReceived) / report.Seconds
+	}
+	report.Latency = percentiles(latencies)
+	return report
+}
+
+func runClient(options Options, index int, stop time.Time) result {
+	conn, err := net.DialTimeout("tcp", options.Addr, options.DialTimeout)
+	if err != nil {
+		return result{}
+	}
+	defer conn.Close()
+
+	read := make(chan result, 1)
+	welcome := make(chan struct{})
+	go func() {
+		read <- readClient(conn, welcome)
+	}()
+
+	if options.Guest {
+		if _, err := fmt.Fprintf(conn, "GUEST bench-%d\n", index); err != nil {
+			return result{dialed: true, login: true}
+		}
+		timeout := time.NewTimer(options.DialTimeout)
+		defer timeout.Stop()
+		select {
+		case <-welcome:
+		case r := <-read:
+			r.dialed, r.login = true, true
+			return r
+		case <-timeout.C:
+			conn.Close()
+			r := <-read
+			r.dialed, r.login = true, true
+			return r
+		}
+	}
+
+	sent := 0
+	interval := time.Duration(float64(time.Second) / options.Rate)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	deadline := time.NewTimer(time.Until(stop))
+	defer deadline.Stop()
+
+	for sending := true; sending; {
+		select {
+		case <-ticker.C:
+			if _, err := conn.Write(payload(options.Size)); err != nil {
+				sending = false
+				continue
+			}
+			sent++
+		case <-deadline.C:
+			sending = false
+		case r := <-read:
+			// The server closed the connection while we were sending.
+			r.dialed, r.sent, r.disconnected = true, sent, !r.refused
+			return r
+		}
+	}
+
+	drain := time.NewTimer(options.Drain)
+	defer drain.Stop()
+	select {
+	case r := <-read:
+		r.dialed, r.sent, r.disconnected = true, sent, !r.refused
+		return r
+	case <-drain.C:
+	}
+
+	conn.Close()
+	r := <-read
+	r.dialed, r.sent = true, sent
+	return r
+}
+
+// readClient reads until the connection fails, counting benchmark messages
+// and noting when the server refuses the connection, reports a limit or
+// welcomes the client after its login.
+func readClient(conn net.Conn, welcome chan<- struct{}) result {
+	var r result
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		r.bytes += int64(len(line) + 1)
+		if strings.HasPrefix(line, "Connection refused: ") {
+			r.refused = true
+			continue
+		}
+		if strings.HasPrefix(line, "Login with: ") {
+			r.login = true
+			continue
+		}
+		if strings.HasPrefix(line, "Welcome ") && !r.welcomed {
+			r.welcomed = true
+			close(welcome)
+			continue
+		}
+		if strings.Contains(line, "limit reached") {
+			r.limitHit = true
+			continue
+		}
+		sentAt, ok := parse(line)
+		if !ok {
+			continue
+		}
+		r.received++
+		r.latencies = append(r.latencies, time.Since(sentAt))
+	}
+	return r
+}
+
+// payload builds a message of size bytes, including the newline, that starts
+// with the send time.
+func payload(size int) []byte {
+	line := prefix + strconv.FormatInt(time.Now().UnixNano(), 10) + " "
+	if pad := size - len(line) - 1; pad > 0 {
+		line += strings.Repeat("x", pad)
+	}
+	return []byte(line + "\n")
+}
+
+func parse(line string) (time.Time, bool) {
+	rest, ok := strings.CutPrefix(line, prefix)
+	if !ok {
+		return time.Time{}, false
+	}
+	stamp, _, _ := strings.Cut(rest, " ")
+	nanos, err := strconv.ParseInt(stamp, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, nanos), true
+}
+
+func percentiles(latencies []time.Duration) Latencies {
+	if len(latencies) == 0 {
+		return Latencies{}
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	at := func(p float64) float64 {
+		i := int(p * float64(len(latencies)-1))
+		return milliseconds(latencies[i])
+	}
+	return Latencies{P50: at(0.50), P90: at(0.90), P99: at(0.99), Max: milliseconds(latencies[len(latencies)-1])}
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func (report Report) String() string {
+	var out strings.Builder
+	fmt.Fprintf(&out, "Clients:     %d connected, %d failed to connect, %d refused, %d failed to log in\n",
+		report.Connected, report.DialErrors, report.Refused, report.LoginErrors)
+	fmt.Fprintf(&out, "Duration:    %.1fs\n", report.Seconds)
+	fmt.Fprintf(&out, "Sent:        %d messages (%.1f/s)\n", report.Sent, report.SentPerSec)
+	fmt.Fprintf(&out, "Received:    %d messages (%.1f/s), %.1f KB/s\n", report.Received, report.RecvPerSec, report.BytesPerSec/1024)
+	fmt.Fprintf(&out, "Latency:     p50 %.2fms  p90 %.2fms  p99 %.2fms  max %.2fms\n",
+		report.Latency.P50, report.Latency.P90, report.Latency.P99, report.Latency.Max)
+	fmt.Fprintf(&out, "Disconnects: %d\n", report.Disconnects)
+	fmt.Fprintf(&out, "Limit hits:  %d\n", report.LimitHits)
+	return out.String()
+}
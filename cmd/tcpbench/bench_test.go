@@ -0,0 +1,154 @@
+package main
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Arun445/tcp-go/internal/config"
+	"github.com/Arun445/tcp-go/internal/room"
+)
+
+func startServer(t *testing.T, byteLimit int) string {
+	t.Helper()
+	return serve(t, &config.RoomConfig{Name: "lobby", ByteLimit: byteLimit})
+}
+
+func serve(t *testing.T, roomConfig *config.RoomConfig) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	lobby := room.NewRoom(roomConfig)
+	go lobby.Open()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go lobby.NewSession(conn)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestRun(t *testing.T) {
+	addr := startServer(t, 1<<20)
+
+	report := Run(Options{
+		Addr:        addr,
+		Clients:     5,
+		Rate:        20,
+		Size:        64,
+		Duration:    300 * time.Millisecond,
+		Drain:       200 * time.Millisecond,
+		DialTimeout: time.Second,
+	})
+
+	if report.Connected != 5 || report.DialErrors != 0 {
+		t.Fatalf("Expected 5 connected clients, got %+v", report)
+	}
+	if report.Sent == 0 {
+		t.Fatal("Expected messages to be sent")
+	}
+	if report.Received != report.Sent*4 {
+		t.Errorf("Expected every message to reach the other 4 clients, sent %d received %d", report.Sent, report.Received)
+	}
+	if report.Disconnects != 0 || report.LimitHits != 0 {
+		t.Errorf("Expected no disconnects, got %+v", report)
+	}
+	if report.Latency.Max <= 0 || report.Latency.P50 > report.Latency.Max {
+		t.Errorf("Expected latency percentiles, got %+v", report.Latency)
+	}
+}
+
+func TestRun_LimitHits(t *testing.T) {
+	addr := startServer(t, 300)
+
+	report := Run(Options{
+		Addr:        addr,
+		Clients:     3,
+		Rate:        50,
+		Size:        64,
+		Duration:    300 * time.Millisecond,
+		Drain:       100 * time.Millisecond,
+		DialTimeout: time.Second,
+	})
+
+	if report.LimitHits == 0 || report.Disconnects == 0 {
+		t.Errorf("Expected byte limits to disconnect clients, got %+v", report)
+	}
+}
+
+func TestRun_Guest(t *testing.T) {
+	addr := serve(t, &config.RoomConfig{
+		Name:      "lobby",
+		ByteLimit: 1 << 20,
+		Auth:      &config.AuthConfig{Mode: config.AuthGuest, UsersFile: filepath.Join(t.TempDir(), "users.json")},
+	})
+
+	options := Options{
+		Addr:        addr,
+		Clients:     3,
+		Rate:        20,
+		Size:        64,
+		Duration:    200 * time.Millisecond,
+		Drain:       200 * time.Millisecond,
+		DialTimeout: time.Second,
+	}
+	if report := Run(options); report.LoginErrors != 3 || report.Connected != 0 {
+		t.Errorf("Expected clients without a login to fail, got %+v", report)
+	}
+
+	options.Guest = true
+	report := Run(options)
+	if report.Connected != 3 || report.Sent == 0 || report.Received != report.Sent*2 {
+		t.Errorf("Expected guests to exchange messages, got %+v", report)
+	}
+}
+
+func TestRun_Refused(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte("Connection refused: too many connections from your address\n"))
+			conn.Close()
+		}
+	}()
+
+	report := Run(Options{
+		Addr:        listener.Addr().String(),
+		Clients:     2,
+		Rate:        20,
+		Size:        64,
+		Duration:    100 * time.Millisecond,
+		Drain:       100 * time.Millisecond,
+		DialTimeout: time.Second,
+	})
+	if report.Refused != 2 || report.Connected != 0 || report.Disconnects != 0 {
+		t.Errorf("Expected refused clients to be counted apart, got %+v", report)
+	}
+}
+
+func TestPayload(t *testing.T) {
+	line := payload(64)
+	if len(line) != 64 || line[len(line)-1] != '\n' {
+		t.Fatalf("Expected a 64 byte line, got %q", line)
+	}
+	if _, ok := parse(string(line[:len(line)-1])); !ok {
+		t.Error("Expected payload to carry its send time")
+	}
+}
@@ -0,0 +1,41 @@
+// Command tcpbench opens many clients against a running server, has each of
+// them post at a fixed rate and reports fan-out latency and throughput.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+func main() {
+	options := Options{}
+	flag.StringVar(&options.Addr, "addr", "localhost:9000", "server address")
+	flag.IntVar(&options.Clients, "clients", 50, "concurrent client connections")
+	flag.Float64Var(&options.Rate, "rate", 1, "messages per second per client")
+	flag.IntVar(&options.Size, "size", 64, "bytes per message")
+	flag.DurationVar(&options.Duration, "duration", 10*time.Second, "how long clients send")
+	flag.DurationVar(&options.Drain, "drain", 2*time.Second, "how long clients keep reading after sending stops")
+	flag.DurationVar(&options.DialTimeout, "dial-timeout", 5*time.Second, "timeout for each connection")
+	flag.BoolVar(&options.Guest, "guest", false, "log every client in as a guest before it sends")
+	asJSON := flag.Bool("json", false, "print the report as JSON")
+	flag.Parse()
+
+	if options.Clients <= 0 || options.Rate <= 0 || options.Duration <= 0 {
+		log.Fatal("clients, rate and duration must be positive")
+	}
+
+	report := Run(options)
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			log.Fatalf("Failed to write report: %v", err)
+		}
+		return
+	}
+	fmt.Print(report)
+}
@@ -0,0 +1,112 @@
+package acl
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Arun445/tcp-go/internal/clock"
+)
+
+func TestList_DenyAndAllow(t *testing.T) {
+	list, err := Load(filepath.Join(t.TempDir(), "acl.json"))
+	if err != nil {
+		t.Fatalf("Failed to load list: %v", err)
+	}
+	go list.Open()
+
+	if allowed, _ := list.Check(net.ParseIP("10.0.0.1")); !allowed {
+		t.Error("Expected empty list to allow everything")
+	}
+
+	deny, _ := NewRule(Deny, "10.1.0.0/16", nil)
+	list.Add(deny)
+	allowed, reason := list.Check(net.ParseIP("10.1.2.3"))
+	if allowed || !strings.Contains(reason, "10.1.0.0/16") {
+		t.Errorf("Expected deny rule to match, got %v %s", allowed, reason)
+	}
+
+	office, _ := NewRule(Allow, "10.0.0.0/8", nil)
+	list.Add(office)
+	if allowed, _ := list.Check(net.ParseIP("10.2.0.1")); !allowed {
+		t.Error("Expected office address to be allowed")
+	}
+	if allowed, _ := list.Check(net.ParseIP("10.1.2.3")); allowed {
+		t.Error("Expected deny rule to win over allow rule")
+	}
+	if allowed, reason := list.Check(net.ParseIP("192.168.0.1")); allowed || reason != "not in allow list" {
+		t.Errorf("Expected address outside allow list to be denied, got %v %s", allowed, reason)
+	}
+
+	v6, _ := NewRule(Allow, "2001:db8::/32", nil)
+	list.Add(v6)
+	if allowed, _ := list.Check(net.ParseIP("2001:db8::1")); !allowed {
+		t.Error("Expected IPv6 address to be allowed")
+	}
+}
+
+func TestList_Expiry(t *testing.T) {
+	fake := clock.NewFake(time.Date(2024, time.January, 1, 9, 0, 0, 0, time.UTC))
+	list, _ := Load("")
+	list.SetClock(fake)
+	go list.Open()
+
+	expires := fake.Now().Add(time.Minute)
+	rule, _ := NewRule(Deny, "10.0.0.1", &expires)
+	list.Add(rule)
+
+	if allowed, _ := list.Check(net.ParseIP("10.0.0.1")); allowed {
+		t.Error("Expected address to be denied before expiry")
+	}
+	fake.Advance(time.Minute)
+	if allowed, _ := list.Check(net.ParseIP("10.0.0.1")); !allowed {
+		t.Error("Expected address to be allowed after expiry")
+	}
+	if len(list.Rules()) != 0 {
+		t.Error("Expected expired rule to be pruned")
+	}
+}
+
+func TestList_Persisted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "acl.json")
+	list, _ := Load(path)
+	go list.Open()
+
+	rule, _ := NewRule(Deny, "192.168.1.0/24", nil)
+	list.Add(rule)
+
+	reloaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("Failed to reload list: %v", err)
+	}
+	go reloaded.Open()
+	if allowed, _ := reloaded.Check(net.ParseIP("192.168.1.5")); allowed {
+		t.Error("Expected persisted deny rule to apply")
+	}
+
+	if found, _ := reloaded.Remove("192.168.1.0/24"); !found {
+		t.Error("Expected rule to be removed")
+	}
+	if allowed, _ := reloaded.Check(net.ParseIP("192.168.1.5")); !allowed {
+		t.Error("Expected address to be allowed after removal")
+	}
+}
+
+func TestList_SaveFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "acl.json")
+	// The temporary file cannot be written over a directory.
+	os.Mkdir(path+".tmp", 0o755)
+	list, _ := Load(path)
+	go list.Open()
+
+	rule, _ := NewRule(Deny, "192.168.1.0/24", nil)
+	if err := list.Add(rule); err == nil {
+		t.Fatal("Expected the failed save to be reported")
+	}
+	if allowed, _ := list.Check(net.ParseIP("192.168.1.5")); !allowed {
+		t.Error("Expected a rule that was not saved to be dropped")
+	}
+}
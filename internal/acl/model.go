@@ -0,0 +1,58 @@
+package acl
+
+import (
+	"net"
+	"time"
+
+	"github.com/Arun445/tcp-go/internal/clock"
+)
+
+type Action string
+
+const (
+	Allow Action = "allow"
+	Deny  Action = "deny"
+)
+
+type Rule struct {
+	Action  Action     `json:"action"`
+	CIDR    string     `json:"cidr"`
+	Expires *time.Time `json:"expires,omitempty"`
+
+	network *net.IPNet
+}
+
+// List holds allow and deny rules. Deny rules win; when any allow rule is
+// active, addresses outside of every allow rule are denied. State is only
+// touched by the Open goroutine.
+type List struct {
+	path     string
+	rules    []Rule
+	requests chan request
+	stop     chan struct{}
+	clock    clock.Clock
+}
+
+type requestType int
+
+const (
+	check requestType = iota
+	add
+	remove
+	snapshot
+)
+
+type request struct {
+	Type  requestType
+	IP    net.IP
+	Rule  Rule
+	Reply chan response
+}
+
+type response struct {
+	Allowed bool
+	Reason  string
+	Found   bool
+	Rules   []Rule
+	Err     error
+}
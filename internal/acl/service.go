@@ -0,0 +1,211 @@
+package acl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Arun445/tcp-go/internal/clock"
+)
+
+// Load reads rules from path. A missing file yields an empty list that is
+// created on the first change.
+func Load(path string) (*List, error) {
+	list := &List{
+		path:     path,
+		requests: make(chan request),
+		stop:     make(chan struct{}),
+		clock:    clock.Real(),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return list, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []Rule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, err
+	}
+	for _, rule := range rules {
+		parsed, err := NewRule(rule.Action, rule.CIDR, rule.Expires)
+		if err != nil {
+			return nil, err
+		}
+		list.rules = append(list.rules, parsed)
+	}
+	return list, nil
+}
+
+// NewRule validates a rule. A bare address is treated as a single host.
+func NewRule(action Action, cidr string, expires *time.Time) (Rule, error) {
+	if action != Allow && action != Deny {
+		return Rule{}, fmt.Errorf("unknown action %q", action)
+	}
+	if !strings.Contains(cidr, "/") {
+		if ip := net.ParseIP(cidr); ip != nil && ip.To4() != nil {
+			cidr += "/32"
+		} else {
+			cidr += "/128"
+		}
+	}
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return Rule{}, err
+	}
+	return Rule{Action: action, CIDR: network.String(), Expires: expires, network: network}, nil
+}
+
+// SetClock replaces the system clock that expires rules. It must be called
+// before Open.
+func (list *List) SetClock(c clock.Clock) {
+	list.clock = c
+}
+
+func (list *List) Open() {
+	for {
+		select {
+		case req := <-list.requests:
+			list.prune()
+
+			switch req.Type {
+			case check:
+				allowed, reason := list.evaluate(req.IP)
+				req.Reply <- response{Allowed: allowed, Reason: reason}
+
+			case add:
+				previous := append([]Rule(nil), list.rules...)
+				list.drop(req.Rule.CIDR)
+				list.rules = append(list.rules, req.Rule)
+				err := list.save()
+				if err != nil {
+					list.rules = previous
+				}
+				req.Reply <- response{Err: err}
+
+			case remove:
+				previous := append([]Rule(nil), list.rules...)
+				found := list.drop(req.Rule.CIDR)
+				var err error
+				if found {
+					err = list.save()
+				}
+				if err != nil {
+					list.rules = previous
+				}
+				req.Reply <- response{Found: found, Err: err}
+
+			case snapshot:
+				rules := make([]Rule, len(list.rules))
+				copy(rules, list.rules)
+				req.Reply <- response{Rules: rules}
+			}
+
+		case <-list.stop:
+			return
+		}
+	}
+}
+
+// Close stops Open once nothing checks or changes the list any more.
+func (list *List) Close() {
+	close(list.stop)
+}
+
+// Check reports whether ip may connect and, if not, why.
+func (list *List) Check(ip net.IP) (bool, string) {
+	resp := list.do(request{Type: check, IP: ip})
+	return resp.Allowed, resp.Reason
+}
+
+// Add adds rule, replacing any rule for the same CIDR. When the list cannot
+// be saved it is left unchanged.
+func (list *List) Add(rule Rule) error {
+	return list.do(request{Type: add, Rule: rule}).Err
+}
+
+func (list *List) Remove(cidr string) (bool, error) {
+	rule, err := NewRule(Deny, cidr, nil)
+	if err != nil {
+		return false, err
+	}
+	resp := list.do(request{Type: remove, Rule: rule})
+	return resp.Found, resp.Err
+}
+
+func (list *List) Rules() []Rule {
+	return list.do(request{Type: snapshot}).Rules
+}
+
+func (list *List) do(req request) response {
+	req.Reply = make(chan response, 1)
+	list.requests <- req
+	return <-req.Reply
+}
+
+func (list *List) evaluate(ip net.IP) (bool, string) {
+	hasAllow := false
+	allowed := false
+	for _, rule := range list.rules {
+		if !rule.network.Contains(ip) {
+			hasAllow = hasAllow || rule.Action == Allow
+			continue
+		}
+		if rule.Action == Deny {
+			return false, "denied by rule " + rule.CIDR
+		}
+		hasAllow = true
+		allowed = true
+	}
+	if hasAllow && !allowed {
+		return false, "not in allow list"
+	}
+	return true, ""
+}
+
+func (list *List) prune() {
+	now := list.clock.Now()
+	active := list.rules[:0]
+	for _, rule := range list.rules {
+		if rule.Expires == nil || rule.Expires.After(now) {
+			active = append(active, rule)
+		}
+	}
+	list.rules = active
+}
+
+func (list *List) drop(cidr string) bool {
+	for i, rule := range list.rules {
+		if rule.CIDR == cidr {
+			list.rules = append(list.rules[:i], list.rules[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (list *List) save() error {
+	if list.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(list.rules, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(list.path), 0o755); err != nil {
+		return err
+	}
+	tmp := list.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, list.path)
+}
@@ -0,0 +1,65 @@
+package audit
+
+import (
+	"os"
+	"time"
+
+	"github.com/Arun445/tcp-go/internal/clock"
+)
+
+// Action is what an audit record is about.
+type Action string
+
+const (
+	Login       Action = "login"
+	LoginFailed Action = "login_failed"
+	Kick        Action = "kick"
+	// Ban adds a deny rule to the access list, ACLAllow an allow rule.
+	Ban             Action = "ban"
+	ACLAllow        Action = "acl_allow"
+	ACLRemove       Action = "acl_remove"
+	RoleChange      Action = "role_change"
+	RoomCreate      Action = "room_create"
+	RoomConfig      Action = "room_config"
+	LimitDisconnect Action = "limit_disconnect"
+	// ConnectionDenied is a connection the access list turned away,
+	// ConnectionLimited one over the connection limits.
+	ConnectionDenied  Action = "connection_denied"
+	ConnectionLimited Action = "connection_limited"
+)
+
+// Record is one line of the audit log: Actor did Action to Target at Time.
+// Actor is empty for what the server did on its own.
+type Record struct {
+	Time    time.Time `json:"time"`
+	Action  Action    `json:"action"`
+	Actor   string    `json:"actor,omitempty"`
+	Target  string    `json:"target,omitempty"`
+	Room    string    `json:"room,omitempty"`
+	Session string    `json:"session,omitempty"`
+	Addr    string    `json:"addr,omitempty"`
+	Detail  string    `json:"detail,omitempty"`
+	// Prev is the hash of the record before, empty for the first one. Hash
+	// is the SHA-256 of the record encoded without it, so changing or
+	// removing a line breaks the chain from there on.
+	Prev string `json:"prev"`
+	Hash string `json:"hash,omitempty"`
+}
+
+// Log appends records to a file. The file and the hash of the last record
+// are only touched by the Open goroutine, which writes records in the order
+// they were queued. A nil Log records nothing.
+type Log struct {
+	file     *os.File
+	last     string
+	clock    clock.Clock
+	requests chan request
+	stop     chan struct{}
+}
+
+// request queues Record, or with Flushed set, asks to be told once every
+// record queued before it is on disk.
+type request struct {
+	Record  Record
+	Flushed chan struct{}
+}
@@ -0,0 +1,195 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/Arun445/tcp-go/internal/clock"
+)
+
+const (
+	// maxLine bounds a record when reading the log back.
+	maxLine = 64 * 1024
+	// queue is how many records may wait to be written before Record blocks.
+	queue = 1024
+)
+
+// Load verifies the log at path and opens it for appending, so new records
+// continue its chain. A missing file starts a new chain. A last line cut
+// short by a crash is dropped with a warning; any other broken chain is an
+// error, and the file has to be moved aside to start over.
+func Load(path string) (*Log, error) {
+	if err := truncateTorn(path); err != nil {
+		return nil, fmt.Errorf("repair %s: %w", path, err)
+	}
+	existing, err := os.Open(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	last := ""
+	if existing != nil {
+		last, _, err = Verify(existing)
+		existing.Close()
+		if err != nil {
+			return nil, fmt.Errorf("verify %s: %w", path, err)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &Log{
+		file:     file,
+		last:     last,
+		clock:    clock.Real(),
+		requests: make(chan request, queue),
+		stop:     make(chan struct{}),
+	}, nil
+}
+
+// truncateTorn cuts a last line that does not end in a newline off the file
+// at path. Records are written with their newline in one call, so such a
+// line is the start of a record the server did not finish writing.
+func truncateTorn(path string) error {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+	tail := make([]byte, min(size, maxLine))
+	if _, err := file.ReadAt(tail, size-int64(len(tail))); err != nil {
+		return err
+	}
+	if len(tail) == 0 || tail[len(tail)-1] == '\n' {
+		return nil
+	}
+	end := bytes.LastIndexByte(tail, '\n')
+	if end < 0 && int64(len(tail)) < size {
+		return fmt.Errorf("last line is longer than %d bytes", maxLine)
+	}
+	keep := size - int64(len(tail)) + int64(end+1)
+	log.Printf("Audit log %s ends in an unfinished record, dropping its last %d bytes", path, size-keep)
+	return file.Truncate(keep)
+}
+
+// SetClock replaces the system clock. It must be called before Open.
+func (l *Log) SetClock(c clock.Clock) {
+	l.clock = c
+}
+
+func (l *Log) Open() {
+	for {
+		select {
+		case req := <-l.requests:
+			if req.Flushed != nil {
+				close(req.Flushed)
+				continue
+			}
+			req.Record.Prev = l.last
+			req.Record.Hash = hash(req.Record)
+			if err := l.write(req.Record); err != nil {
+				log.Printf("Failed to write audit record %s: %v", req.Record.Action, err)
+			} else {
+				l.last = req.Record.Hash
+			}
+
+		case <-l.stop:
+			l.file.Close()
+			return
+		}
+	}
+}
+
+// Record stamps r with the current time and queues it for writing, so the
+// caller does not wait for the disk. It only blocks when the queue is full.
+func (l *Log) Record(r Record) {
+	if l == nil {
+		return
+	}
+	r.Time = l.clock.Now().UTC()
+	l.requests <- request{Record: r}
+}
+
+// Flush returns once every record queued before it is on disk.
+func (l *Log) Flush() {
+	if l == nil {
+		return
+	}
+	flushed := make(chan struct{})
+	l.requests <- request{Flushed: flushed}
+	<-flushed
+}
+
+// Close writes the queued records, stops Open and closes the file. Nothing
+// may be recorded after.
+func (l *Log) Close() {
+	if l == nil {
+		return
+	}
+	l.Flush()
+	close(l.stop)
+}
+
+func (l *Log) write(r Record) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if _, err := l.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return l.file.Sync()
+}
+
+// Verify checks the chain of the log read from r, returning the hash of its
+// last record and how many records it holds.
+func Verify(r io.Reader) (string, int, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), maxLine)
+
+	last, count := "", 0
+	for scanner.Scan() {
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return last, count, fmt.Errorf("line %d: %w", count+1, err)
+		}
+		if record.Prev != last {
+			return last, count, fmt.Errorf("line %d: chain broken, previous record was changed or removed", count+1)
+		}
+		if hash(record) != record.Hash {
+			return last, count, fmt.Errorf("line %d: hash mismatch, record was changed", count+1)
+		}
+		last = record.Hash
+		count++
+	}
+	return last, count, scanner.Err()
+}
+
+func hash(r Record) string {
+	r.Hash = ""
+	data, _ := json.Marshal(r)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
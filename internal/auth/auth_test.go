@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Arun445/tcp-go/internal/config"
+)
+
+func TestPBKDF2_RFC7914Vector(t *testing.T) {
+	expected := "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc" +
+		"49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"
+
+	key := pbkdf2([]byte("passwd"), []byte("salt"), 1, 64)
+	if hex.EncodeToString(key) != expected {
+		t.Errorf("Unexpected key: %x", key)
+	}
+}
+
+func newTestService(t *testing.T, maxFailures int) *Service {
+	service, err := NewService(&config.AuthConfig{
+		Mode:        config.AuthRequired,
+		UsersFile:   filepath.Join(t.TempDir(), "users.json"),
+		MaxFailures: maxFailures,
+		Lockout:     time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create service: %v", err)
+	}
+	go service.Open()
+	return service
+}
+
+func TestService_RegisterLogin(t *testing.T) {
+	service := newTestService(t, 5)
+
+	if err := service.Register("alice", "secret"); err != nil {
+		t.Fatalf("Failed to register: %v", err)
+	}
+	if err := service.Register("alice", "other"); !errors.Is(err, ErrUserExists) {
+		t.Errorf("Expected ErrUserExists, got %v", err)
+	}
+	if err := service.Login("10.0.0.1", "alice", "secret"); err != nil {
+		t.Errorf("Expected login to succeed, got %v", err)
+	}
+	if err := service.Login("10.0.0.1", "alice", "wrong"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Expected ErrInvalidCredentials, got %v", err)
+	}
+	if err := service.Login("10.0.0.1", "nobody", "secret"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Expected ErrInvalidCredentials for unknown user, got %v", err)
+	}
+}
+
+func TestService_Persisted(t *testing.T) {
+	authConfig := &config.AuthConfig{UsersFile: filepath.Join(t.TempDir(), "users.json")}
+	service, _ := NewService(authConfig)
+	go service.Open()
+	service.Register("alice", "secret")
+
+	reloaded, err := NewService(authConfig)
+	if err != nil {
+		t.Fatalf("Failed to reload service: %v", err)
+	}
+	go reloaded.Open()
+	if err := reloaded.Login("10.0.0.1", "alice", "secret"); err != nil {
+		t.Errorf("Expected login after reload to succeed, got %v", err)
+	}
+}
+
+func TestService_Lockout(t *testing.T) {
+	service := newTestService(t, 3)
+	service.Register("alice", "secret")
+
+	// Guessing at different accounts counts against the same address.
+	service.Login("10.0.0.1", "alice", "wrong")
+	service.Login("10.0.0.1", "bob", "wrong")
+	service.Login("10.0.0.1", "carol", "wrong")
+
+	if err := service.Login("10.0.0.1", "alice", "secret"); !errors.Is(err, ErrLocked) {
+		t.Errorf("Expected ErrLocked, got %v", err)
+	}
+	if err := service.Login("10.0.0.2", "alice", "secret"); err != nil {
+		t.Errorf("Expected alice to log in from another address, got %v", err)
+	}
+	if !service.Exists("alice") {
+		t.Error("Expected account to still exist")
+	}
+}
+
+func TestService_FailuresBounded(t *testing.T) {
+	service := newTestService(t, 3)
+	now := time.Now()
+	for i := 0; i < maxSources+10; i++ {
+		service.fail(fmt.Sprintf("10.%d.%d.%d", i>>16&255, i>>8&255, i&255), now)
+	}
+	if len(service.failures) > maxSources {
+		t.Errorf("Expected at most %d tracked sources, got %d", maxSources, len(service.failures))
+	}
+}
@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"errors"
+	"time"
+
+	"github.com/Arun445/tcp-go/internal/config"
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrLocked             = errors.New("too many failed logins")
+	ErrUserExists         = errors.New("user already exists")
+)
+
+type User struct {
+	Name       string `json:"name"`
+	Salt       []byte `json:"salt"`
+	Hash       []byte `json:"hash"`
+	Iterations int    `json:"iterations"`
+}
+
+// Service owns the user store and failure counters. All state is only
+// touched by the Open goroutine; callers talk to it through requests.
+// Failures are counted per source address, so guessing at many accounts
+// from one address is throttled and nobody can lock someone else out.
+type Service struct {
+	config   *config.AuthConfig
+	requests chan request
+	stop     chan struct{}
+	users    map[string]User
+	failures map[string]*failure
+	// dummy is hashed against for unknown names, so a failed login takes
+	// as long whether the account exists or not.
+	dummy User
+}
+
+type failure struct {
+	Count       int
+	Last        time.Time
+	LockedUntil time.Time
+}
+
+type requestType int
+
+const (
+	lookup requestType = iota
+	fail
+	succeed
+	create
+)
+
+type request struct {
+	Type   requestType
+	Source string
+	Name   string
+	User   User
+	Reply  chan response
+}
+
+type response struct {
+	User  User
+	Found bool
+	Err   error
+}
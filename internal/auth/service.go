@@ -0,0 +1,193 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Arun445/tcp-go/internal/config"
+)
+
+// maxSources bounds how many source addresses the failure counters track.
+const maxSources = 10_000
+
+func NewService(authConfig *config.AuthConfig) (*Service, error) {
+	service := &Service{
+		config:   authConfig,
+		requests: make(chan request),
+		stop:     make(chan struct{}),
+		users:    make(map[string]User),
+		failures: make(map[string]*failure),
+		dummy:    User{Salt: make([]byte, saltSize), Hash: make([]byte, keySize), Iterations: iterations},
+	}
+
+	data, err := os.ReadFile(authConfig.UsersFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return service, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var users []User
+	if err := json.Unmarshal(data, &users); err != nil {
+		return nil, err
+	}
+	for _, user := range users {
+		service.users[user.Name] = user
+	}
+	return service, nil
+}
+
+func (service *Service) Open() {
+	for {
+		select {
+		case req := <-service.requests:
+			switch req.Type {
+			case lookup:
+				user, ok := service.users[req.Name]
+				resp := response{User: user, Found: ok}
+				if f, locked := service.failures[req.Source]; locked && time.Now().Before(f.LockedUntil) {
+					resp.Err = ErrLocked
+				}
+				req.Reply <- resp
+
+			case fail:
+				service.fail(req.Source, time.Now())
+				req.Reply <- response{}
+
+			case succeed:
+				delete(service.failures, req.Source)
+				req.Reply <- response{}
+
+			case create:
+				if _, ok := service.users[req.User.Name]; ok {
+					req.Reply <- response{Err: ErrUserExists}
+					continue
+				}
+				service.users[req.User.Name] = req.User
+				if err := service.save(); err != nil {
+					delete(service.users, req.User.Name)
+					req.Reply <- response{Err: err}
+					continue
+				}
+				req.Reply <- response{}
+			}
+
+		case <-service.stop:
+			return
+		}
+	}
+}
+
+// Close stops Open once no session logs in any more.
+func (service *Service) Close() {
+	close(service.stop)
+}
+
+// Login checks a password for the named account on behalf of source, the
+// address the attempt came from.
+func (service *Service) Login(source, name, password string) error {
+	resp := service.do(request{Type: lookup, Source: source, Name: name})
+	if resp.Err != nil {
+		return resp.Err
+	}
+
+	user := resp.User
+	if !resp.Found {
+		user = service.dummy
+	}
+	if !user.Verify(password) || !resp.Found {
+		service.do(request{Type: fail, Source: source})
+		return ErrInvalidCredentials
+	}
+
+	service.do(request{Type: succeed, Source: source})
+	return nil
+}
+
+func (service *Service) Register(name, password string) error {
+	user, err := NewUser(name, password)
+	if err != nil {
+		return err
+	}
+	return service.do(request{Type: create, User: user}).Err
+}
+
+// Exists reports whether a registered account uses the name.
+func (service *Service) Exists(name string) bool {
+	return service.do(request{Type: lookup, Name: name}).Found
+}
+
+func (service *Service) do(req request) response {
+	req.Reply = make(chan response, 1)
+	service.requests <- req
+	return <-req.Reply
+}
+
+// fail counts a failed login from source, locking it out after MaxFailures
+// within Lockout of each other.
+func (service *Service) fail(source string, now time.Time) {
+	f, ok := service.failures[source]
+	if !ok {
+		if len(service.failures) >= maxSources {
+			service.prune(now)
+		}
+		f = &failure{}
+		service.failures[source] = f
+	}
+	if now.Sub(f.Last) > service.config.Lockout {
+		f.Count = 0
+	}
+	f.Count++
+	f.Last = now
+	if service.config.MaxFailures > 0 && f.Count >= service.config.MaxFailures {
+		f.Count = 0
+		f.LockedUntil = now.Add(service.config.Lockout)
+		log.Printf("Logins from %s locked after repeated failures", source)
+	}
+}
+
+// prune forgets sources that are neither locked nor failed recently, and
+// when none are that old, one arbitrary source, preferring one not locked.
+func (service *Service) prune(now time.Time) {
+	for source, f := range service.failures {
+		if now.After(f.LockedUntil) && now.Sub(f.Last) > service.config.Lockout {
+			delete(service.failures, source)
+		}
+	}
+	if len(service.failures) < maxSources {
+		return
+	}
+	var victim string
+	for source, f := range service.failures {
+		victim = source
+		if now.After(f.LockedUntil) {
+			break
+		}
+	}
+	delete(service.failures, victim)
+}
+
+func (service *Service) save() error {
+	users := make([]User, 0, len(service.users))
+	for _, user := range service.users {
+		users = append(users, user)
+	}
+	data, err := json.MarshalIndent(users, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(service.config.UsersFile), 0o755); err != nil {
+		return err
+	}
+	tmp := service.config.UsersFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, service.config.UsersFile)
+}
@@ -0,0 +1,199 @@
+package broker
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Arun445/tcp-go/internal/message"
+)
+
+func expectMessage(t *testing.T, subscription <-chan message.Message, body string) {
+	t.Helper()
+	select {
+	case m := <-subscription:
+		if string(m.Body) != body {
+			t.Errorf("Expected '%s', got '%s'", body, string(m.Body))
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Did not receive '%s'", body)
+	}
+}
+
+func TestMemory_PublishSubscribe(t *testing.T) {
+	memory := NewMemory()
+	go memory.Open()
+
+	lobby, _ := memory.Subscribe("lobby")
+	other, _ := memory.Subscribe("other")
+
+	// Publishing must not wait for the subscriber to read.
+	for _, body := range []string{"one", "two", "three"} {
+		memory.Publish("lobby", message.Message{SessionID: "s1", Body: []byte(body)})
+	}
+
+	for _, body := range []string{"one", "two", "three"} {
+		expectMessage(t, lobby, body)
+	}
+	select {
+	case m := <-other:
+		t.Errorf("Other room received %s", string(m.Body))
+	case <-time.After(20 * time.Millisecond):
+		// expected
+	}
+}
+
+func TestRESP_RoundTrip(t *testing.T) {
+	var buffer bytes.Buffer
+	writeCommand(&buffer, []byte("PUBLISH"), []byte("room"), []byte("hi\r\nthere"))
+
+	reply, err := readReply(bufio.NewReader(&buffer))
+	if err != nil {
+		t.Fatalf("Failed to read reply: %v", err)
+	}
+	expected := []any{[]byte("PUBLISH"), []byte("room"), []byte("hi\r\nthere")}
+	if !reflect.DeepEqual(reply, expected) {
+		t.Errorf("Expected %v, got %v", expected, reply)
+	}
+
+	reply, _ = readReply(bufio.NewReader(bytes.NewBufferString("-ERR wrong\r\n:3\r\n$-1\r\n")))
+	if _, ok := reply.(respError); !ok {
+		t.Errorf("Expected error reply, got %v", reply)
+	}
+}
+
+// stubRedis implements just enough of SUBSCRIBE and PUBLISH for the broker.
+func stubRedis(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	type command struct {
+		Conn net.Conn
+		Args []any
+	}
+	commands := make(chan command)
+
+	go func() {
+		subscribers := make(map[string][]net.Conn)
+		for cmd := range commands {
+			name, _ := cmd.Args[0].([]byte)
+			channel, _ := cmd.Args[1].([]byte)
+			switch string(name) {
+			case "SUBSCRIBE":
+				subscribers[string(channel)] = append(subscribers[string(channel)], cmd.Conn)
+				writeCommand(cmd.Conn, []byte("subscribe"), channel, []byte("1"))
+			case "PUBLISH":
+				for _, conn := range subscribers[string(channel)] {
+					writeCommand(conn, []byte("message"), channel, cmd.Args[2].([]byte))
+				}
+				cmd.Conn.Write([]byte(":1\r\n"))
+			}
+		}
+	}()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				reader := bufio.NewReader(conn)
+				for {
+					reply, err := readReply(reader)
+					if err != nil {
+						return
+					}
+					if args, ok := reply.([]any); ok && len(args) >= 2 {
+						commands <- command{Conn: conn, Args: args}
+					}
+				}
+			}()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func TestRedis_PublishSubscribe(t *testing.T) {
+	addr := stubRedis(t)
+
+	first := NewRedis(addr)
+	second := NewRedis(addr)
+	go first.Open()
+	go second.Open()
+
+	firstLobby, _ := first.Subscribe("lobby")
+	secondLobby, _ := second.Subscribe("lobby")
+	time.Sleep(100 * time.Millisecond)
+
+	first.Publish("lobby", message.Message{SessionID: "s1", Body: []byte("across instances")})
+
+	expectMessage(t, firstLobby, "across instances")
+	expectMessage(t, secondLobby, "across instances")
+}
+
+func TestMemory_Unsubscribe(t *testing.T) {
+	memory := NewMemory()
+	go memory.Open()
+
+	kept, _ := memory.Subscribe("lobby")
+	dropped, _ := memory.Subscribe("lobby")
+	memory.Unsubscribe("lobby", dropped)
+
+	memory.Publish("lobby", message.Message{SessionID: "s1", Body: []byte("after")})
+	expectMessage(t, kept, "after")
+	select {
+	case m := <-dropped:
+		t.Errorf("Unsubscribed channel received %s", string(m.Body))
+	case <-time.After(20 * time.Millisecond):
+		// expected
+	}
+}
+
+func TestMemory_SlowSubscriber(t *testing.T) {
+	memory := NewMemory()
+	go memory.Open()
+	lobby, _ := memory.Subscribe("lobby")
+	other, _ := memory.Subscribe("other")
+
+	for i := 0; i < pipeQueue+10; i++ {
+		memory.Publish("lobby", message.Message{Body: []byte("flood")})
+	}
+	// Publications are handed over in order, so once this one arrived the
+	// flood has reached the lobby's queue.
+	memory.Publish("other", message.Message{Body: []byte("marker")})
+	expectMessage(t, other, "marker")
+
+	received := 0
+	for {
+		select {
+		case <-lobby:
+			received++
+			continue
+		case <-time.After(50 * time.Millisecond):
+		}
+		break
+	}
+	if received != pipeQueue {
+		t.Errorf("Expected the subscriber to get the first %d messages, got %d", pipeQueue, received)
+	}
+}
+
+func TestRedis_PublishBacklog(t *testing.T) {
+	redis := NewRedis("127.0.0.1:1")
+	for i := 0; i < publishQueue; i++ {
+		if err := redis.Publish("lobby", message.Message{Body: []byte("queued")}); err != nil {
+			t.Fatalf("Expected publish %d to be queued, got %v", i, err)
+		}
+	}
+	if err := redis.Publish("lobby", message.Message{Body: []byte("dropped")}); err != ErrBacklog {
+		t.Errorf("Expected ErrBacklog once the queue is full, got %v", err)
+	}
+}
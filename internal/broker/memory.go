@@ -0,0 +1,105 @@
+package broker
+
+import (
+	"log"
+
+	"github.com/Arun445/tcp-go/internal/message"
+	"github.com/Arun445/tcp-go/internal/metrics"
+)
+
+// pipeQueue is how far a subscriber may fall behind.
+const pipeQueue = 4096
+
+func NewMemory() *Memory {
+	return &Memory{
+		publishes:    make(chan publication),
+		subscribes:   make(chan subscription),
+		unsubscribes: make(chan subscription),
+		stop:         make(chan struct{}),
+		rooms:        make(map[string][]subscription),
+	}
+}
+
+func (memory *Memory) Open() {
+	for {
+		select {
+		case sub := <-memory.subscribes:
+			memory.rooms[sub.Room] = append(memory.rooms[sub.Room], sub)
+		case sub := <-memory.unsubscribes:
+			memory.rooms[sub.Room] = unsubscribe(memory.rooms[sub.Room], sub.Out)
+			if len(memory.rooms[sub.Room]) == 0 {
+				delete(memory.rooms, sub.Room)
+			}
+		case pub := <-memory.publishes:
+			for _, sub := range memory.rooms[pub.Room] {
+				sub.In <- pub.Message
+			}
+		case <-memory.stop:
+			return
+		}
+	}
+}
+
+func (memory *Memory) Publish(room string, m message.Message) error {
+	memory.publishes <- publication{Room: room, Message: m}
+	return nil
+}
+
+func (memory *Memory) Subscribe(room string) (<-chan message.Message, error) {
+	in := make(chan message.Message)
+	out := make(chan message.Message)
+	go pipe(in, out)
+	memory.subscribes <- subscription{Room: room, In: in, Out: out}
+	return out, nil
+}
+
+func (memory *Memory) Unsubscribe(room string, out <-chan message.Message) {
+	memory.unsubscribes <- subscription{Room: room, Out: out}
+}
+
+func (memory *Memory) Close() {
+	close(memory.stop)
+}
+
+// unsubscribe removes the subscription reading from out and stops its pipe.
+func unsubscribe(subs []subscription, out <-chan message.Message) []subscription {
+	for i, sub := range subs {
+		if sub.Out == out {
+			close(sub.In)
+			return append(subs[:i], subs[i+1:]...)
+		}
+	}
+	return subs
+}
+
+// pipe forwards in to out through a queue of up to pipeQueue messages, so a
+// publisher never waits for a subscriber. This matters when a room publishes
+// from the same goroutine that consumes its subscription. Messages beyond
+// the queue are dropped. It stops once in is closed.
+func pipe(in <-chan message.Message, out chan<- message.Message) {
+	var queue []message.Message
+	for {
+		var send chan<- message.Message
+		var next message.Message
+		if len(queue) > 0 {
+			send = out
+			next = queue[0]
+		}
+
+		select {
+		case m, ok := <-in:
+			if !ok {
+				return
+			}
+			if len(queue) >= pipeQueue {
+				log.Printf("Broker subscriber is %d messages behind, dropping a message", len(queue))
+				metrics.BrokerDropped.Add("deliver", 1)
+				continue
+			}
+			queue = append(queue, m)
+		case send <- next:
+			queue[0] = message.Message{}
+			queue = queue[1:]
+		}
+	}
+}
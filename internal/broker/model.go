@@ -0,0 +1,46 @@
+package broker
+
+import "github.com/Arun445/tcp-go/internal/message"
+
+// Broker carries room messages between publishers and subscribers. A
+// subscriber also receives the messages it published itself.
+type Broker interface {
+	Publish(room string, m message.Message) error
+	Subscribe(room string) (<-chan message.Message, error)
+	// Unsubscribe stops a subscription, which receives nothing after.
+	Unsubscribe(room string, subscription <-chan message.Message)
+	// Close stops the broker once every subscriber has unsubscribed.
+	Close()
+}
+
+type publication struct {
+	Room    string
+	Message message.Message
+}
+
+// subscription feeds In, whose pipe forwards to Out. Unsubscribing closes
+// In, which stops the pipe.
+type subscription struct {
+	Room string
+	In   chan<- message.Message
+	Out  <-chan message.Message
+}
+
+// Memory is the in-process broker used by default.
+type Memory struct {
+	publishes    chan publication
+	subscribes   chan subscription
+	unsubscribes chan subscription
+	stop         chan struct{}
+	rooms        map[string][]subscription
+}
+
+// Redis speaks RESP pub/sub to a Redis server, so that several instances
+// can share rooms over one bus.
+type Redis struct {
+	addr         string
+	publishes    chan publication
+	subscribes   chan subscription
+	unsubscribes chan subscription
+	stop         chan struct{}
+}
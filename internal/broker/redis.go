@@ -0,0 +1,233 @@
+package broker
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/Arun445/tcp-go/internal/message"
+	"github.com/Arun445/tcp-go/internal/metrics"
+)
+
+const (
+	channelPrefix = "tcp-go:room:"
+	redialDelay   = time.Second
+	publishQueue  = 1024
+)
+
+// ErrBacklog is returned for messages dropped because Redis is not keeping
+// up or is unreachable.
+var ErrBacklog = errors.New("redis publish queue is full")
+
+type push struct {
+	Conn    net.Conn
+	Room    string
+	Payload []byte
+	Err     error
+}
+
+func NewRedis(addr string) *Redis {
+	return &Redis{
+		addr:         addr,
+		publishes:    make(chan publication, publishQueue),
+		subscribes:   make(chan subscription),
+		unsubscribes: make(chan subscription),
+		stop:         make(chan struct{}),
+	}
+}
+
+// Open runs the publishing and subscribing connections. Both reconnect on
+// failure; messages published while disconnected are retried once.
+func (redis *Redis) Open() {
+	go redis.publisher()
+	redis.subscriber()
+}
+
+// Publish queues m for the publishing connection without waiting for it,
+// and drops it when publishQueue messages are already waiting.
+func (redis *Redis) Publish(room string, m message.Message) error {
+	select {
+	case redis.publishes <- publication{Room: room, Message: m}:
+		return nil
+	default:
+		metrics.BrokerDropped.Add("publish", 1)
+		return ErrBacklog
+	}
+}
+
+func (redis *Redis) Subscribe(room string) (<-chan message.Message, error) {
+	in := make(chan message.Message)
+	out := make(chan message.Message)
+	go pipe(in, out)
+	redis.subscribes <- subscription{Room: room, In: in, Out: out}
+	return out, nil
+}
+
+func (redis *Redis) Unsubscribe(room string, out <-chan message.Message) {
+	redis.unsubscribes <- subscription{Room: room, Out: out}
+}
+
+// Close stops both connections. Messages still queued for publishing are
+// dropped.
+func (redis *Redis) Close() {
+	close(redis.stop)
+}
+
+func (redis *Redis) publisher() {
+	var conn net.Conn
+	var reader *bufio.Reader
+
+	for {
+		var pub publication
+		select {
+		case pub = <-redis.publishes:
+		case <-redis.stop:
+			if conn != nil {
+				conn.Close()
+			}
+			return
+		}
+
+		payload, err := json.Marshal(pub.Message)
+		if err != nil {
+			log.Printf("Redis publish encode error: %v", err)
+			continue
+		}
+
+		for attempt := 0; attempt < 2; attempt++ {
+			if conn == nil {
+				if conn, err = net.DialTimeout("tcp", redis.addr, 5*time.Second); err != nil {
+					log.Printf("Redis publisher dial error: %v", err)
+					conn = nil
+					select {
+					case <-time.After(redialDelay):
+					case <-redis.stop:
+						return
+					}
+					continue
+				}
+				reader = bufio.NewReader(conn)
+			}
+
+			err = writeCommand(conn, []byte("PUBLISH"), []byte(channelPrefix+pub.Room), payload)
+			if err == nil {
+				var reply any
+				if reply, err = readReply(reader); err == nil {
+					if replyErr, ok := reply.(respError); ok {
+						err = replyErr
+					}
+				}
+			}
+			if err == nil {
+				break
+			}
+			log.Printf("Redis publish error: %v", err)
+			conn.Close()
+			conn = nil
+		}
+	}
+}
+
+func (redis *Redis) subscriber() {
+	rooms := make(map[string][]subscription)
+	pushes := make(chan push)
+	var conn net.Conn
+	redial := time.After(0)
+
+	for {
+		select {
+		case sub := <-redis.subscribes:
+			rooms[sub.Room] = append(rooms[sub.Room], sub)
+			if conn != nil && len(rooms[sub.Room]) == 1 {
+				writeCommand(conn, []byte("SUBSCRIBE"), []byte(channelPrefix+sub.Room))
+			}
+
+		case sub := <-redis.unsubscribes:
+			rooms[sub.Room] = unsubscribe(rooms[sub.Room], sub.Out)
+			if len(rooms[sub.Room]) > 0 {
+				continue
+			}
+			delete(rooms, sub.Room)
+			if conn != nil {
+				writeCommand(conn, []byte("UNSUBSCRIBE"), []byte(channelPrefix+sub.Room))
+			}
+
+		case <-redial:
+			redial = nil
+			dialed, err := net.DialTimeout("tcp", redis.addr, 5*time.Second)
+			if err != nil {
+				log.Printf("Redis subscriber dial error: %v", err)
+				redial = time.After(redialDelay)
+				continue
+			}
+			conn = dialed
+			go readPushes(conn, pushes, redis.stop)
+			for room := range rooms {
+				writeCommand(conn, []byte("SUBSCRIBE"), []byte(channelPrefix+room))
+			}
+
+		case p := <-pushes:
+			if p.Conn != conn {
+				continue
+			}
+			if p.Err != nil {
+				log.Printf("Redis subscriber error: %v", p.Err)
+				conn.Close()
+				conn = nil
+				redial = time.After(redialDelay)
+				continue
+			}
+
+			var m message.Message
+			if err := json.Unmarshal(p.Payload, &m); err != nil {
+				log.Printf("Redis message decode error: %v", err)
+				continue
+			}
+			for _, sub := range rooms[p.Room] {
+				sub.In <- m
+			}
+
+		case <-redis.stop:
+			if conn != nil {
+				conn.Close()
+			}
+			return
+		}
+	}
+}
+
+// readPushes forwards "message" pushes until the connection fails or the
+// broker stops.
+func readPushes(conn net.Conn, pushes chan<- push, stop <-chan struct{}) {
+	reader := bufio.NewReader(conn)
+	for {
+		reply, err := readReply(reader)
+		if err != nil {
+			select {
+			case pushes <- push{Conn: conn, Err: err}:
+			case <-stop:
+			}
+			return
+		}
+
+		items, ok := reply.([]any)
+		if !ok || len(items) != 3 {
+			continue
+		}
+		kind, _ := items[0].([]byte)
+		channel, _ := items[1].([]byte)
+		payload, _ := items[2].([]byte)
+		if string(kind) != "message" || !strings.HasPrefix(string(channel), channelPrefix) {
+			continue
+		}
+		select {
+		case pushes <- push{Conn: conn, Room: strings.TrimPrefix(string(channel), channelPrefix), Payload: payload}:
+		case <-stop:
+			return
+		}
+	}
+}
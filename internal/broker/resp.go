@@ -0,0 +1,79 @@
+package broker
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// respError is an error reply sent by the server.
+type respError string
+
+func (err respError) Error() string {
+	return string(err)
+}
+
+// writeCommand encodes a command as a RESP array of bulk strings.
+func writeCommand(w io.Writer, args ...[]byte) error {
+	buffer := []byte(fmt.Sprintf("*%d\r\n", len(args)))
+	for _, arg := range args {
+		buffer = append(buffer, fmt.Sprintf("$%d\r\n", len(arg))...)
+		buffer = append(buffer, arg...)
+		buffer = append(buffer, "\r\n"...)
+	}
+	_, err := w.Write(buffer)
+	return err
+}
+
+// readReply decodes one RESP value: string, respError, int64, []byte (nil
+// for a null bulk string) or []any.
+func readReply(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("malformed RESP line")
+	}
+	kind, payload := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return payload, nil
+	case '-':
+		return respError(payload), nil
+	case ':':
+		return strconv.ParseInt(payload, 10, 64)
+	case '$':
+		size, err := strconv.Atoi(payload)
+		if err != nil {
+			return nil, err
+		}
+		if size < 0 {
+			return []byte(nil), nil
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		return data[:size], nil
+	case '*':
+		count, err := strconv.Atoi(payload)
+		if err != nil {
+			return nil, err
+		}
+		if count < 0 {
+			return []any(nil), nil
+		}
+		items := make([]any, count)
+		for i := range items {
+			if items[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("unknown RESP type %q", kind)
+}
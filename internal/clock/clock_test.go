@@ -0,0 +1,89 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFake_Ticker(t *testing.T) {
+	start := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	fake := NewFake(start)
+	ticker := fake.NewTicker(time.Second)
+	fake.BlockUntil(1)
+
+	fake.Advance(500 * time.Millisecond)
+	select {
+	case <-ticker.C():
+		t.Fatal("Ticker fired before its interval")
+	default:
+	}
+
+	fake.Advance(3 * time.Second)
+	select {
+	case now := <-ticker.C():
+		if !now.Equal(start.Add(3500 * time.Millisecond)) {
+			t.Errorf("Expected tick at the new time, got %v", now)
+		}
+	default:
+		t.Fatal("Ticker did not fire")
+	}
+	select {
+	case <-ticker.C():
+		t.Error("Expected missed ticks to be dropped")
+	default:
+	}
+
+	ticker.Stop()
+	fake.Advance(time.Minute)
+	select {
+	case <-ticker.C():
+		t.Error("Stopped ticker fired")
+	default:
+	}
+	if got := fake.Now(); !got.Equal(start.Add(63500 * time.Millisecond)) {
+		t.Errorf("Expected clock to have moved, got %v", got)
+	}
+}
+
+func TestFake_Timer(t *testing.T) {
+	fake := NewFake(time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC))
+	timer := fake.NewTimer(time.Second)
+
+	fake.Advance(time.Second)
+	select {
+	case <-timer.C():
+	default:
+		t.Fatal("Timer did not fire")
+	}
+	fake.Advance(time.Minute)
+	select {
+	case <-timer.C():
+		t.Fatal("Timer fired twice")
+	default:
+	}
+
+	timer.Reset(time.Second)
+	timer.Stop()
+	fake.Advance(time.Minute)
+	select {
+	case <-timer.C():
+		t.Error("Stopped timer fired")
+	default:
+	}
+
+	timer.Reset(time.Second)
+	fake.Advance(500 * time.Millisecond)
+	timer.Reset(time.Second)
+	fake.Advance(500 * time.Millisecond)
+	select {
+	case <-timer.C():
+		t.Error("Reset timer fired at its old time")
+	default:
+	}
+	fake.Advance(500 * time.Millisecond)
+	select {
+	case <-timer.C():
+	default:
+		t.Error("Reset timer did not fire")
+	}
+}
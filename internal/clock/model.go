@@ -0,0 +1,58 @@
+package clock
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Clock tells the time. Production code uses Real; tests use a Fake and move
+// it forward by hand.
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+	NewTimer(d time.Duration) Timer
+}
+
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Timer fires once. Like time.Timer, no stale time is received after Stop
+// or Reset returns.
+type Timer interface {
+	C() <-chan time.Time
+	Stop()
+	Reset(d time.Duration)
+}
+
+type system struct{}
+
+type realTicker struct {
+	*time.Ticker
+}
+
+type realTimer struct {
+	*time.Timer
+}
+
+// Fake only moves when Advance is called. Its tickers fire once for every
+// interval crossed, dropping ticks nobody has taken like time.Ticker does,
+// and its timers fire once when their time is crossed.
+type Fake struct {
+	now atomic.Int64
+	// tickers holds the registered tickers. Taking the slice out of the
+	// channel gives exclusive access until it is put back.
+	tickers chan []*fakeTicker
+	// added is signalled whenever a ticker is registered.
+	added chan struct{}
+}
+
+// fakeTicker is a ticker, or a timer when every is zero.
+type fakeTicker struct {
+	fake    *Fake
+	c       chan time.Time
+	every   time.Duration
+	next    time.Time
+	stopped atomic.Bool
+}
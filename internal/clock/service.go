@@ -0,0 +1,152 @@
+package clock
+
+import (
+	"slices"
+	"time"
+)
+
+// Real is the system clock.
+func Real() Clock {
+	return system{}
+}
+
+func (system) Now() time.Time {
+	return time.Now()
+}
+
+func (system) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
+
+func (system) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
+
+func (t realTimer) Stop() {
+	t.Timer.Stop()
+}
+
+func (t realTimer) Reset(d time.Duration) {
+	t.Timer.Reset(d)
+}
+
+// NewFake returns a fake clock standing at start.
+func NewFake(start time.Time) *Fake {
+	fake := &Fake{tickers: make(chan []*fakeTicker, 1), added: make(chan struct{}, 1)}
+	fake.now.Store(start.UnixNano())
+	fake.tickers <- nil
+	return fake
+}
+
+func (fake *Fake) Now() time.Time {
+	return time.Unix(0, fake.now.Load())
+}
+
+func (fake *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive ticker interval")
+	}
+	ticker := &fakeTicker{fake: fake, c: make(chan time.Time, 1), every: d, next: fake.Now().Add(d)}
+	fake.add(ticker)
+	return ticker
+}
+
+// NewTimer returns a timer that fires once the clock has moved by d.
+func (fake *Fake) NewTimer(d time.Duration) Timer {
+	timer := &fakeTicker{fake: fake, c: make(chan time.Time, 1), next: fake.Now().Add(d)}
+	fake.add(timer)
+	return timer
+}
+
+func (fake *Fake) add(ticker *fakeTicker) {
+	tickers := <-fake.tickers
+	fake.tickers <- append(tickers, ticker)
+	select {
+	case fake.added <- struct{}{}:
+	default:
+	}
+}
+
+// BlockUntil waits until n tickers and timers have been registered, so a test knows
+// when the code under test is ready for Advance.
+func (fake *Fake) BlockUntil(n int) {
+	for {
+		tickers := <-fake.tickers
+		count := len(tickers)
+		fake.tickers <- tickers
+		if count >= n {
+			return
+		}
+		<-fake.added
+	}
+}
+
+// Advance moves the clock forward by d and fires the tickers and timers that
+// came due.
+func (fake *Fake) Advance(d time.Duration) {
+	tickers := <-fake.tickers
+	defer func() { fake.tickers <- tickers }()
+
+	now := fake.Now().Add(d)
+	fake.now.Store(now.UnixNano())
+
+	kept := tickers[:0]
+	for _, ticker := range tickers {
+		if ticker.stopped.Load() {
+			continue
+		}
+		kept = append(kept, ticker)
+		if now.Before(ticker.next) {
+			continue
+		}
+		if ticker.every == 0 {
+			ticker.stopped.Store(true)
+			kept = kept[:len(kept)-1]
+		}
+		for ticker.every > 0 && !now.Before(ticker.next) {
+			ticker.next = ticker.next.Add(ticker.every)
+		}
+		select {
+		case ticker.c <- now:
+		default:
+		}
+	}
+	tickers = kept
+}
+
+func (ticker *fakeTicker) C() <-chan time.Time {
+	return ticker.c
+}
+
+func (ticker *fakeTicker) Stop() {
+	tickers := <-ticker.fake.tickers
+	ticker.stopped.Store(true)
+	ticker.drain()
+	ticker.fake.tickers <- tickers
+}
+
+// Reset starts a timer again, to fire once the clock has moved by d.
+func (ticker *fakeTicker) Reset(d time.Duration) {
+	tickers := <-ticker.fake.tickers
+	ticker.drain()
+	ticker.next = ticker.fake.Now().Add(d)
+	if ticker.stopped.Swap(false) && !slices.Contains(tickers, ticker) {
+		tickers = append(tickers, ticker)
+	}
+	ticker.fake.tickers <- tickers
+}
+
+func (ticker *fakeTicker) drain() {
+	select {
+	case <-ticker.c:
+	default:
+	}
+}
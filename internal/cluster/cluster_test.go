@@ -0,0 +1,201 @@
+package cluster
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Arun445/tcp-go/internal/config"
+	"github.com/Arun445/tcp-go/internal/message"
+)
+
+// startCluster runs n fully meshed nodes on localhost, each with a local
+// "lobby" room whose deliveries end up on the returned channel. Nodes share
+// the secret "test" unless secrets gives each its own.
+func startCluster(t *testing.T, n int, secrets ...string) ([]*Node, []chan message.Message) {
+	listeners := make([]net.Listener, n)
+	addrs := make([]string, n)
+	for i := range listeners {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Failed to listen: %v", err)
+		}
+		t.Cleanup(func() { listener.Close() })
+		listeners[i] = listener
+		addrs[i] = listener.Addr().String()
+	}
+
+	nodes := make([]*Node, n)
+	rooms := make([]chan message.Message, n)
+	for i := range nodes {
+		var peers []string
+		for j, addr := range addrs {
+			if j != i {
+				// Peers are known by node ID, not by the address
+				// they were dialed at.
+				peers = append(peers, strings.Replace(addr, "127.0.0.1", "localhost", 1))
+			}
+		}
+		secret := "test"
+		if len(secrets) > 0 {
+			secret = secrets[i]
+		}
+		nodes[i] = NewNode(&config.ClusterConfig{
+			NodeID: fmt.Sprintf("node-%d", i),
+			Addr:   addrs[i],
+			Peers:  peers,
+			Secret: secret,
+		})
+		rooms[i] = make(chan message.Message, 10)
+
+		go nodes[i].Open()
+		go nodes[i].Serve(listeners[i])
+		nodes[i].Join("lobby", rooms[i], nil)
+		nodes[i].Connect()
+	}
+	return nodes, rooms
+}
+
+// awaitReach polls node until a message published to lobby goes to exactly
+// peers, as the links come up and the peers' members are learned.
+func awaitReach(t *testing.T, node *Node, peers ...string) {
+	t.Helper()
+	deadline := time.After(time.Second)
+	for {
+		if got := node.reaches("lobby"); slices.Equal(got, peers) {
+			return
+		}
+		select {
+		case <-deadline:
+			t.Fatalf("Expected lobby to reach %v, got %v", peers, node.reaches("lobby"))
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+}
+
+func TestCluster_FanOut(t *testing.T) {
+	nodes, rooms := startCluster(t, 3)
+
+	nodes[1].SetMembers("lobby", true)
+	nodes[2].SetMembers("lobby", true)
+	awaitReach(t, nodes[0], "node-1", "node-2")
+
+	at := time.Date(2024, time.January, 1, 9, 0, 0, 0, time.UTC)
+	nodes[0].Publish("lobby", message.Message{ID: 3, Seq: 4, Time: at, Body: []byte("hello cluster")})
+
+	for i := 1; i < 3; i++ {
+		select {
+		case m := <-rooms[i]:
+			if string(m.Body) != "hello cluster" || m.ID != 3 || m.Seq != 4 || !m.Time.Equal(at) {
+				t.Errorf("Node %d: expected 'hello cluster' numbered and stamped by its origin, got %+v", i, m)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Node %d did not receive the message", i)
+		}
+	}
+
+	select {
+	case m := <-rooms[0]:
+		t.Errorf("Origin node received its own message: %s", string(m.Body))
+	case <-time.After(50 * time.Millisecond):
+		// expected
+	}
+
+	nodes[0].Publish("lobby", message.Message{ID: 9, Action: message.Delete, Target: 7, From: "alice", By: "bob"})
+	select {
+	case m := <-rooms[1]:
+		if m.ID != 9 || m.Action != message.Delete || m.Target != 7 || m.By != "bob" {
+			t.Errorf("Expected the deletion to arrive as published, got %+v", m)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Node 1 did not receive the deletion")
+	}
+}
+
+func TestCluster_OnlyInterestedPeers(t *testing.T) {
+	nodes, rooms := startCluster(t, 3)
+
+	nodes[1].SetMembers("lobby", true)
+	nodes[2].SetMembers("lobby", true)
+	awaitReach(t, nodes[0], "node-1", "node-2")
+	nodes[2].SetMembers("lobby", false)
+	awaitReach(t, nodes[0], "node-1")
+
+	nodes[0].Publish("lobby", message.Message{Body: []byte("only node 1")})
+
+	select {
+	case <-rooms[1]:
+		// expected
+	case <-time.After(time.Second):
+		t.Fatal("Node 1 did not receive the message")
+	}
+	select {
+	case m := <-rooms[2]:
+		t.Errorf("Node 2 without members received %s", string(m.Body))
+	case <-time.After(100 * time.Millisecond):
+		// expected
+	}
+}
+
+func TestCluster_WrongSecret(t *testing.T) {
+	nodes, rooms := startCluster(t, 3, "test", "guess", "test")
+
+	// node 2 knows the secret, so once it is reached the links node 1 failed
+	// to log in over were tried as well.
+	nodes[1].SetMembers("lobby", true)
+	nodes[2].SetMembers("lobby", true)
+	awaitReach(t, nodes[0], "node-2")
+	nodes[0].Publish("lobby", message.Message{Body: []byte("secret plans")})
+	select {
+	case <-rooms[2]:
+	case <-time.After(time.Second):
+		t.Fatal("Node 2 did not receive the message")
+	}
+
+	select {
+	case m := <-rooms[1]:
+		t.Errorf("Node with the wrong secret received %s", string(m.Body))
+	case <-time.After(100 * time.Millisecond):
+		// expected
+	}
+}
+
+func TestNode_DedupAndLoop(t *testing.T) {
+	node := NewNode(&config.ClusterConfig{NodeID: "node-a"})
+	go node.Open()
+	deliver := make(chan message.Message)
+	node.Join("lobby", deliver, nil)
+
+	f := frame{Type: messageFrame, ID: "node-b-1", Room: "lobby", Path: []string{"node-b"}}
+	if node.do(request{Type: accept, Frame: f}).Deliver == nil {
+		t.Error("Expected first copy to be delivered")
+	}
+	if node.do(request{Type: accept, Frame: f}).Deliver != nil {
+		t.Error("Expected duplicate to be dropped")
+	}
+
+	looped := frame{Type: messageFrame, ID: "node-b-2", Room: "lobby", Path: []string{"node-b", "node-a"}}
+	if node.do(request{Type: accept, Frame: looped}).Deliver != nil {
+		t.Error("Expected message that already passed this node to be dropped")
+	}
+}
+
+func TestNode_RestartKeepsIDsApart(t *testing.T) {
+	var ids []string
+	for run := 0; run < 2; run++ {
+		node := NewNode(&config.ClusterConfig{NodeID: "node-a"})
+		go node.Open()
+		out := make(chan frame, 1)
+		node.do(request{Type: connected, Node: "node-b", Out: out})
+		node.tell(request{Type: setInterest, Node: "node-b", Rooms: []string{"lobby"}})
+		node.Publish("lobby", message.Message{Body: []byte("hello")})
+		ids = append(ids, (<-out).ID)
+		node.Close()
+	}
+	if ids[0] == ids[1] {
+		t.Errorf("Expected a restarted node to number frames apart from its last run, got %s twice", ids[0])
+	}
+}
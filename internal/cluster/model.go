@@ -0,0 +1,105 @@
+package cluster
+
+import (
+	"time"
+
+	"github.com/Arun445/tcp-go/internal/config"
+	"github.com/Arun445/tcp-go/internal/message"
+)
+
+const (
+	helloFrame     = "hello"
+	challengeFrame = "challenge"
+	proofFrame     = "proof"
+	roomsFrame     = "rooms"
+	messageFrame   = "message"
+)
+
+// frame is one JSON line on a peer connection.
+type frame struct {
+	Type string `json:"type"`
+	Node string `json:"node,omitempty"`
+	// Nonce and Proof make up the login of a link: each end proves it
+	// knows the secret by signing the other's nonce, which never crosses
+	// the link itself.
+	Nonce   string   `json:"nonce,omitempty"`
+	Proof   string   `json:"proof,omitempty"`
+	Rooms   []string `json:"rooms,omitempty"`
+	ID      string   `json:"id,omitempty"`
+	Room    string   `json:"room,omitempty"`
+	From    string   `json:"from,omitempty"`
+	Account string   `json:"account,omitempty"`
+	Body    []byte   `json:"body,omitempty"`
+	// Message, Seq, Time, Action, Target and By carry a room message as the
+	// room that took it numbered and stamped it, or the edit or deletion it
+	// stands for.
+	Message uint64     `json:"message,omitempty"`
+	Seq     uint64     `json:"seq,omitempty"`
+	Time    *time.Time `json:"time,omitempty"`
+	Action  string     `json:"action,omitempty"`
+	Target  uint64     `json:"target,omitempty"`
+	By      string     `json:"by,omitempty"`
+	Path    []string   `json:"path,omitempty"`
+}
+
+// Node links local rooms with the rooms of the same name on its peers. Every
+// node dials every peer and only writes on the connections it dialed, so a
+// pair of nodes shares two one-way links. Peers are known by the node ID they
+// proved on login, whatever address they were dialed at. State is only
+// touched by the Open goroutine, which never blocks on the network or on a
+// room.
+type Node struct {
+	config   *config.ClusterConfig
+	requests chan request
+	stop     chan struct{}
+	outbound map[string]chan frame
+	interest map[string]map[string]bool
+	local    map[string]local
+	members  map[string]bool
+	seen     map[string]bool
+	order    []string
+	// Frame IDs are the node ID, the incarnation and a counter. The
+	// incarnation is drawn at random on start, so peers that still remember
+	// the IDs of a previous run do not take new frames for repeats.
+	incarnation string
+	counter     uint64
+}
+
+// local is a room on this node, which stops reading once Done is closed.
+type local struct {
+	Deliver chan<- message.Message
+	Done    <-chan struct{}
+}
+
+type requestType int
+
+const (
+	join requestType = iota
+	setMembers
+	publish
+	accept
+	setInterest
+	forget
+	connected
+	reach
+)
+
+type request struct {
+	Type    requestType
+	Room    string
+	Deliver chan<- message.Message
+	Done    <-chan struct{}
+	Members bool
+	Node    string
+	Out     chan frame
+	Rooms   []string
+	Frame   frame
+	Reply   chan response
+}
+
+type response struct {
+	Deliver chan<- message.Message
+	Done    <-chan struct{}
+	Frames  []frame
+	Peers   []string
+}
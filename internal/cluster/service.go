@@ -0,0 +1,421 @@
+package cluster
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"slices"
+	"time"
+
+	"github.com/Arun445/tcp-go/internal/config"
+	"github.com/Arun445/tcp-go/internal/message"
+)
+
+const (
+	outboundQueue    = 256
+	seenLimit        = 4096
+	redialDelay      = time.Second
+	handshakeTimeout = 5 * time.Second
+	// writeTimeout drops a link to a peer that stopped reading, so its
+	// queue fills and drops instead of holding a writer forever.
+	writeTimeout = 10 * time.Second
+)
+
+var errProof = errors.New("peer does not know the cluster secret")
+
+func NewNode(clusterConfig *config.ClusterConfig) *Node {
+	node := &Node{
+		config:   clusterConfig,
+		requests: make(chan request),
+		stop:     make(chan struct{}),
+		outbound: make(map[string]chan frame),
+		interest: make(map[string]map[string]bool),
+		local:    make(map[string]local),
+		members:  make(map[string]bool),
+		seen:     make(map[string]bool),
+
+		incarnation: newNonce()[:8],
+	}
+	return node
+}
+
+func (node *Node) Open() {
+	for {
+		select {
+		case req := <-node.requests:
+			switch req.Type {
+			case join:
+				node.local[req.Room] = local{Deliver: req.Deliver, Done: req.Done}
+
+			case setMembers:
+				if node.members[req.Room] == req.Members {
+					continue
+				}
+				if req.Members {
+					node.members[req.Room] = true
+				} else {
+					delete(node.members, req.Room)
+				}
+				rooms := node.rooms()
+				for peer := range node.outbound {
+					node.enqueue(peer, rooms)
+				}
+
+			case publish:
+				node.counter++
+				f := req.Frame
+				f.ID = fmt.Sprintf("%s-%s-%d", node.config.NodeID, node.incarnation, node.counter)
+				f.Path = []string{node.config.NodeID}
+				node.remember(f.ID)
+				for peer, rooms := range node.interest {
+					if rooms[f.Room] {
+						node.enqueue(peer, f)
+					}
+				}
+
+			case accept:
+				f := req.Frame
+				if node.seen[f.ID] || slices.Contains(f.Path, node.config.NodeID) {
+					req.Reply <- response{}
+					continue
+				}
+				node.remember(f.ID)
+				room, ok := node.local[f.Room]
+				if ok && closed(room.Done) {
+					delete(node.local, f.Room)
+					room = local{}
+				}
+				req.Reply <- response{Deliver: room.Deliver, Done: room.Done}
+
+			case setInterest:
+				rooms := make(map[string]bool)
+				for _, room := range req.Rooms {
+					rooms[room] = true
+				}
+				node.interest[req.Node] = rooms
+
+			case forget:
+				delete(node.interest, req.Node)
+
+			case connected:
+				// A peer that restarted elsewhere, or is listed under two
+				// addresses, takes over its old queue's place.
+				node.outbound[req.Node] = req.Out
+				req.Reply <- response{Frames: []frame{node.rooms()}}
+
+			case reach:
+				var peers []string
+				for peer, rooms := range node.interest {
+					if _, ok := node.outbound[peer]; ok && rooms[req.Room] {
+						peers = append(peers, peer)
+					}
+				}
+				slices.Sort(peers)
+				req.Reply <- response{Peers: peers}
+			}
+
+		case <-node.stop:
+			return
+		}
+	}
+}
+
+// Close drops every link and stops the node once its rooms have closed.
+// The listener passed to Serve is closed by its owner.
+func (node *Node) Close() {
+	close(node.stop)
+}
+
+// Join routes messages from peers for the named room to deliver until done
+// is closed.
+func (node *Node) Join(room string, deliver chan<- message.Message, done <-chan struct{}) {
+	node.requests <- request{Type: join, Room: room, Deliver: deliver, Done: done}
+}
+
+// SetMembers tells peers whether this node has members in the room, so
+// messages are only forwarded where someone will read them.
+func (node *Node) SetMembers(room string, members bool) {
+	node.requests <- request{Type: setMembers, Room: room, Members: members}
+}
+
+func (node *Node) Publish(room string, m message.Message) {
+	f := frame{Type: messageFrame, Room: room, From: m.From, Account: m.Account, Body: m.Body,
+		Message: m.ID, Seq: m.Seq, Action: m.Action, Target: m.Target, By: m.By}
+	if !m.Time.IsZero() {
+		f.Time = &m.Time
+	}
+	node.requests <- request{Type: publish, Frame: f}
+}
+
+// reaches returns the IDs of the peers a message published to room now goes
+// to: those linked to this node with members in the room.
+func (node *Node) reaches(room string) []string {
+	return node.do(request{Type: reach, Room: room}).Peers
+}
+
+// Connect keeps an outbound link open to every configured peer.
+func (node *Node) Connect() {
+	for _, peer := range node.config.Peers {
+		go node.dial(peer, make(chan frame, outboundQueue))
+	}
+}
+
+// Serve accepts links from peers until the listener is closed.
+func (node *Node) Serve(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Printf("Cluster accept error: %v", err)
+			return
+		}
+		go node.handlePeer(conn)
+	}
+}
+
+func (node *Node) dial(peer string, out chan frame) {
+	for {
+		conn, err := net.DialTimeout("tcp", peer, 5*time.Second)
+		if err != nil {
+			if !node.wait(redialDelay) {
+				return
+			}
+			continue
+		}
+		encoder := json.NewEncoder(conn)
+		id, err := node.login(conn, encoder)
+		if err != nil {
+			log.Printf("Cluster login to %s failed: %v", peer, err)
+			conn.Close()
+			if !node.wait(redialDelay) {
+				return
+			}
+			continue
+		}
+		log.Printf("Cluster connected to %s at %s", id, peer)
+
+		for _, f := range node.do(request{Type: connected, Node: id, Out: out}).Frames {
+			if err = send(conn, encoder, f); err != nil {
+				break
+			}
+		}
+		for err == nil {
+			select {
+			case f := <-out:
+				err = send(conn, encoder, f)
+			case <-node.stop:
+				conn.Close()
+				return
+			}
+		}
+
+		log.Printf("Cluster link to %s lost: %v", id, err)
+		conn.Close()
+		if !node.wait(redialDelay) {
+			return
+		}
+	}
+}
+
+// wait sleeps for d, reporting false if the node stops first.
+func (node *Node) wait(d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-node.stop:
+		return false
+	}
+}
+
+// send writes one frame, giving up on a peer that does not take it in time.
+func send(conn net.Conn, encoder *json.Encoder, f frame) error {
+	conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return encoder.Encode(f)
+}
+
+// login opens a dialed link: the peer proves it knows the secret by signing
+// our nonce, we do the same with its nonce, and it tells us its node ID.
+func (node *Node) login(conn net.Conn, encoder *json.Encoder) (string, error) {
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	nonce := newNonce()
+	if err := encoder.Encode(frame{Type: helloFrame, Node: node.config.NodeID, Nonce: nonce}); err != nil {
+		return "", err
+	}
+	// The peer writes nothing after its challenge, so the decoder cannot
+	// read ahead.
+	var challenge frame
+	if err := json.NewDecoder(conn).Decode(&challenge); err != nil {
+		return "", err
+	}
+	if challenge.Type != challengeFrame || !node.verify(challenge.Proof, challengeFrame, nonce, challenge.Node) {
+		return "", errProof
+	}
+	proof := node.prove(proofFrame, challenge.Nonce, node.config.NodeID)
+	if err := encoder.Encode(frame{Type: proofFrame, Proof: proof}); err != nil {
+		return "", err
+	}
+	return challenge.Node, nil
+}
+
+// admit is the other side of login, returning the node ID the peer proved.
+func (node *Node) admit(conn net.Conn, decoder *json.Decoder) (string, error) {
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	var hello frame
+	if err := decoder.Decode(&hello); err != nil {
+		return "", err
+	}
+	if hello.Type != helloFrame || hello.Node == "" {
+		return "", errors.New("no hello")
+	}
+	nonce := newNonce()
+	challenge := frame{Type: challengeFrame, Node: node.config.NodeID, Nonce: nonce,
+		Proof: node.prove(challengeFrame, hello.Nonce, node.config.NodeID)}
+	if err := json.NewEncoder(conn).Encode(challenge); err != nil {
+		return "", err
+	}
+	var proof frame
+	if err := decoder.Decode(&proof); err != nil {
+		return "", err
+	}
+	if proof.Type != proofFrame || !node.verify(proof.Proof, proofFrame, nonce, hello.Node) {
+		return "", errProof
+	}
+	return hello.Node, nil
+}
+
+// prove signs a nonce for the given step of a login as node. Binding the
+// step and the node keeps a proof from being replayed elsewhere.
+func (node *Node) prove(step, nonce, id string) string {
+	mac := hmac.New(sha256.New, []byte(node.config.Secret))
+	fmt.Fprintf(mac, "%s\n%s\n%s", step, nonce, id)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (node *Node) verify(proof, step, nonce, id string) bool {
+	want := node.prove(step, nonce, id)
+	return hmac.Equal([]byte(proof), []byte(want))
+}
+
+func newNonce() string {
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	return hex.EncodeToString(nonce)
+}
+
+func (node *Node) handlePeer(conn net.Conn) {
+	defer conn.Close()
+	decoder := json.NewDecoder(bufio.NewReader(conn))
+
+	id, err := node.admit(conn, decoder)
+	if err != nil {
+		log.Printf("Cluster peer %s rejected: %v", conn.RemoteAddr(), err)
+		return
+	}
+	defer node.tell(request{Type: forget, Node: id})
+
+	// Links from peers are closed when the node stops.
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-node.stop:
+			conn.Close()
+		case <-finished:
+		}
+	}()
+
+	for {
+		var f frame
+		if err := decoder.Decode(&f); err != nil {
+			log.Printf("Cluster peer %s disconnected: %v", id, err)
+			return
+		}
+
+		switch f.Type {
+		case roomsFrame:
+			node.tell(request{Type: setInterest, Node: id, Rooms: f.Rooms})
+		case messageFrame:
+			room := node.do(request{Type: accept, Frame: f})
+			if room.Deliver != nil {
+				m := message.Message{ID: f.Message, Seq: f.Seq, SessionID: f.ID, From: f.From, Account: f.Account, Body: f.Body,
+					Action: f.Action, Target: f.Target, By: f.By}
+				if f.Time != nil {
+					m.Time = *f.Time
+				}
+				select {
+				case room.Deliver <- m:
+				case <-room.Done:
+				}
+			}
+		}
+	}
+}
+
+// do sends a request and waits for its reply, which is empty once the node
+// has stopped.
+func (node *Node) do(req request) response {
+	req.Reply = make(chan response, 1)
+	select {
+	case node.requests <- req:
+		return <-req.Reply
+	case <-node.stop:
+		return response{}
+	}
+}
+
+// tell sends a request that has no reply, unless the node has stopped.
+func (node *Node) tell(req request) {
+	select {
+	case node.requests <- req:
+	case <-node.stop:
+	}
+}
+
+func (node *Node) rooms() frame {
+	rooms := make([]string, 0, len(node.members))
+	for room := range node.members {
+		rooms = append(rooms, room)
+	}
+	return frame{Type: roomsFrame, Rooms: rooms}
+}
+
+// enqueue never blocks the node; a peer that falls behind loses frames.
+func (node *Node) enqueue(peer string, f frame) {
+	out, ok := node.outbound[peer]
+	if !ok {
+		return
+	}
+	select {
+	case out <- f:
+	default:
+		log.Printf("Cluster queue to %s full, dropping %s frame", peer, f.Type)
+	}
+}
+
+func closed(done <-chan struct{}) bool {
+	select {
+	case <-done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (node *Node) remember(id string) {
+	node.seen[id] = true
+	node.order = append(node.order, id)
+	if len(node.order) > seenLimit {
+		delete(node.seen, node.order[0])
+		node.order = node.order[1:]
+	}
+}
@@ -3,14 +3,109 @@ package config
 import (
 	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type ServerConfig struct {
-	Port string
+	Port        string
+	ACLFile     string
+	FilterFile  string
+	MetricsAddr string
+	// AuditFile is the append-only audit log. Empty disables it.
+	AuditFile string
+	// TLSCertFile and TLSKeyFile serve clients over TLS when both are set.
+	TLSCertFile string
+	TLSKeyFile  string
+	// Compression lets clients of the listener negotiate DEFLATE during the
+	// login handshake.
+	Compression bool
+	// Bots names built-in bots to run in the lobby. BotsExempt spares them
+	// the byte limits and filters.
+	Bots       []string
+	BotsExempt bool
+	// MOTDFile holds the message of the day. A missing file disables it.
+	MOTDFile string
+	// Broker is "memory" or "redis".
+	Broker    string
+	RedisAddr string
+
+	// Connection limits, zero disables the respective check.
+	MaxConnections int
+	MaxPerIP       int
+	MaxPerCIDR     int
+	CIDRPrefixV4   int
+	CIDRPrefixV6   int
+	AcceptRate     float64
+	AcceptBurst    int
 }
 
 type RoomConfig struct {
+	Name      string
 	ByteLimit int
+	// LimitCompressed counts ByteLimit on the connection, after compression,
+	// instead of on the messages.
+	LimitCompressed bool
+
+	// StateDir persists room settings such as room roles. Empty disables it.
+	StateDir  string
+	RolesFile string
+
+	// MailboxDir enables offline delivery for registered accounts when set.
+	// Empty disables it.
+	MailboxDir    string
+	MailboxSize   int
+	MailboxMaxAge time.Duration
+
+	// MaxRooms caps how many rooms a hub keeps open, the lobby included.
+	// Zero removes the cap.
+	MaxRooms int
+
+	// HistorySize is how many broadcasts are kept for /resend and /delivery.
+	HistorySize int
+
+	// BroadcastShards hands fan-out to that many goroutines, each writing to
+	// a subset of the room. Zero fans out from the room loop.
+	BroadcastShards int
+
+	// WriteBatchBytes and WriteFlushDelay let each session's writer gather
+	// queued messages into one write. Zero delay only gathers what is queued.
+	WriteBatchBytes int
+	WriteFlushDelay time.Duration
+
+	// TransferLimit caps file transfer bytes per session in each direction.
+	// Zero removes the cap.
+	TransferLimit int
+	TransferTTL   time.Duration
+
+	// ResumeGrace is how long a dropped session can be resumed with its token.
+	ResumeGrace time.Duration
+	ResumeQueue int
+
+	// Auth enables the login handshake when set. Nil keeps connections anonymous.
+	Auth *AuthConfig
+}
+
+const (
+	AuthOff      = "off"
+	AuthGuest    = "guest"
+	AuthRequired = "required"
+)
+
+type AuthConfig struct {
+	Mode          string
+	UsersFile     string
+	AllowRegister bool
+	MaxFailures   int
+	Lockout       time.Duration
+}
+
+type ClusterConfig struct {
+	NodeID string
+	// Addr is where this node accepts peers.
+	Addr   string
+	Peers  []string
+	Secret string
 }
 
 func Server() *ServerConfig {
@@ -20,18 +115,144 @@ func Server() *ServerConfig {
 	}
 
 	return &ServerConfig{
-		Port: port,
+		Port:           port,
+		ACLFile:        envString("ACL_FILE", "data/acl.json"),
+		FilterFile:     envString("FILTER_FILE", "data/filters.json"),
+		MetricsAddr:    os.Getenv("METRICS_ADDR"),
+		AuditFile:      envString("AUDIT_FILE", "data/audit.jsonl"),
+		TLSCertFile:    os.Getenv("TLS_CERT_FILE"),
+		TLSKeyFile:     os.Getenv("TLS_KEY_FILE"),
+		Compression:    envBool("COMPRESSION", false),
+		MOTDFile:       envString("MOTD_FILE", "data/motd.txt"),
+		Bots:           envList("BOTS"),
+		BotsExempt:     envBool("BOTS_EXEMPT", true),
+		Broker:         envString("BROKER", "memory"),
+		RedisAddr:      envString("REDIS_ADDR", "localhost:6379"),
+		MaxConnections: envInt("CONN_MAX", 1000),
+		MaxPerIP:       envInt("CONN_MAX_PER_IP", 10),
+		MaxPerCIDR:     envInt("CONN_MAX_PER_CIDR", 100),
+		CIDRPrefixV4:   envInt("CONN_CIDR_V4", 24),
+		CIDRPrefixV6:   envInt("CONN_CIDR_V6", 64),
+		AcceptRate:     envFloat("ACCEPT_RATE", 50),
+		AcceptBurst:    envInt("ACCEPT_BURST", 100),
 	}
 }
 
 func Room() *RoomConfig {
-	byteLimit := 100
-	if byteLimitEnv := os.Getenv("BYTE_LIMIT"); byteLimitEnv != "" {
-		if byteLimitInt, err := strconv.Atoi(byteLimitEnv); err == nil {
-			byteLimit = byteLimitInt
+	return &RoomConfig{
+		Name:            envString("ROOM_NAME", "lobby"),
+		ByteLimit:       envInt("BYTE_LIMIT", 100),
+		LimitCompressed: envBool("BYTE_LIMIT_COMPRESSED", false),
+		StateDir:        envString("ROOM_STATE_DIR", "data/rooms"),
+		RolesFile:       envString("ROLES_FILE", "data/roles.json"),
+		MailboxDir:      os.Getenv("MAILBOX_DIR"),
+		MailboxSize:     envInt("MAILBOX_SIZE", 100),
+		MailboxMaxAge:   envDuration("MAILBOX_MAX_AGE", 7*24*time.Hour),
+		MaxRooms:        envInt("MAX_ROOMS", 100),
+		HistorySize:     envInt("HISTORY_SIZE", 500),
+		BroadcastShards: envInt("BROADCAST_SHARDS", 0),
+		WriteBatchBytes: envInt("WRITE_BATCH_BYTES", 16<<10),
+		WriteFlushDelay: envDuration("WRITE_FLUSH_DELAY", 0),
+		TransferLimit:   envInt("TRANSFER_LIMIT", 10<<20),
+		TransferTTL:     envDuration("TRANSFER_TTL", 10*time.Minute),
+		ResumeGrace:     envDuration("RESUME_GRACE", 2*time.Minute),
+		ResumeQueue:     envInt("RESUME_QUEUE", 100),
+		Auth:            Auth(),
+	}
+}
+
+func Auth() *AuthConfig {
+	mode := envString("AUTH_MODE", AuthOff)
+	if mode == AuthOff {
+		return nil
+	}
+
+	usersFile := os.Getenv("AUTH_USERS_FILE")
+	if usersFile == "" {
+		usersFile = "data/users.json"
+	}
+
+	return &AuthConfig{
+		Mode:          mode,
+		UsersFile:     usersFile,
+		AllowRegister: envBool("AUTH_ALLOW_REGISTER", true),
+		MaxFailures:   envInt("AUTH_MAX_FAILURES", 5),
+		Lockout:       envDuration("AUTH_LOCKOUT", 15*time.Minute),
+	}
+}
+
+// Cluster returns nil unless CLUSTER_ADDR is set.
+func Cluster() *ClusterConfig {
+	addr := os.Getenv("CLUSTER_ADDR")
+	if addr == "" {
+		return nil
+	}
+
+	var peers []string
+	for _, peer := range envList("CLUSTER_PEERS") {
+		if peer != addr {
+			peers = append(peers, peer)
 		}
 	}
-	return &RoomConfig{
-		ByteLimit: byteLimit,
+
+	return &ClusterConfig{
+		NodeID: envString("CLUSTER_NODE_ID", addr),
+		Addr:   addr,
+		Peers:  peers,
+		Secret: os.Getenv("CLUSTER_SECRET"),
+	}
+}
+
+func envString(name string, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// envList splits a comma separated variable, skipping empty entries.
+func envList(name string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(name), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+func envInt(name string, fallback int) int {
+	if value := os.Getenv(name); value != "" {
+		if valueInt, err := strconv.Atoi(value); err == nil {
+			return valueInt
+		}
+	}
+	return fallback
+}
+
+func envFloat(name string, fallback float64) float64 {
+	if value := os.Getenv(name); value != "" {
+		if valueFloat, err := strconv.ParseFloat(value, 64); err == nil {
+			return valueFloat
+		}
+	}
+	return fallback
+}
+
+func envDuration(name string, fallback time.Duration) time.Duration {
+	if value := os.Getenv(name); value != "" {
+		if valueDuration, err := time.ParseDuration(value); err == nil {
+			return valueDuration
+		}
+	}
+	return fallback
+}
+
+func envBool(name string, fallback bool) bool {
+	if value := os.Getenv(name); value != "" {
+		if valueBool, err := strconv.ParseBool(value); err == nil {
+			return valueBool
+		}
 	}
+	return fallback
 }
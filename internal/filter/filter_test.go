@@ -0,0 +1,144 @@
+package filter
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Arun445/tcp-go/internal/message"
+)
+
+func TestPipeline_Rules(t *testing.T) {
+	pipeline, err := New(Config{
+		Rules: []Rule{
+			{Type: RegexRule, Pattern: `(?i)hunter\d`, Action: Redact},
+			{Type: LinkRule, Action: Reject},
+			{Type: CapsRule, Ratio: 0.8, MinLength: 8, Action: Redact},
+			{Type: RepeatRule, Count: 3, Window: "1m", Action: Shadow},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create pipeline: %v", err)
+	}
+
+	tests := []struct {
+		body   string
+		want   string
+		action Action
+	}{
+		{"hello", "hello", ""},
+		{"my password is Hunter2", "my password is ***", Redact},
+		{"buy at https://spam.example now", "buy at https://spam.example now", Reject},
+		{"WHY IS NOBODY ANSWERING", "why is nobody answering", Redact},
+		{"OK", "OK", ""},
+		{"hello", "hello", ""},
+		{"Hello ", "Hello ", Shadow},
+	}
+
+	session := &state{}
+	now := time.Now()
+	for _, test := range tests {
+		body, action, _ := pipeline.check(session, "alice", []byte(test.body), now)
+		if string(body) != test.want || action != test.action {
+			t.Errorf("%q: expected %q %q, got %q %q", test.body, test.want, test.action, body, action)
+		}
+		now = now.Add(time.Second)
+	}
+}
+
+func TestPipeline_FloodMute(t *testing.T) {
+	pipeline, _ := New(Config{
+		Rules:     []Rule{{Type: FloodRule, Count: 3, Window: "10s", Action: Reject}},
+		MuteAfter: 2,
+		MuteFor:   "1m",
+	})
+	go pipeline.Open()
+
+	session := &state{}
+	now := time.Now()
+	var reason string
+	for i := 0; i < 4; i++ {
+		_, _, reason = pipeline.check(session, "alice", []byte("msg"), now)
+	}
+	if !strings.Contains(reason, "muted for 1m0s") {
+		t.Fatalf("Expected second flood violation to mute, got %q", reason)
+	}
+
+	later := now.Add(30 * time.Second)
+	if _, action, reason := pipeline.check(session, "alice", []byte("calm now"), later); action != Reject || !strings.Contains(reason, "You are muted for 30s") {
+		t.Errorf("Expected muted session to be rejected, got %q %q", action, reason)
+	}
+	// The mute belongs to the key, not to the session that earned it.
+	if _, action, _ := pipeline.check(&state{}, "alice", []byte("calm now"), later); action != Reject {
+		t.Errorf("Expected the mute to hold for a new session, got %q", action)
+	}
+	if _, action, _ := pipeline.check(&state{}, "bob", []byte("calm now"), later); action != "" {
+		t.Errorf("Expected another key to be free, got %q", action)
+	}
+	if _, action, _ := pipeline.check(session, "alice", []byte("calm now"), now.Add(2*time.Minute)); action != "" {
+		t.Errorf("Expected mute to expire, got %q", action)
+	}
+}
+
+func TestPipeline_Run(t *testing.T) {
+	pipeline, _ := New(Config{Rules: []Rule{{Type: RegexRule, Pattern: "spam", Action: Shadow}}})
+
+	in := make(chan message.Message)
+	out := make(chan message.Message, 10)
+	go func() {
+		pipeline.Run("alice", in, out)
+		close(out)
+	}()
+	in <- message.Message{Body: []byte("spam\n")}
+	in <- message.Message{Body: []byte("/msg bob spam\n")}
+	in <- message.Message{Body: []byte("/nick spam\n")}
+	in <- message.Message{Body: []byte("hi\n")}
+	close(in)
+
+	var bodies []string
+	for m := range out {
+		bodies = append(bodies, string(m.Body))
+	}
+	if strings.Join(bodies, "") != "/nick spam\nhi\n" {
+		t.Errorf("Expected shadowed message and private message dropped and command passed, got %q", bodies)
+	}
+}
+
+func TestPipeline_RunCommandText(t *testing.T) {
+	pipeline, _ := New(Config{Rules: []Rule{{Type: RegexRule, Pattern: "darn", Action: Redact}}})
+
+	in := make(chan message.Message)
+	out := make(chan message.Message, 10)
+	go func() {
+		pipeline.Run("alice", in, out)
+		close(out)
+	}()
+	in <- message.Message{Body: []byte("/edit 5 darn it\n")}
+	in <- message.Message{Body: []byte("/edit darn\n")}
+	in <- message.Message{Body: []byte("/topic darn meetings\n")}
+	in <- message.Message{Body: []byte("/msg bob darn\n")}
+	close(in)
+
+	var bodies []string
+	for m := range out {
+		bodies = append(bodies, string(m.Body))
+	}
+	if strings.Join(bodies, "") != "/edit 5 *** it\n/edit darn\n/topic *** meetings\n/msg bob ***\n" {
+		t.Errorf("Expected command texts redacted and the bad usage passed, got %q", bodies)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	if pipeline, err := Load(filepath.Join(dir, "missing.json")); err != nil || len(pipeline.rules) != 0 {
+		t.Errorf("Expected missing file to yield an empty pipeline, got %v", err)
+	}
+
+	path := filepath.Join(dir, "filters.json")
+	os.WriteFile(path, []byte(`{"rules": [{"type": "flood", "count": 5, "window": "5s", "action": "redact"}]}`), 0o600)
+	if _, err := Load(path); err == nil || !strings.Contains(err.Error(), "cannot redact") {
+		t.Errorf("Expected redacting flood rule to be refused, got %v", err)
+	}
+}
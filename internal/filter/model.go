@@ -0,0 +1,100 @@
+package filter
+
+import (
+	"regexp"
+	"time"
+
+	"github.com/Arun445/tcp-go/internal/clock"
+)
+
+type Action string
+
+const (
+	// Reject refuses the message and tells the sender why.
+	Reject Action = "reject"
+	// Redact rewrites the offending part and lets the message through.
+	Redact Action = "redact"
+	// Shadow drops the message without telling the sender.
+	Shadow Action = "shadow"
+)
+
+const (
+	RegexRule  = "regex"
+	RepeatRule = "repeat"
+	CapsRule   = "caps"
+	FloodRule  = "flood"
+	LinkRule   = "link"
+)
+
+type Rule struct {
+	Type   string `json:"type"`
+	Action Action `json:"action"`
+	// Pattern is the expression of a regex rule.
+	Pattern string `json:"pattern,omitempty"`
+	// Count and Window bound repeat and flood rules: at most Count-1 equal
+	// messages, or messages at all, within Window.
+	Count  int    `json:"count,omitempty"`
+	Window string `json:"window,omitempty"`
+	// Ratio and MinLength tune the caps rule.
+	Ratio     float64 `json:"ratio,omitempty"`
+	MinLength int     `json:"min_length,omitempty"`
+
+	pattern *regexp.Regexp
+	window  time.Duration
+}
+
+type Config struct {
+	Rules []Rule `json:"rules"`
+	// After MuteAfter violations a session is muted for MuteFor. Zero
+	// disables muting.
+	MuteAfter int    `json:"mute_after,omitempty"`
+	MuteFor   string `json:"mute_for,omitempty"`
+}
+
+// Pipeline is the parsed, read-only rule set shared by every session. Each
+// session keeps its recent messages in the goroutine running Run. Violations
+// and mutes belong to the key Run was given and are only touched by the Open
+// goroutine, so they outlast the session.
+type Pipeline struct {
+	rules     []Rule
+	muteAfter int
+	muteFor   time.Duration
+	// history is how long sent messages are remembered for repeat and
+	// flood rules.
+	history time.Duration
+	clock   clock.Clock
+
+	violations chan violation
+	lookups    chan lookup
+	stop       chan struct{}
+	offenders  map[string]offender
+}
+
+type state struct {
+	recent []sent
+}
+
+// offender is what a key has done against the rules lately.
+type offender struct {
+	violations int
+	last       time.Time
+	mutedUntil time.Time
+}
+
+// violation counts one against Key. The reply is when the mute it earned
+// ends, or zero.
+type violation struct {
+	Key   string
+	Now   time.Time
+	Reply chan time.Time
+}
+
+type lookup struct {
+	Key   string
+	Reply chan time.Time
+}
+
+type sent struct {
+	Body string
+	Time time.Time
+}
@@ -0,0 +1,314 @@
+package filter
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/Arun445/tcp-go/internal/clock"
+	"github.com/Arun445/tcp-go/internal/message"
+	"github.com/Arun445/tcp-go/internal/metrics"
+)
+
+var links = regexp.MustCompile(`(?i)\b(?:https?://|www\.)\S+`)
+
+// forgetAfter is how long violations are kept without another one.
+const forgetAfter = time.Hour
+
+// Load reads the filter rules. A missing file yields a pipeline that lets
+// everything through.
+func Load(path string) (*Pipeline, error) {
+	var filterConfig Config
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return New(filterConfig)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &filterConfig); err != nil {
+		return nil, err
+	}
+	return New(filterConfig)
+}
+
+func New(filterConfig Config) (*Pipeline, error) {
+	pipeline := &Pipeline{
+		muteAfter:  filterConfig.MuteAfter,
+		clock:      clock.Real(),
+		violations: make(chan violation),
+		lookups:    make(chan lookup),
+		stop:       make(chan struct{}),
+		offenders:  make(map[string]offender),
+	}
+	if filterConfig.MuteFor != "" {
+		muteFor, err := time.ParseDuration(filterConfig.MuteFor)
+		if err != nil {
+			return nil, fmt.Errorf("mute_for: %w", err)
+		}
+		pipeline.muteFor = muteFor
+	}
+
+	for i, rule := range filterConfig.Rules {
+		if err := rule.parse(); err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i+1, err)
+		}
+		if rule.window > pipeline.history {
+			pipeline.history = rule.window
+		}
+		pipeline.rules = append(pipeline.rules, rule)
+	}
+	return pipeline, nil
+}
+
+// SetClock replaces the system clock used for flood windows and mutes. It
+// must be called before Run.
+func (pipeline *Pipeline) SetClock(c clock.Clock) {
+	pipeline.clock = c
+}
+
+func (rule *Rule) parse() error {
+	if rule.Action != Reject && rule.Action != Redact && rule.Action != Shadow {
+		return fmt.Errorf("unknown action %q", rule.Action)
+	}
+
+	switch rule.Type {
+	case RegexRule:
+		pattern, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return err
+		}
+		rule.pattern = pattern
+	case LinkRule:
+		rule.pattern = links
+	case CapsRule:
+		if rule.Ratio <= 0 || rule.Ratio > 1 {
+			return errors.New("caps ratio must be between 0 and 1")
+		}
+	case RepeatRule, FloodRule:
+		if rule.Action == Redact {
+			return fmt.Errorf("%s rules cannot redact", rule.Type)
+		}
+		window, err := time.ParseDuration(rule.Window)
+		if err != nil {
+			return fmt.Errorf("window: %w", err)
+		}
+		if rule.Count < 1 || window <= 0 {
+			return errors.New("count and window must be positive")
+		}
+		rule.window = window
+	default:
+		return fmt.Errorf("unknown rule type %q", rule.Type)
+	}
+	return nil
+}
+
+// Open keeps the violations and mutes of every key. It must be running
+// while sessions are filtered.
+func (pipeline *Pipeline) Open() {
+	for {
+		select {
+		case req := <-pipeline.violations:
+			req.Reply <- pipeline.record(req.Key, req.Now)
+		case req := <-pipeline.lookups:
+			req.Reply <- pipeline.offenders[req.Key].mutedUntil
+		case <-pipeline.stop:
+			return
+		}
+	}
+}
+
+// Close stops Open once no session is filtered any more.
+func (pipeline *Pipeline) Close() {
+	close(pipeline.stop)
+}
+
+// record counts a violation against key and mutes it once it has
+// muteAfter of them, returning when that mute ends. Keys nobody has heard
+// from for forgetAfter are dropped on the way.
+func (pipeline *Pipeline) record(key string, now time.Time) time.Time {
+	for other, o := range pipeline.offenders {
+		if !now.Before(o.mutedUntil) && now.Sub(o.last) >= forgetAfter {
+			delete(pipeline.offenders, other)
+		}
+	}
+
+	o := pipeline.offenders[key]
+	o.violations++
+	o.last = now
+	var until time.Time
+	if o.violations >= pipeline.muteAfter {
+		o.violations = 0
+		o.mutedUntil = now.Add(pipeline.muteFor)
+		until = o.mutedUntil
+	}
+	pipeline.offenders[key] = o
+	return until
+}
+
+// violate counts a violation against key, returning when the mute it
+// earned ends, or zero. Without muting there is nothing to count.
+func (pipeline *Pipeline) violate(key string, now time.Time) time.Time {
+	if pipeline.muteAfter <= 0 {
+		return time.Time{}
+	}
+	reply := make(chan time.Time, 1)
+	pipeline.violations <- violation{Key: key, Now: now, Reply: reply}
+	return <-reply
+}
+
+// mutedUntil returns when key's mute ends, zero if it never was.
+func (pipeline *Pipeline) mutedUntil(key string) time.Time {
+	if pipeline.muteAfter <= 0 {
+		return time.Time{}
+	}
+	reply := make(chan time.Time, 1)
+	pipeline.lookups <- lookup{Key: key, Reply: reply}
+	return <-reply
+}
+
+// Run filters one session's messages from in to out until in is closed.
+// Key is who violations and mutes count against, so that they outlast the
+// session: its account or its address. Commands pass untouched, except for
+// the text of those that carry one. Rejected messages go out with Filtered
+// set, so the room can tell the sender, and shadowed ones are dropped.
+func (pipeline *Pipeline) Run(key string, in <-chan message.Message, out chan<- message.Message) {
+	session := &state{}
+	for m := range in {
+		if head, text, ok := split(m.Body); len(pipeline.rules) > 0 && ok {
+			var action Action
+			text, action, m.Filtered = pipeline.check(session, key, text, pipeline.clock.Now())
+			m.Body = text
+			if head != nil {
+				m.Body = append(head, text...)
+			}
+			if action != "" {
+				metrics.MessagesFiltered.Add(string(action), 1)
+			}
+			if action == Shadow {
+				continue
+			}
+		}
+		out <- m
+	}
+}
+
+// texts maps the commands that carry text for others to read to how many
+// words come before that text.
+var texts = map[string]int{
+	"/msg":   2,
+	"/edit":  2,
+	"/topic": 1,
+}
+
+// split separates the text to filter from what comes before it: nothing for
+// a message, the command and its arguments for a command in texts. It
+// reports false for any other command.
+func split(body []byte) ([]byte, []byte, bool) {
+	if !bytes.HasPrefix(body, []byte("/")) {
+		return nil, body, true
+	}
+	command, _, _ := bytes.Cut(bytes.TrimRight(body, "\r\n"), []byte(" "))
+	words, ok := texts[string(command)]
+	if !ok {
+		return nil, nil, false
+	}
+	fields := bytes.SplitN(body, []byte(" "), words+1)
+	if len(fields) <= words {
+		return nil, nil, false
+	}
+	n := len(body) - len(fields[words])
+	return body[:n:n], fields[words], true
+}
+
+// check applies every rule in order. The first rule that rejects or shadows
+// decides; redactions accumulate.
+func (pipeline *Pipeline) check(session *state, key string, body []byte, now time.Time) ([]byte, Action, string) {
+	if until := pipeline.mutedUntil(key); now.Before(until) {
+		return body, Reject, fmt.Sprintf("You are muted for %s", until.Sub(now).Round(time.Second))
+	}
+	session.remember(string(body), now, pipeline.history)
+
+	var taken Action
+	for _, rule := range pipeline.rules {
+		redacted, matched := rule.match(session, body, now)
+		if !matched {
+			continue
+		}
+
+		reason := fmt.Sprintf("Message blocked by the %s filter", rule.Type)
+		if until := pipeline.violate(key, now); !until.IsZero() {
+			reason += fmt.Sprintf(", you are muted for %s", pipeline.muteFor)
+			if rule.Action == Redact {
+				return body, Reject, reason
+			}
+		}
+
+		switch rule.Action {
+		case Redact:
+			body = redacted
+			taken = Redact
+		default:
+			return body, rule.Action, reason
+		}
+	}
+	return body, taken, ""
+}
+
+// match reports whether the rule applies to body and, for rules that can
+// redact, what the body looks like afterwards.
+func (rule Rule) match(session *state, body []byte, now time.Time) ([]byte, bool) {
+	switch rule.Type {
+	case RegexRule:
+		return rule.pattern.ReplaceAll(body, []byte("***")), rule.pattern.Match(body)
+	case LinkRule:
+		return rule.pattern.ReplaceAll(body, []byte("[link removed]")), rule.pattern.Match(body)
+	case CapsRule:
+		letters, upper := 0, 0
+		for _, r := range string(body) {
+			if unicode.IsLetter(r) {
+				letters++
+				if unicode.IsUpper(r) {
+					upper++
+				}
+			}
+		}
+		matched := letters >= rule.MinLength && letters > 0 && float64(upper)/float64(letters) >= rule.Ratio
+		return bytes.ToLower(body), matched
+	case RepeatRule:
+		text := normalize(string(body))
+		return body, session.count(now.Add(-rule.window), func(s sent) bool { return normalize(s.Body) == text }) >= rule.Count
+	case FloodRule:
+		return body, session.count(now.Add(-rule.window), func(sent) bool { return true }) >= rule.Count
+	}
+	return body, false
+}
+
+func (session *state) remember(body string, now time.Time, keep time.Duration) {
+	cutoff := now.Add(-keep)
+	i := 0
+	for i < len(session.recent) && session.recent[i].Time.Before(cutoff) {
+		i++
+	}
+	session.recent = append(session.recent[i:], sent{Body: body, Time: now})
+}
+
+func (session *state) count(since time.Time, matches func(sent) bool) int {
+	n := 0
+	for _, s := range session.recent {
+		if !s.Time.Before(since) && matches(s) {
+			n++
+		}
+	}
+	return n
+}
+
+func normalize(body string) string {
+	return strings.ToLower(strings.TrimSpace(body))
+}
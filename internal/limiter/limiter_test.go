@@ -0,0 +1,97 @@
+package limiter
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/Arun445/tcp-go/internal/config"
+)
+
+func addr(ip string) net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP(ip), Port: 40000}
+}
+
+func TestLimiter_PerIP(t *testing.T) {
+	limiter := NewLimiter(&config.ServerConfig{MaxPerIP: 2, CIDRPrefixV4: 24, CIDRPrefixV6: 64})
+	go limiter.Open()
+
+	release, err := limiter.Acquire(addr("10.0.0.1"))
+	if err != nil {
+		t.Fatalf("Expected first connection to be admitted, got %v", err)
+	}
+	if _, err := limiter.Acquire(addr("10.0.0.1")); err != nil {
+		t.Fatalf("Expected second connection to be admitted, got %v", err)
+	}
+	if _, err := limiter.Acquire(addr("10.0.0.1")); !errors.Is(err, ErrTooManyFromIP) {
+		t.Errorf("Expected ErrTooManyFromIP, got %v", err)
+	}
+	if _, err := limiter.Acquire(addr("10.0.0.2")); err != nil {
+		t.Errorf("Expected other address to be admitted, got %v", err)
+	}
+
+	release()
+	if _, err := limiter.Acquire(addr("10.0.0.1")); err != nil {
+		t.Errorf("Expected connection after release to be admitted, got %v", err)
+	}
+}
+
+func TestLimiter_PerCIDR(t *testing.T) {
+	limiter := NewLimiter(&config.ServerConfig{MaxPerCIDR: 2, CIDRPrefixV4: 24, CIDRPrefixV6: 64})
+	go limiter.Open()
+
+	limiter.Acquire(addr("10.0.0.1"))
+	limiter.Acquire(addr("10.0.0.2"))
+	if _, err := limiter.Acquire(addr("10.0.0.3")); !errors.Is(err, ErrTooManyFromNet) {
+		t.Errorf("Expected ErrTooManyFromNet, got %v", err)
+	}
+	if _, err := limiter.Acquire(addr("10.0.1.1")); err != nil {
+		t.Errorf("Expected other network to be admitted, got %v", err)
+	}
+
+	limiter.Acquire(addr("2001:db8::1"))
+	limiter.Acquire(addr("2001:db8::2"))
+	if _, err := limiter.Acquire(addr("2001:db8::3")); !errors.Is(err, ErrTooManyFromNet) {
+		t.Errorf("Expected ErrTooManyFromNet for IPv6, got %v", err)
+	}
+}
+
+func TestLimiter_MaxConnections(t *testing.T) {
+	limiter := NewLimiter(&config.ServerConfig{MaxConnections: 1, CIDRPrefixV4: 24, CIDRPrefixV6: 64})
+	go limiter.Open()
+
+	limiter.Acquire(addr("10.0.0.1"))
+	if _, err := limiter.Acquire(addr("192.168.0.1")); !errors.Is(err, ErrServerFull) {
+		t.Errorf("Expected ErrServerFull, got %v", err)
+	}
+}
+
+func TestLimiter_AcceptRate(t *testing.T) {
+	limiter := NewLimiter(&config.ServerConfig{AcceptRate: 1, AcceptBurst: 3, CIDRPrefixV4: 24, CIDRPrefixV6: 64})
+	go limiter.Open()
+
+	for i := 0; i < 3; i++ {
+		if _, err := limiter.Acquire(addr("10.0.0.1")); err != nil {
+			t.Fatalf("Expected burst connection %d to be admitted, got %v", i, err)
+		}
+	}
+	if _, err := limiter.Acquire(addr("10.0.0.1")); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("Expected ErrRateLimited, got %v", err)
+	}
+}
+
+func TestLimiter_AcceptRate_AfterCounts(t *testing.T) {
+	limiter := NewLimiter(&config.ServerConfig{MaxPerIP: 1, AcceptRate: 0.001, AcceptBurst: 2, CIDRPrefixV4: 24, CIDRPrefixV6: 64})
+	go limiter.Open()
+
+	limiter.Acquire(addr("10.0.0.1"))
+	for i := 0; i < 5; i++ {
+		if _, err := limiter.Acquire(addr("10.0.0.1")); !errors.Is(err, ErrTooManyFromIP) {
+			t.Fatalf("Expected ErrTooManyFromIP, got %v", err)
+		}
+	}
+	// The refused connections took no token, so the burst still has one.
+	if _, err := limiter.Acquire(addr("10.0.0.2")); err != nil {
+		t.Errorf("Expected another address to be admitted, got %v", err)
+	}
+}
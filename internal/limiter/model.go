@@ -0,0 +1,34 @@
+package limiter
+
+import (
+	"errors"
+	"time"
+
+	"github.com/Arun445/tcp-go/internal/config"
+)
+
+var (
+	ErrServerFull     = errors.New("server is full")
+	ErrTooManyFromIP  = errors.New("too many connections from your address")
+	ErrTooManyFromNet = errors.New("too many connections from your network")
+	ErrRateLimited    = errors.New("too many connection attempts, slow down")
+)
+
+// Limiter tracks open connections and the accept rate. Its state is only
+// touched by the Open goroutine.
+type Limiter struct {
+	config   *config.ServerConfig
+	acquires chan acquire
+	releases chan string
+	stop     chan struct{}
+	perIP    map[string]int
+	perCIDR  map[string]int
+	total    int
+	tokens   float64
+	last     time.Time
+}
+
+type acquire struct {
+	IP    string
+	Reply chan error
+}
@@ -0,0 +1,118 @@
+package limiter
+
+import (
+	"net"
+	"time"
+
+	"github.com/Arun445/tcp-go/internal/config"
+)
+
+func NewLimiter(serverConfig *config.ServerConfig) *Limiter {
+	return &Limiter{
+		config:   serverConfig,
+		acquires: make(chan acquire),
+		releases: make(chan string),
+		stop:     make(chan struct{}),
+		perIP:    make(map[string]int),
+		perCIDR:  make(map[string]int),
+		tokens:   float64(serverConfig.AcceptBurst),
+		last:     time.Now(),
+	}
+}
+
+func (limiter *Limiter) Open() {
+	for {
+		select {
+		case req := <-limiter.acquires:
+			req.Reply <- limiter.admit(req.IP)
+		case ip := <-limiter.releases:
+			limiter.total--
+			limiter.decrement(limiter.perIP, ip)
+			limiter.decrement(limiter.perCIDR, limiter.network(ip))
+		case <-limiter.stop:
+			return
+		}
+	}
+}
+
+// Close stops Open once every admitted connection has been released.
+func (limiter *Limiter) Close() {
+	close(limiter.stop)
+}
+
+// Acquire admits a new connection from addr. On success the returned
+// function must be called once the connection is gone.
+func (limiter *Limiter) Acquire(addr net.Addr) (func(), error) {
+	ip := hostOf(addr)
+	reply := make(chan error, 1)
+	limiter.acquires <- acquire{IP: ip, Reply: reply}
+	if err := <-reply; err != nil {
+		return nil, err
+	}
+	return func() { limiter.releases <- ip }, nil
+}
+
+// admit checks the connection counts before taking an accept token, so
+// connections refused for another reason do not use up the rate.
+func (limiter *Limiter) admit(ip string) error {
+	network := limiter.network(ip)
+	if limiter.config.MaxConnections > 0 && limiter.total >= limiter.config.MaxConnections {
+		return ErrServerFull
+	}
+	if limiter.config.MaxPerIP > 0 && limiter.perIP[ip] >= limiter.config.MaxPerIP {
+		return ErrTooManyFromIP
+	}
+	if limiter.config.MaxPerCIDR > 0 && limiter.perCIDR[network] >= limiter.config.MaxPerCIDR {
+		return ErrTooManyFromNet
+	}
+
+	if limiter.config.AcceptRate > 0 {
+		now := time.Now()
+		limiter.tokens += now.Sub(limiter.last).Seconds() * limiter.config.AcceptRate
+		if burst := float64(limiter.config.AcceptBurst); limiter.tokens > burst {
+			limiter.tokens = burst
+		}
+		limiter.last = now
+		if limiter.tokens < 1 {
+			return ErrRateLimited
+		}
+		limiter.tokens--
+	}
+
+	limiter.total++
+	limiter.perIP[ip]++
+	limiter.perCIDR[network]++
+	return nil
+}
+
+func (limiter *Limiter) decrement(counts map[string]int, key string) {
+	counts[key]--
+	if counts[key] <= 0 {
+		delete(counts, key)
+	}
+}
+
+// network maps an IP to the CIDR block it is counted against.
+func (limiter *Limiter) network(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ip
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		mask := net.CIDRMask(limiter.config.CIDRPrefixV4, 32)
+		return (&net.IPNet{IP: v4.Mask(mask), Mask: mask}).String()
+	}
+	mask := net.CIDRMask(limiter.config.CIDRPrefixV6, 128)
+	return (&net.IPNet{IP: parsed.Mask(mask), Mask: mask}).String()
+}
+
+func hostOf(addr net.Addr) string {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		return tcpAddr.IP.String()
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
@@ -0,0 +1,106 @@
+package mailbox
+
+import (
+	"testing"
+	"time"
+)
+
+func open(t *testing.T, dir string, size int) *Store {
+	t.Helper()
+	store, err := NewStore(dir, size, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	go store.Open()
+	t.Cleanup(store.Close)
+	return store
+}
+
+func TestStore_EnqueueDrain_Order(t *testing.T) {
+	store := open(t, t.TempDir(), 10)
+
+	for _, body := range []string{"first", "second", "third"} {
+		store.Enqueue("bob", Entry{From: "alice", Body: body, Time: time.Now()})
+	}
+
+	entries := store.Drain("bob")
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 entries, got %d", len(entries))
+	}
+	for i, body := range []string{"first", "second", "third"} {
+		if entries[i].Body != body {
+			t.Errorf("Entry %d: expected '%s', got '%s'", i, body, entries[i].Body)
+		}
+	}
+
+	if entries := store.Drain("bob"); len(entries) != 0 {
+		t.Errorf("Expected empty mailbox after drain, got %d entries", len(entries))
+	}
+}
+
+func TestStore_SizeLimit(t *testing.T) {
+	store := open(t, t.TempDir(), 2)
+
+	for _, body := range []string{"first", "second", "third"} {
+		store.Enqueue("bob", Entry{Body: body, Time: time.Now()})
+	}
+
+	entries := store.Drain("bob")
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(entries))
+	}
+	if entries[0].Body != "second" || entries[1].Body != "third" {
+		t.Errorf("Expected oldest entry to be dropped, got %v", entries)
+	}
+}
+
+func TestStore_AgeLimit(t *testing.T) {
+	store := open(t, t.TempDir(), 10)
+
+	store.Enqueue("bob", Entry{Body: "stale", Time: time.Now().Add(-2 * time.Hour)})
+	store.Enqueue("bob", Entry{Body: "fresh", Time: time.Now()})
+
+	entries := store.Drain("bob")
+	if len(entries) != 1 || entries[0].Body != "fresh" {
+		t.Errorf("Expected only fresh entry, got %v", entries)
+	}
+}
+
+func TestStore_Persistence(t *testing.T) {
+	dir := t.TempDir()
+	store := open(t, dir, 10)
+
+	if store.Known("bob") {
+		t.Error("Expected bob to be unknown")
+	}
+	store.Touch("bob")
+	store.Touch("carol")
+	store.Enqueue("carol", Entry{From: "alice", Body: "hi", Time: time.Now()})
+	store.Sync()
+
+	reopened := open(t, dir, 10)
+	if !reopened.Known("bob") {
+		t.Error("Expected bob to be known after reopening the store")
+	}
+	if entries := reopened.Drain("carol"); len(entries) != 1 || entries[0].Body != "hi" {
+		t.Errorf("Expected the queued entry to survive reopening, got %v", entries)
+	}
+}
+
+func TestStore_AmendRemove(t *testing.T) {
+	store := open(t, t.TempDir(), 10)
+
+	store.Enqueue("bob", Entry{From: "alice", Body: "private", Time: time.Now()})
+	store.Enqueue("bob", Entry{ID: 7, From: "alice", Body: "@bob hi", Time: time.Now()})
+	store.Enqueue("carol", Entry{ID: 7, From: "alice", Body: "@bob hi", Time: time.Now()})
+	store.Enqueue("carol", Entry{ID: 8, From: "alice", Body: "@carol hi", Time: time.Now()})
+
+	store.Amend(7, "@bob hello")
+	if entries := store.Drain("bob"); len(entries) != 2 || entries[1].Body != "@bob hello" {
+		t.Errorf("Expected the mention to be amended, got %v", entries)
+	}
+	store.Remove(7)
+	if entries := store.Drain("carol"); len(entries) != 1 || entries[0].ID != 8 {
+		t.Errorf("Expected only the other mention to remain, got %v", entries)
+	}
+}
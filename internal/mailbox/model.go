@@ -0,0 +1,58 @@
+package mailbox
+
+import "time"
+
+// Entry is a queued message. ID is the room broadcast it mentions, zero for
+// a private message.
+type Entry struct {
+	ID   uint64    `json:"id,omitempty"`
+	From string    `json:"from"`
+	Body string    `json:"body"`
+	Time time.Time `json:"time"`
+}
+
+// Store keeps one persistent queue per identity. Queues are held in memory
+// by the Open goroutine and written behind by another, so rooms never wait
+// for the disk.
+type Store struct {
+	dir      string
+	size     int
+	maxAge   time.Duration
+	queues   map[string][]Entry
+	dirty    map[string]bool
+	requests chan request
+	stop     chan struct{}
+}
+
+type requestType int
+
+const (
+	known requestType = iota
+	touch
+	enqueue
+	drain
+	amend
+	remove
+	flush
+)
+
+type request struct {
+	Type     requestType
+	Identity string
+	Entry    Entry
+	ID       uint64
+	Reply    chan response
+}
+
+type response struct {
+	Known   bool
+	Entries []Entry
+}
+
+// write is a queue handed to the writer. Done, when set instead, is
+// answered once everything before it is written.
+type write struct {
+	Identity string
+	Entries  []Entry
+	Done     chan<- response
+}
@@ -0,0 +1,230 @@
+package mailbox
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// NewStore loads the queues kept in dir.
+func NewStore(dir string, size int, maxAge time.Duration) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	store := &Store{
+		dir:      dir,
+		size:     size,
+		maxAge:   maxAge,
+		queues:   make(map[string][]Entry),
+		dirty:    make(map[string]bool),
+		requests: make(chan request),
+		stop:     make(chan struct{}),
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		identity, ok := strings.CutSuffix(file.Name(), ".json")
+		if !ok || file.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var entries []Entry
+		if err := json.Unmarshal(data, &entries); err != nil {
+			return nil, err
+		}
+		store.queues[identity] = entries
+	}
+	return store, nil
+}
+
+func (store *Store) Open() {
+	writes := make(chan write)
+	go store.write(writes)
+
+	for {
+		// Offer one changed queue to the writer at a time, so a queue that
+		// changes again before it is written is only written once.
+		var out chan<- write
+		var next write
+		for identity := range store.dirty {
+			out = writes
+			next = write{Identity: identity, Entries: store.queues[identity]}
+			break
+		}
+
+		select {
+		case req := <-store.requests:
+			store.handle(req, writes)
+		case out <- next:
+			delete(store.dirty, next.Identity)
+		case <-store.stop:
+			close(writes)
+			return
+		}
+	}
+}
+
+// Close writes every change made so far and stops Open. The store may not
+// be used after.
+func (store *Store) Close() {
+	store.Sync()
+	close(store.stop)
+}
+
+func (store *Store) handle(req request, writes chan<- write) {
+	switch req.Type {
+	case known:
+		_, ok := store.queues[req.Identity]
+		req.Reply <- response{Known: ok}
+
+	case touch:
+		if _, ok := store.queues[req.Identity]; !ok {
+			store.queues[req.Identity] = []Entry{}
+			store.dirty[req.Identity] = true
+		}
+		req.Reply <- response{}
+
+	case enqueue:
+		entries := append(store.fresh(req.Identity), req.Entry)
+		if store.size > 0 && len(entries) > store.size {
+			entries = entries[len(entries)-store.size:]
+		}
+		store.queues[req.Identity] = entries
+		store.dirty[req.Identity] = true
+		req.Reply <- response{}
+
+	case drain:
+		entries := store.fresh(req.Identity)
+		if len(entries) > 0 || len(store.queues[req.Identity]) > 0 {
+			store.queues[req.Identity] = []Entry{}
+			store.dirty[req.Identity] = true
+		}
+		req.Reply <- response{Entries: entries}
+
+	case amend, remove:
+		for identity, entries := range store.queues {
+			changed := false
+			kept := make([]Entry, 0, len(entries))
+			for _, entry := range entries {
+				if entry.ID != req.ID {
+					kept = append(kept, entry)
+					continue
+				}
+				changed = true
+				if req.Type == amend {
+					entry.Body = req.Entry.Body
+					kept = append(kept, entry)
+				}
+			}
+			if changed {
+				store.queues[identity] = kept
+				store.dirty[identity] = true
+			}
+		}
+		req.Reply <- response{}
+
+	case flush:
+		for identity := range store.dirty {
+			writes <- write{Identity: identity, Entries: store.queues[identity]}
+			delete(store.dirty, identity)
+		}
+		writes <- write{Done: req.Reply}
+	}
+}
+
+// Known reports whether the identity has ever been registered.
+func (store *Store) Known(identity string) bool {
+	return store.do(request{Type: known, Identity: identity}).Known
+}
+
+// Touch marks the identity as known, creating an empty mailbox.
+func (store *Store) Touch(identity string) {
+	store.do(request{Type: touch, Identity: identity})
+}
+
+func (store *Store) Enqueue(identity string, entry Entry) {
+	store.do(request{Type: enqueue, Identity: identity, Entry: entry})
+}
+
+// Drain returns queued entries oldest first and empties the mailbox.
+func (store *Store) Drain(identity string) []Entry {
+	return store.do(request{Type: drain, Identity: identity}).Entries
+}
+
+// Amend replaces the body of every entry queued from broadcast id.
+func (store *Store) Amend(id uint64, body string) {
+	store.do(request{Type: amend, ID: id, Entry: Entry{Body: body}})
+}
+
+// Remove drops every entry queued from broadcast id.
+func (store *Store) Remove(id uint64) {
+	store.do(request{Type: remove, ID: id})
+}
+
+// Sync returns once every change made so far is on disk.
+func (store *Store) Sync() {
+	store.do(request{Type: flush})
+}
+
+func (store *Store) do(req request) response {
+	req.Reply = make(chan response, 1)
+	store.requests <- req
+	return <-req.Reply
+}
+
+// fresh returns a copy of the queue without entries older than maxAge. The
+// queue itself may be shared with the writer, so it is never changed in
+// place.
+func (store *Store) fresh(identity string) []Entry {
+	entries := store.queues[identity]
+	cutoff := time.Now().Add(-store.maxAge)
+	kept := make([]Entry, 0, len(entries)+1)
+	for _, entry := range entries {
+		if store.maxAge <= 0 || entry.Time.After(cutoff) {
+			kept = append(kept, entry)
+		}
+	}
+	return kept
+}
+
+// write persists the queues handed over by Open, one file per identity.
+func (store *Store) write(writes <-chan write) {
+	for w := range writes {
+		if w.Done != nil {
+			w.Done <- response{}
+			continue
+		}
+		if err := store.save(w.Identity, w.Entries); err != nil {
+			log.Printf("Failed to save mailbox of %s: %v", w.Identity, err)
+		}
+	}
+}
+
+func (store *Store) save(identity string, entries []Entry) error {
+	if entries == nil {
+		entries = []Entry{}
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+
+	tmp := store.path(identity) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, store.path(identity))
+}
+
+func (store *Store) path(identity string) string {
+	return filepath.Join(store.dir, identity+".json")
+}
@@ -0,0 +1,89 @@
+package message
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"sync"
+)
+
+// maxPooled keeps unusually large buffers out of the pool.
+const maxPooled = 64 * 1024
+
+var buffers = sync.Pool{
+	New: func() any { return &Buffer{data: make([]byte, 0, 512), pooled: true} },
+}
+
+// NewBuffer wraps data for a single holder. data must not be changed
+// afterwards.
+func NewBuffer(data []byte) *Buffer {
+	b := &Buffer{data: data}
+	b.refs.Store(1)
+	return b
+}
+
+// Shared encodes a room message once for every session using the given
+// protocol. Text clients share the body itself, structured ones a pooled
+// JSON line.
+func Shared(room string, m Message, structured bool) *Buffer {
+	if !structured {
+		return NewBuffer(m.Body)
+	}
+
+	b := buffers.Get().(*Buffer)
+	b.refs.Store(1)
+	at := m.Time
+	w := bytes.NewBuffer(b.data[:0])
+	err := json.NewEncoder(w).Encode(Event{
+		Type: "message",
+		ID:   m.ID,
+		Seq:  m.Seq,
+		Room: room,
+		From: m.From,
+		Time: &at,
+		Body: strings.TrimRight(string(m.Body), "\r\n"),
+	})
+	if err != nil {
+		b.data = EncodeEvent(Event{Type: "error", Text: err.Error()})
+		return b
+	}
+	b.data = w.Bytes()
+	return b
+}
+
+func (b *Buffer) Bytes() []byte {
+	return b.data
+}
+
+func (b *Buffer) Len() int {
+	return len(b.data)
+}
+
+func (b *Buffer) String() string {
+	return string(b.data)
+}
+
+// Retain adds a holder, who must call Release in turn.
+func (b *Buffer) Retain() *Buffer {
+	b.refs.Add(1)
+	return b
+}
+
+// Release drops a holder. The last release of a pooled buffer returns it to
+// the pool, so its bytes must not be used afterwards.
+func (b *Buffer) Release() {
+	if b.refs.Add(-1) != 0 || !b.pooled {
+		return
+	}
+	if cap(b.data) > maxPooled {
+		return
+	}
+	b.data = b.data[:0]
+	buffers.Put(b)
+}
+
+// Copy returns the bytes in memory the caller owns, for keeping a message
+// beyond the release of its buffer.
+func (b *Buffer) Copy() []byte {
+	return append([]byte(nil), b.data...)
+}
@@ -0,0 +1,79 @@
+package message
+
+import (
+	"testing"
+	"time"
+)
+
+func testMessage() Message {
+	return Message{ID: 7, Seq: 3, From: "alice", Time: time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC), Body: []byte("hello <world>\n")}
+}
+
+func TestShared(t *testing.T) {
+	m := testMessage()
+	for _, structured := range []bool{false, true} {
+		buffer := Shared("lobby", m, structured)
+		if want := string(Encode("lobby", m, structured)); buffer.String() != want {
+			t.Errorf("Expected %q, got %q", want, buffer.String())
+		}
+		buffer.Release()
+	}
+}
+
+func TestBuffer_Release(t *testing.T) {
+	buffer := Shared("lobby", testMessage(), true)
+	buffer.Retain()
+
+	kept := buffer.Copy()
+	buffer.Release()
+	if buffer.Len() == 0 {
+		t.Fatal("Buffer was emptied while still held")
+	}
+
+	buffer.Release()
+	if buffer.Len() != 0 {
+		t.Error("Expected the last release to return the buffer to the pool")
+	}
+	if string(kept) != string(Encode("lobby", testMessage(), true)) {
+		t.Errorf("Expected the copy to outlive the buffer, got %q", kept)
+	}
+}
+
+// BenchmarkEncode compares encoding a broadcast for each of 100 structured
+// recipients with encoding it once into a shared buffer.
+func BenchmarkEncode(b *testing.B) {
+	m := testMessage()
+	b.Run("per-session", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for j := 0; j < 100; j++ {
+				Encode("lobby", m, true)
+			}
+		}
+	})
+	b.Run("shared", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			buffer := Shared("lobby", m, true)
+			for j := 0; j < 100; j++ {
+				buffer.Retain().Release()
+			}
+			buffer.Release()
+		}
+	})
+}
+
+func TestNextID_Origin(t *testing.T) {
+	defer origin.Store(0)
+
+	SetOrigin("node-a")
+	a := NextID()
+	SetOrigin("node-b")
+	b := NextID()
+	if a>>idBits == b>>idBits || a>>idBits == 0 {
+		t.Errorf("Expected distinct origins, got IDs %d and %d", a, b)
+	}
+	if a >= 1<<53 || b >= 1<<53 {
+		t.Errorf("Expected IDs below 2^53, got %d and %d", a, b)
+	}
+}
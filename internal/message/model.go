@@ -1,6 +1,73 @@
 package message
 
+import (
+	"sync/atomic"
+	"time"
+)
+
 type Message struct {
+	// ID is unique across the servers sharing a room and assigned by the
+	// room that took the message before publishing it, so every copy of the
+	// room knows it by the same ID. Seq is its position in the room, also
+	// given on publishing; posts on two nodes at once may share one.
+	ID        uint64
+	Seq       uint64
+	Time      time.Time
 	SessionID string
-	Body      []byte
+	From      string
+	// Account is the verified account that sent the message, empty for
+	// guests and servers without authentication. Unlike From it cannot be
+	// taken by someone else later.
+	Account string
+	Body    []byte
+	// Filtered is why the filter held the message back. The room tells the
+	// sender instead of broadcasting it.
+	Filtered string
+	// Action is empty for a post. An edit or delete changes the earlier
+	// message Target instead, on behalf of By, and a topic change sets the
+	// topic to Body. They travel like a post so every copy of the room
+	// applies them.
+	Action string
+	Target uint64
+	By     string
+}
+
+const (
+	Edit   = "edit"
+	Delete = "delete"
+	Topic  = "topic"
+)
+
+// Event is one JSON line sent to clients on the structured protocol.
+type Event struct {
+	Type     string     `json:"type"`
+	ID       uint64     `json:"id,omitempty"`
+	Seq      uint64     `json:"seq,omitempty"`
+	Room     string     `json:"room,omitempty"`
+	From     string     `json:"from,omitempty"`
+	By       string     `json:"by,omitempty"`
+	Time     *time.Time `json:"time,omitempty"`
+	Body     string     `json:"body,omitempty"`
+	Text     string     `json:"text,omitempty"`
+	Acked    []string   `json:"acked,omitempty"`
+	Pending  []string   `json:"pending,omitempty"`
+	Complete bool       `json:"complete,omitempty"`
+	File     string     `json:"file,omitempty"`
+	Size     int64      `json:"size,omitempty"`
+	Offset   int64      `json:"offset,omitempty"`
+	Checksum string     `json:"checksum,omitempty"`
+	Data     string     `json:"data,omitempty"`
+	Percent  int        `json:"percent,omitempty"`
+	Mode     string     `json:"mode,omitempty"`
+	Members  int        `json:"members,omitempty"`
+	Limit    int        `json:"limit,omitempty"`
+}
+
+// Buffer is an encoded message that may be shared by every session it is
+// sent to. Its bytes must not be changed. Every holder releases it once
+// written, and a pooled buffer is reused after the last release.
+type Buffer struct {
+	data   []byte
+	refs   atomic.Int32
+	pooled bool
 }
@@ -0,0 +1,74 @@
+package message
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"strings"
+	"sync/atomic"
+)
+
+// idBits is how many low bits of an ID count messages. The bits above hold
+// the origin, keeping IDs from processes that share rooms apart and below
+// 2^53, so JavaScript clients read them exactly.
+const idBits = 40
+
+var (
+	lastID atomic.Uint64
+	origin atomic.Uint64
+)
+
+// SetOrigin numbers messages from now on as the process called name, for
+// servers that share rooms with others through a cluster or a broker. Names
+// are hashed into 8191 origins, so two names may rarely share one.
+func SetOrigin(name string) {
+	hash := fnv.New32a()
+	hash.Write([]byte(name))
+	origin.Store(uint64(hash.Sum32()%(1<<13-1)) + 1)
+}
+
+// NextID returns the next server-wide message ID.
+func NextID() uint64 {
+	return origin.Load()<<idBits | lastID.Add(1)
+}
+
+// Encode renders a room message for a client. Text clients get the body
+// exactly as it was sent.
+func Encode(room string, m Message, structured bool) []byte {
+	if !structured {
+		return m.Body
+	}
+	at := m.Time
+	return EncodeEvent(Event{
+		Type: "message",
+		ID:   m.ID,
+		Seq:  m.Seq,
+		Room: room,
+		From: m.From,
+		Time: &at,
+		Body: strings.TrimRight(string(m.Body), "\r\n"),
+	})
+}
+
+// EncodeNotice renders a line of server text, such as a command reply.
+func EncodeNotice(text string, structured bool) []byte {
+	if !structured {
+		return []byte(text + "\n")
+	}
+	return EncodeEvent(Event{Type: "notice", Text: text})
+}
+
+// EncodeEmit renders a typed event, or fallback text for text clients.
+func EncodeEmit(event Event, fallback string, structured bool) []byte {
+	if !structured {
+		return []byte(fallback + "\n")
+	}
+	return EncodeEvent(event)
+}
+
+func EncodeEvent(event Event) []byte {
+	data, err := json.Marshal(event)
+	if err != nil {
+		data, _ = json.Marshal(Event{Type: "error", Text: err.Error()})
+	}
+	return append(data, '\n')
+}
@@ -0,0 +1,14 @@
+package metrics
+
+import "expvar"
+
+// Counters are published through expvar and served on /debug/vars when
+// METRICS_ADDR is set.
+var (
+	ConnectionsAccepted = expvar.NewInt("connections_accepted")
+	ConnectionsDenied   = expvar.NewMap("connections_denied")
+	ConnectionsLimited  = expvar.NewMap("connections_limited")
+	MessagesFiltered    = expvar.NewMap("messages_filtered")
+	SessionsCompressed  = expvar.NewInt("sessions_compressed")
+	BrokerDropped       = expvar.NewMap("broker_dropped")
+)
@@ -0,0 +1,62 @@
+package role
+
+type Role string
+
+const (
+	Owner     Role = "owner"
+	Moderator Role = "moderator"
+	Member    Role = "member"
+	Guest     Role = "guest"
+)
+
+type Permission int
+
+const (
+	Post Permission = iota
+	Kick
+	SetTopic
+	ExceedLimits
+	AssignRoles
+	ManageACL
+	ModerateMessages
+	ManageRoom
+	BypassRoomLimits
+	MentionRoom
+)
+
+var permissions = map[Role]map[Permission]bool{
+	Owner: {
+		Post:             true,
+		Kick:             true,
+		SetTopic:         true,
+		ExceedLimits:     true,
+		AssignRoles:      true,
+		ManageACL:        true,
+		ModerateMessages: true,
+		ManageRoom:       true,
+		BypassRoomLimits: true,
+		MentionRoom:      true,
+	},
+	Moderator: {
+		Post:             true,
+		Kick:             true,
+		SetTopic:         true,
+		ExceedLimits:     true,
+		ModerateMessages: true,
+		BypassRoomLimits: true,
+		MentionRoom:      true,
+	},
+	Member: {
+		Post: true,
+	},
+	Guest: {
+		Post: true,
+	},
+}
+
+var ranks = map[Role]int{
+	Guest:     1,
+	Member:    2,
+	Moderator: 3,
+	Owner:     4,
+}
@@ -0,0 +1,64 @@
+package role
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRole_Can(t *testing.T) {
+	tests := []struct {
+		role       Role
+		permission Permission
+		expected   bool
+	}{
+		{Owner, AssignRoles, true},
+		{Moderator, AssignRoles, false},
+		{Moderator, Kick, true},
+		{Member, Kick, false},
+		{Member, Post, true},
+		{Guest, Post, true},
+		{Guest, ExceedLimits, false},
+		{Moderator, MentionRoom, true},
+		{Member, MentionRoom, false},
+		{Role("unknown"), Post, false},
+	}
+
+	for _, test := range tests {
+		if got := test.role.Can(test.permission); got != test.expected {
+			t.Errorf("%s.Can(%d): expected %v, got %v", test.role, test.permission, test.expected, got)
+		}
+	}
+}
+
+func TestMax(t *testing.T) {
+	if Max(Member, Moderator) != Moderator {
+		t.Error("Expected moderator to outrank member")
+	}
+	if Max(Owner, Guest) != Owner {
+		t.Error("Expected owner to outrank guest")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "roles.json")
+
+	roles, err := Load(path)
+	if err != nil || len(roles) != 0 {
+		t.Fatalf("Expected no roles for missing file, got %v, %v", roles, err)
+	}
+
+	os.WriteFile(path, []byte(`{"alice": "owner", "bob": "moderator"}`), 0o600)
+	roles, err = Load(path)
+	if err != nil {
+		t.Fatalf("Failed to load roles: %v", err)
+	}
+	if roles["alice"] != Owner || roles["bob"] != Moderator {
+		t.Errorf("Unexpected roles: %v", roles)
+	}
+
+	os.WriteFile(path, []byte(`{"alice": "admin"}`), 0o600)
+	if _, err := Load(path); err == nil {
+		t.Error("Expected error for unknown role")
+	}
+}
@@ -0,0 +1,51 @@
+package role
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+)
+
+func Parse(name string) (Role, bool) {
+	r := Role(name)
+	_, ok := ranks[r]
+	return r, ok
+}
+
+func (r Role) Can(permission Permission) bool {
+	return permissions[r][permission]
+}
+
+func (r Role) Outranks(other Role) bool {
+	return ranks[r] > ranks[other]
+}
+
+// Max returns the higher of two roles.
+func Max(a, b Role) Role {
+	if b.Outranks(a) {
+		return b
+	}
+	return a
+}
+
+// Load reads identity roles from a JSON object of name to role.
+// A missing file yields no roles.
+func Load(path string) (map[string]Role, error) {
+	roles := make(map[string]Role)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return roles, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &roles); err != nil {
+		return nil, err
+	}
+	for name, r := range roles {
+		if _, ok := Parse(string(r)); !ok {
+			return nil, errors.New("unknown role " + string(r) + " for " + name)
+		}
+	}
+	return roles, nil
+}
@@ -0,0 +1,118 @@
+package room
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"sync/atomic"
+
+	"github.com/Arun445/tcp-go/internal/message"
+	"github.com/Arun445/tcp-go/internal/session"
+)
+
+// Bot is the server side of an in-process bot: a session without a
+// connection. The rooms queue what they send it on the session, where
+// ReadFrame takes it, and WriteFrame hands a line to the room the bot is in.
+type Bot struct {
+	session *session.Session
+	limit   int
+	posts   chan message.Message
+	stop    chan struct{}
+	stopped *atomic.Bool
+}
+
+// Attach registers an in-process bot named name in the lobby and returns
+// its end of the session, which the caller closes once the bot is done. The
+// bot is trusted with its name and skips the login. Unlimited bots are
+// exempt from byte limits and filters.
+func (hub *Hub) Attach(name string, unlimited bool) *Bot {
+	session := newSession("bot-"+name, nil)
+	session.Name = name
+	session.Unlimited = unlimited
+	bot := &Bot{
+		session: session,
+		limit:   hub.lobby.config.ByteLimit,
+		posts:   make(chan message.Message),
+		stop:    make(chan struct{}),
+		stopped: &atomic.Bool{},
+	}
+	session.Stop = bot.Close
+
+	if !hub.lobby.join(session) {
+		bot.Close()
+		return bot
+	}
+	go hub.serveBot(bot)
+	return bot
+}
+
+// serveBot routes the bot's posts like a connected session's until it
+// stops, and then unregisters it from the room it is in.
+func (hub *Hub) serveBot(bot *Bot) {
+	inbox := make(chan message.Message)
+	left := make(chan *Room)
+	go hub.route(bot.session, inbox, left, nil)
+	hub.lobby.filtered(bot.session, inbox, bot.forward)
+	close(inbox)
+	close(bot.session.Done)
+
+	current := <-left
+	current.events <- Event{Session: bot.session, Type: Unregister}
+}
+
+// forward passes the bot's posts to messages until it stops or reaches
+// the upload limit.
+func (bot *Bot) forward(messages chan<- message.Message) {
+	for {
+		select {
+		case m := <-bot.posts:
+			bot.session.UploadedBytes += len(m.Body)
+			if bot.session.UploadedBytes >= bot.limit && !bot.session.Exempt.Load() {
+				log.Printf("Bot %s reached the upload limit", bot.session.Name)
+				bot.Close()
+				return
+			}
+			messages <- m
+		case <-bot.stop:
+			return
+		}
+	}
+}
+
+// ReadFrame returns the next message queued for the bot, and io.EOF once
+// the session is closed, disconnected or over the download limit.
+func (bot *Bot) ReadFrame() ([]byte, error) {
+	select {
+	case buffer, ok := <-bot.session.Messages:
+		if !ok || buffer == nil {
+			return nil, io.EOF
+		}
+		frame := bytes.Clone(buffer.Bytes())
+		buffer.Release()
+		bot.session.DownloadedBytes += len(frame)
+		if bot.session.DownloadedBytes >= bot.limit {
+			log.Printf("Bot %s reached the download limit", bot.session.Name)
+			return nil, io.EOF
+		}
+		return frame, nil
+	case <-bot.stop:
+		return nil, io.EOF
+	}
+}
+
+// WriteFrame posts one line as the bot, waiting for the room to take it.
+func (bot *Bot) WriteFrame(frame []byte) error {
+	select {
+	case bot.posts <- message.Message{SessionID: bot.session.ID, Body: bytes.Clone(frame)}:
+		return nil
+	case <-bot.stop:
+		return io.ErrClosedPipe
+	}
+}
+
+// Close ends the bot's session. It may be called more than once.
+func (bot *Bot) Close() {
+	if bot.stopped.CompareAndSwap(false, true) {
+		close(bot.stop)
+	}
+}
@@ -0,0 +1,361 @@
+package room
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Arun445/tcp-go/internal/acl"
+	"github.com/Arun445/tcp-go/internal/audit"
+	"github.com/Arun445/tcp-go/internal/message"
+	"github.com/Arun445/tcp-go/internal/role"
+	"github.com/Arun445/tcp-go/internal/session"
+)
+
+var validName = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
+func isCommand(body []byte) bool {
+	return bytes.HasPrefix(body, []byte("/"))
+}
+
+func (room *Room) handleCommand(sender *session.Session, body []byte) {
+	line := strings.TrimRight(string(body), "\r\n")
+	fields := strings.SplitN(line, " ", 3)
+
+	switch fields[0] {
+	case "/nick":
+		if len(fields) != 2 {
+			sender.Notice("Usage: /nick <name>")
+			return
+		}
+		room.nick(sender, fields[1])
+	case "/msg":
+		if len(fields) != 3 {
+			sender.Notice("Usage: /msg <name> <text>")
+			return
+		}
+		room.direct(sender, fields[1], fields[2])
+	case "/kick":
+		if len(fields) < 2 {
+			sender.Notice("Usage: /kick <name> [reason]")
+			return
+		}
+		reason := ""
+		if len(fields) == 3 {
+			reason = fields[2]
+		}
+		room.kick(sender, fields[1], reason)
+	case "/role":
+		if len(fields) != 3 {
+			sender.Notice("Usage: /role <name> <moderator|member>")
+			return
+		}
+		room.assignRole(sender, fields[1], fields[2])
+	case "/topic":
+		text := strings.TrimSpace(strings.TrimPrefix(line, "/topic"))
+		if text == "" {
+			room.showTopic(sender)
+			return
+		}
+		room.setTopic(sender, text)
+	case "/invite":
+		if len(fields) != 2 {
+			sender.Notice("Usage: /invite <name>")
+			return
+		}
+		room.invite(sender, fields[1])
+	case "/mode":
+		room.setMode(sender, strings.Fields(line)[1:])
+	case "/limit":
+		if len(fields) != 2 {
+			sender.Notice("Usage: /limit <members>, 0 removes the limit")
+			return
+		}
+		room.setLimit(sender, fields[1])
+	case "/join", "/leave", "/rooms":
+		// Valid uses are taken care of by the hub before reaching the room.
+		sender.Notice("Usage: /join <room> [password] | /leave | /rooms")
+	case "/proto":
+		room.setProtocol(sender, strings.Fields(line)[1:])
+	case "/ack":
+		room.ack(sender, strings.Fields(line)[1:])
+	case "/delivery":
+		room.deliveryReport(sender, strings.Fields(line)[1:])
+	case "/resend":
+		room.resend(sender, strings.Fields(line)[1:])
+	case "/history":
+		room.showHistory(sender, strings.Fields(line)[1:])
+	case "/edit":
+		if len(fields) != 3 {
+			sender.Notice("Usage: /edit <id> <text>")
+			return
+		}
+		room.edit(sender, fields[1], fields[2])
+	case "/delete":
+		if len(fields) != 2 {
+			sender.Notice("Usage: /delete <id>")
+			return
+		}
+		room.delete(sender, fields[1])
+	case "/offer":
+		room.offer(sender, strings.Fields(line)[1:])
+	case "/accept":
+		room.accept(sender, strings.Fields(line)[1:])
+	case "/chunk":
+		room.chunk(sender, strings.Fields(line)[1:])
+	case "/mentions":
+		room.listMentions(sender, strings.Fields(line)[1:])
+	case "/transfers":
+		room.listTransfers(sender)
+	case "/acl":
+		room.manageACL(sender, strings.Fields(line)[1:])
+	default:
+		sender.Notice(fmt.Sprintf("Unknown command: %s", fields[0]))
+	}
+}
+
+func (room *Room) nick(sender *session.Session, name string) {
+	if room.auth != nil {
+		sender.Notice("Your name is set at login")
+		return
+	}
+	if !validName.MatchString(name) {
+		sender.Notice("Invalid name")
+		return
+	}
+	if _, ok := room.claim(sender, name, false); !ok {
+		sender.Notice("Name already in use")
+		return
+	}
+
+	if sender.Name != "" && sender.Name != name {
+		room.releaseName(sender, sender.Name)
+		if room.names[sender.Name] == sender {
+			delete(room.names, sender.Name)
+		}
+	}
+	sender.Name = name
+	sender.Notice(fmt.Sprintf("You are now %s", name))
+	room.identify(sender)
+}
+
+// direct sends a private message to the named session, in whichever room it
+// is, or queues it when the name is reconnecting or offline.
+func (room *Room) direct(sender *session.Session, to string, text string) {
+	from := sender.DisplayName()
+	target, at := room.locate(to)
+	switch {
+	case at == room:
+		room.directed(Event{Session: target, From: from, To: to, Text: text})
+		if target == nil {
+			sender.Notice(fmt.Sprintf("%s is reconnecting, message queued", to))
+		}
+	case at != nil:
+		// The other room writes to its own members and keeps its dropped
+		// sessions.
+		at.forward(Event{Session: target, Type: Direct, From: from, To: to, Text: text})
+		if target == nil {
+			sender.Notice(fmt.Sprintf("%s is reconnecting, message queued", to))
+		}
+	case room.enqueueOffline(0, from, to, text):
+		sender.Notice(fmt.Sprintf("%s is offline, message queued", to))
+	default:
+		sender.Notice(fmt.Sprintf("No such user: %s", to))
+	}
+}
+
+// directed delivers a private message to a member, or queues it for a
+// session that dropped from this room. A target that moved on in the
+// meantime is looked up again.
+func (room *Room) directed(event Event) {
+	if event.Session != nil {
+		if _, ok := room.sessions[event.Session.ID]; ok {
+			room.emitDirect(event.Session, event.From, event.Text)
+			return
+		}
+	}
+	if token, ok := room.detachedNames[event.To]; ok {
+		record := room.detached[token]
+		record.queue(0, message.EncodeEmit(message.Event{Type: "direct", From: event.From, Body: event.Text},
+			fmt.Sprintf("%s (private): %s", event.From, event.Text), record.Structured), room.config.ResumeQueue)
+		return
+	}
+	target, at := room.locate(event.To)
+	switch {
+	case at == nil:
+		room.enqueueOffline(0, event.From, event.To, event.Text)
+	case at != room:
+		at.forward(Event{Session: target, Type: Direct, From: event.From, To: event.To, Text: event.Text})
+	}
+}
+
+// forward hands an event from another room loop to this room without
+// waiting for it, and drops it if the room closes first.
+func (room *Room) forward(event Event) {
+	go func() {
+		select {
+		case room.events <- event:
+		case <-room.done:
+		}
+	}()
+}
+
+func (room *Room) emitDirect(target *session.Session, from string, text string) {
+	target.Emit(message.Event{Type: "direct", From: from, Body: text},
+		fmt.Sprintf("%s (private): %s", from, text))
+}
+
+func (room *Room) kick(sender *session.Session, name string, reason string) {
+	if !sender.Role.Can(role.Kick) {
+		sender.Notice("You are not allowed to kick")
+		return
+	}
+	target, ok := room.names[name]
+	if !ok {
+		sender.Notice(fmt.Sprintf("No such user: %s", name))
+		return
+	}
+	if !sender.Role.Outranks(target.Role) {
+		sender.Notice(fmt.Sprintf("You cannot kick %s", name))
+		return
+	}
+
+	notice := fmt.Sprintf("You were kicked by %s", sender.DisplayName())
+	if reason != "" {
+		notice += ": " + reason
+	}
+	log.Printf("Session %s kicked by %s", target.ID, sender.DisplayName())
+	room.auditLog.Record(audit.Record{Action: audit.Kick, Actor: sender.DisplayName(), Target: name, Room: room.config.Name, Session: target.ID, Detail: reason})
+	target.Token = ""
+	target.Disconnect(notice)
+	sender.Notice(fmt.Sprintf("Kicked %s", name))
+}
+
+// assignRole sets a room-level role. Owners are only assigned through the
+// roles file, so room owners can promote moderators but not other owners.
+func (room *Room) assignRole(sender *session.Session, name string, roleName string) {
+	if !sender.Role.Can(role.AssignRoles) {
+		sender.Notice("You are not allowed to assign roles")
+		return
+	}
+	r, ok := role.Parse(roleName)
+	if !ok || (r != role.Moderator && r != role.Member) {
+		sender.Notice("Role must be moderator or member")
+		return
+	}
+	if room.auth == nil || !room.auth.Exists(name) {
+		sender.Notice(fmt.Sprintf("No such account: %s", name))
+		return
+	}
+
+	if r == role.Member {
+		delete(room.state.Roles, name)
+	} else {
+		room.state.Roles[name] = r
+	}
+	room.saveState()
+	room.auditLog.Record(audit.Record{Action: audit.RoleChange, Actor: sender.DisplayName(), Target: name, Room: room.config.Name, Detail: string(r)})
+
+	if target, ok := room.names[name]; ok {
+		room.resolveRole(target)
+		target.Notice(fmt.Sprintf("Your role is now %s", target.Role))
+	}
+	sender.Notice(fmt.Sprintf("%s is now %s", name, r))
+}
+
+func (room *Room) manageACL(sender *session.Session, args []string) {
+	if !sender.Role.Can(role.ManageACL) {
+		sender.Notice("You are not allowed to manage access lists")
+		return
+	}
+	if room.acl == nil {
+		sender.Notice("Access lists are not enabled")
+		return
+	}
+	usage := "Usage: /acl list | /acl <allow|deny> <cidr> [duration] | /acl remove <cidr>"
+	if len(args) == 0 {
+		sender.Notice(usage)
+		return
+	}
+
+	switch args[0] {
+	case "list":
+		var out strings.Builder
+		for _, rule := range room.acl.Rules() {
+			fmt.Fprintf(&out, "%s %s", rule.Action, rule.CIDR)
+			if rule.Expires != nil {
+				fmt.Fprintf(&out, " until %s", rule.Expires.Format(time.RFC3339))
+			}
+			out.WriteString("\n")
+		}
+		if out.Len() == 0 {
+			out.WriteString("No access rules\n")
+		}
+		sender.Notice(strings.TrimSuffix(out.String(), "\n"))
+
+	case "allow", "deny":
+		if len(args) < 2 || len(args) > 3 {
+			sender.Notice(usage)
+			return
+		}
+		var expires *time.Time
+		if len(args) == 3 {
+			duration, err := time.ParseDuration(args[2])
+			if err != nil || duration <= 0 {
+				sender.Notice("Invalid duration")
+				return
+			}
+			at := room.clock.Now().Add(duration)
+			expires = &at
+		}
+		rule, err := acl.NewRule(acl.Action(args[0]), args[1], expires)
+		if err != nil {
+			sender.Notice(fmt.Sprintf("Invalid rule: %v", err))
+			return
+		}
+		if err := room.acl.Add(rule); err != nil {
+			log.Printf("Failed to save access list: %v", err)
+			sender.Notice("Failed to save the access list, rule not added")
+			return
+		}
+		log.Printf("ACL %s %s added by %s", rule.Action, rule.CIDR, sender.DisplayName())
+		record := audit.Record{Action: audit.ACLAllow, Actor: sender.DisplayName(), Target: rule.CIDR, Room: room.config.Name}
+		if rule.Action == acl.Deny {
+			record.Action = audit.Ban
+		}
+		if expires != nil {
+			record.Detail = "until " + expires.UTC().Format(time.RFC3339)
+		}
+		room.auditLog.Record(record)
+		sender.Notice(fmt.Sprintf("Added %s %s", rule.Action, rule.CIDR))
+
+	case "remove":
+		if len(args) != 2 {
+			sender.Notice(usage)
+			return
+		}
+		if _, err := acl.NewRule(acl.Deny, args[1], nil); err != nil {
+			sender.Notice(fmt.Sprintf("Invalid rule: %v", err))
+			return
+		}
+		found, err := room.acl.Remove(args[1])
+		if err != nil {
+			log.Printf("Failed to save access list: %v", err)
+			sender.Notice("Failed to save the access list, rule not removed")
+			return
+		}
+		if !found {
+			sender.Notice(fmt.Sprintf("No rule for %s", args[1]))
+			return
+		}
+		log.Printf("ACL %s removed by %s", args[1], sender.DisplayName())
+		room.auditLog.Record(audit.Record{Action: audit.ACLRemove, Actor: sender.DisplayName(), Target: args[1], Room: room.config.Name})
+		sender.Notice(fmt.Sprintf("Removed %s", args[1]))
+
+	default:
+		sender.Notice(usage)
+	}
+}
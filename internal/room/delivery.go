@@ -0,0 +1,149 @@
+package room
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Arun445/tcp-go/internal/message"
+	"github.com/Arun445/tcp-go/internal/session"
+)
+
+// maxReplay bounds how many messages one /resend or structured /history
+// replays. It is well below sessionQueue, so a replay alone never fills the
+// queue of a session that reads.
+const maxReplay = 100
+
+// remember adds a broadcast to the history and starts tracking its delivery.
+func (room *Room) remember(m message.Message) *tracker {
+	tracked := &tracker{
+		Sender:    m.SessionID,
+		Delivered: make(map[string]bool),
+		Acked:     make(map[string]bool),
+	}
+	if room.config.HistorySize <= 0 {
+		return tracked
+	}
+
+	room.history = append(room.history, m)
+	room.trackers[m.ID] = tracked
+	if len(room.history) > room.config.HistorySize {
+		delete(room.trackers, room.history[0].ID)
+		room.history[0] = message.Message{}
+		room.history = room.history[1:]
+	}
+	return tracked
+}
+
+func (room *Room) setProtocol(sender *session.Session, args []string) {
+	if len(args) != 1 || (args[0] != "text" && args[0] != "json") {
+		sender.Notice("Usage: /proto <text|json>")
+		return
+	}
+	sender.Structured = args[0] == "json"
+	if _, ok := room.sessions[sender.ID]; ok && room.shards != nil {
+		room.shardFor(sender) <- shardOp{Type: shardProtocol, Session: sender, Structured: sender.Structured}
+	}
+	sender.Notice(fmt.Sprintf("Protocol set to %s", args[0]))
+}
+
+// ack marks messages as received by the sender. Acks are silent.
+func (room *Room) ack(sender *session.Session, args []string) {
+	for _, arg := range args {
+		id, err := strconv.ParseUint(arg, 10, 64)
+		if err != nil {
+			sender.Notice(fmt.Sprintf("Invalid message id: %s", arg))
+			continue
+		}
+		tracked, ok := room.trackers[id]
+		if !ok {
+			continue
+		}
+		// A shard's delivery report may still be on its way, but the ack
+		// proves the message arrived.
+		if room.shards != nil && tracked.Sender != sender.ID {
+			tracked.Delivered[sender.ID] = true
+		}
+		if tracked.Delivered[sender.ID] {
+			tracked.Acked[sender.ID] = true
+		}
+	}
+}
+
+// deliveryReport tells whether a message was acknowledged by every session
+// currently in the room.
+func (room *Room) deliveryReport(sender *session.Session, args []string) {
+	if len(args) != 1 {
+		sender.Notice("Usage: /delivery <id>")
+		return
+	}
+	id, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		sender.Notice(fmt.Sprintf("Invalid message id: %s", args[0]))
+		return
+	}
+	tracked, ok := room.trackers[id]
+	if !ok {
+		sender.Notice(fmt.Sprintf("Message %d is not in the history", id))
+		return
+	}
+
+	var acked, pending []string
+	for _, member := range room.sessions {
+		if member.ID == tracked.Sender {
+			continue
+		}
+		if tracked.Acked[member.ID] {
+			acked = append(acked, member.DisplayName())
+		} else {
+			pending = append(pending, member.DisplayName())
+		}
+	}
+	sort.Strings(acked)
+	sort.Strings(pending)
+
+	fallback := fmt.Sprintf("Message %d: acked by %d of %d", id, len(acked), len(acked)+len(pending))
+	if len(pending) > 0 {
+		fallback += ", pending: " + strings.Join(pending, ", ")
+	}
+	sender.Emit(message.Event{Type: "delivery", ID: id, Acked: acked, Pending: pending, Complete: len(pending) == 0}, fallback)
+}
+
+// resend retransmits broadcasts from the history by sequence number, up to
+// maxReplay of them.
+func (room *Room) resend(sender *session.Session, args []string) {
+	if len(args) < 1 || len(args) > 2 {
+		sender.Notice("Usage: /resend <from-seq> [to-seq]")
+		return
+	}
+	from, err := strconv.ParseUint(args[0], 10, 64)
+	to := room.seq
+	if err == nil && len(args) == 2 {
+		to, err = strconv.ParseUint(args[1], 10, 64)
+	}
+	if err != nil || from > to {
+		sender.Notice("Invalid sequence range")
+		return
+	}
+
+	if len(room.history) == 0 || from < room.history[0].Seq {
+		first := room.seq + 1
+		if len(room.history) > 0 {
+			first = room.history[0].Seq
+		}
+		sender.Notice(fmt.Sprintf("History starts at seq %d", first))
+	}
+	sent := 0
+	for _, m := range room.history {
+		if m.Seq < from || m.Seq > to {
+			continue
+		}
+		if sent == maxReplay {
+			sender.Notice(fmt.Sprintf("Resend stopped before seq %d, send /resend %d %d for more", m.Seq, m.Seq, to))
+			return
+		}
+		sender.Deliver(room.config.Name, m)
+		sent++
+	}
+}
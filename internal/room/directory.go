@@ -0,0 +1,163 @@
+package room
+
+import (
+	"fmt"
+
+	"github.com/Arun445/tcp-go/internal/session"
+)
+
+func newDirectory() *directory {
+	return &directory{
+		requests: make(chan nameRequest),
+		stop:     make(chan struct{}),
+		names:    make(map[string]*listing),
+		tokens:   make(map[string]string),
+	}
+}
+
+// Open serves the directory. It never waits on a room, so rooms may call it
+// from their loops.
+func (d *directory) Open() {
+	for {
+		select {
+		case req := <-d.requests:
+			switch req.Type {
+			case claimName:
+				req.Reply <- d.claim(req)
+
+			case releaseName:
+				if entry, ok := d.names[req.Name]; ok && entry.Session == req.Session {
+					delete(d.names, req.Name)
+				}
+				req.Reply <- nameResponse{}
+
+			case enterRoom:
+				if entry, ok := d.names[req.Name]; ok && entry.Session == req.Session {
+					entry.Room = req.Room
+				}
+				req.Reply <- nameResponse{}
+
+			case locateName:
+				resp := nameResponse{}
+				if entry, ok := d.names[req.Name]; ok {
+					resp.Session, resp.Room = entry.Session, entry.Room
+				}
+				req.Reply <- resp
+
+			case detachName:
+				if entry, ok := d.names[req.Name]; ok && entry.Session == req.Session {
+					entry.Session, entry.Room, entry.Token = nil, req.Room, req.Token
+					d.tokens[req.Token] = req.Name
+				}
+				req.Reply <- nameResponse{}
+
+			case locateToken:
+				resp := nameResponse{}
+				if name, ok := d.tokens[req.Token]; ok {
+					resp.Name, resp.Room, resp.OK = name, d.names[name].Room, true
+				}
+				req.Reply <- resp
+
+			case resumeToken:
+				resp := nameResponse{}
+				if name, ok := d.tokens[req.Token]; ok {
+					delete(d.tokens, req.Token)
+					entry := d.names[name]
+					entry.Session, entry.Token = req.Session, ""
+					resp.Name, resp.OK = name, true
+				}
+				req.Reply <- resp
+
+			case forgetToken:
+				if name, ok := d.tokens[req.Token]; ok {
+					delete(d.tokens, req.Token)
+					delete(d.names, name)
+				}
+				req.Reply <- nameResponse{}
+			}
+
+		case <-d.stop:
+			return
+		}
+	}
+}
+
+// Close stops Open once every room sharing the directory has closed.
+func (d *directory) Close() {
+	close(d.stop)
+}
+
+// claim binds a free name to the session. A guest asking for a taken name
+// gets the first free one with a numeric suffix instead. A takeover frees
+// the name of a dropped session, which can then no longer resume.
+func (d *directory) claim(req nameRequest) nameResponse {
+	name := req.Name
+	if entry, ok := d.names[name]; ok && entry.Session != req.Session {
+		switch {
+		case entry.Session == nil && req.Takeover:
+			delete(d.tokens, entry.Token)
+		case req.Guest:
+			for i := 2; d.names[name] != nil; i++ {
+				name = fmt.Sprintf("%s-%d", req.Name, i)
+			}
+		default:
+			return nameResponse{}
+		}
+	}
+	d.names[name] = &listing{Session: req.Session, Room: req.Room}
+	return nameResponse{Name: name, OK: true}
+}
+
+// claim binds name to the session in room across the hub, reporting the name
+// it got. A guest asking for a taken name gets a free variant of it; anyone
+// else is refused.
+func (room *Room) claim(s *session.Session, name string, guest bool) (string, bool) {
+	resp := room.directory.do(nameRequest{Type: claimName, Session: s, Name: name, Room: room, Guest: guest})
+	return resp.Name, resp.OK
+}
+
+// takeover binds the name of a verified account to the session, also when
+// a dropped session of the account holds it.
+func (room *Room) takeover(s *session.Session, name string) bool {
+	return room.directory.do(nameRequest{Type: claimName, Session: s, Name: name, Room: room, Takeover: true}).OK
+}
+
+// releaseName frees a name held by the session.
+func (room *Room) releaseName(s *session.Session, name string) {
+	room.directory.do(nameRequest{Type: releaseName, Session: s, Name: name})
+}
+
+// locate finds the session holding name and the room it is in. For a
+// dropped session that may resume, the session is nil and the room is the
+// one it dropped from.
+func (room *Room) locate(name string) (*session.Session, *Room) {
+	resp := room.directory.do(nameRequest{Type: locateName, Name: name})
+	return resp.Session, resp.Room
+}
+
+// holdName keeps the name of a dropped session for its token.
+func (room *Room) holdName(s *session.Session, name string, token string) {
+	room.directory.do(nameRequest{Type: detachName, Session: s, Name: name, Room: room, Token: token})
+}
+
+// tokenRoom finds the room a resume token belongs to.
+func (room *Room) tokenRoom(token string) *Room {
+	return room.directory.do(nameRequest{Type: locateToken, Token: token}).Room
+}
+
+// redeem binds the name held for token to the session, reporting whether
+// the token was still good.
+func (room *Room) redeem(s *session.Session, token string) bool {
+	return room.directory.do(nameRequest{Type: resumeToken, Session: s, Token: token}).OK
+}
+
+// forgetToken frees the name held for a token that expired.
+func (room *Room) forgetToken(token string) {
+	room.directory.do(nameRequest{Type: forgetToken, Token: token})
+}
+
+func (d *directory) do(req nameRequest) nameResponse {
+	req.Reply = make(chan nameResponse, 1)
+	d.requests <- req
+	return <-req.Reply
+}
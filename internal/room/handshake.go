@@ -0,0 +1,268 @@
+package room
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/Arun445/tcp-go/internal/audit"
+	"github.com/Arun445/tcp-go/internal/auth"
+	"github.com/Arun445/tcp-go/internal/config"
+	"github.com/Arun445/tcp-go/internal/metrics"
+	"github.com/Arun445/tcp-go/internal/session"
+	"github.com/Arun445/tcp-go/internal/transport"
+)
+
+const (
+	handshakeTimeout  = 30 * time.Second
+	handshakeAttempts = 3
+	maxHandshakeLine  = 256
+)
+
+// handshake authenticates the connection before it is registered, and
+// returns the room a resumed session dropped from. Input after the last
+// line it reads is left for HandleRead.
+func (room *Room) handshake(session *session.Session) (*Room, bool) {
+	authConfig := room.config.Auth
+	greeting := "Login with: LOGIN <name> <password> | RESUME <token>"
+	if authConfig.AllowRegister {
+		greeting += " | REGISTER <name> <password>"
+	}
+	if authConfig.Mode == config.AuthGuest {
+		greeting += " | GUEST <name>"
+	}
+	if _, ok := session.Transport.(transport.Compressor); ok {
+		greeting += " | COMPRESS deflate"
+	}
+	session.Transport.WriteFrame([]byte(greeting + "\n"))
+
+	defer session.Transport.SetReadDeadline(time.Time{})
+	for attempt := 0; attempt < handshakeAttempts; attempt++ {
+		session.Transport.SetReadDeadline(time.Now().Add(handshakeTimeout))
+		line, err := readLine(session)
+		if err != nil {
+			log.Printf("Session %s handshake error: %v", session.ID, err)
+			return nil, false
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) > 0 && strings.ToUpper(fields[0]) == "COMPRESS" {
+			// Negotiating compression is not a login attempt, and it
+			// only succeeds once.
+			if room.compress(session, fields) {
+				attempt--
+			}
+			continue
+		}
+
+		reply, resumed, ok := room.authenticate(session, fields)
+		if ok {
+			room.auditLog.Record(audit.Record{
+				Action:  audit.Login,
+				Actor:   session.Name,
+				Session: session.ID,
+				Addr:    session.Transport.RemoteAddr().String(),
+				Detail:  strings.ToLower(fields[0]),
+			})
+			session.Transport.WriteFrame([]byte(fmt.Sprintf("Welcome %s\n", session.Name)))
+			return resumed, true
+		}
+		session.Transport.WriteFrame([]byte(reply + "\n"))
+	}
+
+	session.Transport.WriteFrame([]byte("Too many attempts. Disconnecting...\n"))
+	return nil, false
+}
+
+// compress switches the connection to DEFLATE when its listener offers it.
+// The confirmation is the last line sent uncompressed.
+func (room *Room) compress(session *session.Session, fields []string) bool {
+	if _, ok := session.Transport.(transport.Compressor); !ok {
+		session.Transport.WriteFrame([]byte("Compression is not available\n"))
+		return false
+	}
+	if len(fields) != 2 || strings.ToLower(fields[1]) != "deflate" {
+		session.Transport.WriteFrame([]byte("Usage: COMPRESS deflate\n"))
+		return false
+	}
+	if session.Compressed {
+		session.Transport.WriteFrame([]byte("Compression already enabled\n"))
+		return false
+	}
+
+	if err := session.Compress([]byte("Compression enabled\n")); err != nil {
+		session.Transport.WriteFrame([]byte(err.Error() + "\n"))
+		return false
+	}
+	metrics.SessionsCompressed.Add(1)
+	log.Printf("Session %s enabled compression", session.ID)
+	return true
+}
+
+// authenticate runs one login command, returning the reply on failure and
+// the room a resumed session dropped from.
+func (room *Room) authenticate(session *session.Session, fields []string) (string, *Room, bool) {
+	if len(fields) == 0 {
+		return "Empty command", nil, false
+	}
+
+	switch strings.ToUpper(fields[0]) {
+	case "LOGIN":
+		if len(fields) != 3 {
+			return "Usage: LOGIN <name> <password>", nil, false
+		}
+		if err := room.auth.Login(source(session), fields[1], fields[2]); err != nil {
+			log.Printf("Session %s failed login as %s: %v", session.ID, fields[1], err)
+			room.auditFailure(session, fields[1], err.Error())
+			if errors.Is(err, auth.ErrLocked) {
+				return "Too many failed logins, try again later", nil, false
+			}
+			return "Invalid name or password", nil, false
+		}
+		if !room.takeover(session, fields[1]) {
+			room.auditFailure(session, fields[1], "already logged in")
+			return "Already logged in", nil, false
+		}
+		session.Name = fields[1]
+		return "", nil, true
+
+	case "REGISTER":
+		if !room.config.Auth.AllowRegister {
+			return "Registration is disabled", nil, false
+		}
+		if len(fields) != 3 {
+			return "Usage: REGISTER <name> <password>", nil, false
+		}
+		if !validName.MatchString(fields[1]) {
+			return "Invalid name", nil, false
+		}
+		// A guest may be using the name right now.
+		if _, ok := room.claim(session, fields[1], false); !ok {
+			return "Name in use", nil, false
+		}
+		if err := room.auth.Register(fields[1], fields[2]); err != nil {
+			room.releaseName(session, fields[1])
+			if errors.Is(err, auth.ErrUserExists) {
+				return "Name already registered", nil, false
+			}
+			log.Printf("Session %s failed to register %s: %v", session.ID, fields[1], err)
+			return "Registration failed", nil, false
+		}
+		session.Name = fields[1]
+		return "", nil, true
+
+	case "RESUME":
+		if len(fields) != 2 {
+			return "Usage: RESUME <token>", nil, false
+		}
+		session.Token = fields[1]
+		return room.resumeIn(session)
+
+	case "GUEST":
+		if room.config.Auth.Mode != config.AuthGuest {
+			return "Guests are not allowed", nil, false
+		}
+		if len(fields) != 2 {
+			return "Usage: GUEST <name>", nil, false
+		}
+		if !validName.MatchString(fields[1]) {
+			return "Invalid name", nil, false
+		}
+		if room.auth.Exists(fields[1]) {
+			room.auditFailure(session, fields[1], "guest name belongs to a registered user")
+			return "Name belongs to a registered user", nil, false
+		}
+		session.Name = fields[1]
+		session.Guest = true
+		return "", nil, true
+	}
+
+	return fmt.Sprintf("Unknown command: %s", fields[0]), nil, false
+}
+
+// resumeIn resumes session.Token in the room the session dropped from.
+func (room *Room) resumeIn(session *session.Session) (string, *Room, bool) {
+	at := room.tokenRoom(session.Token)
+	if at != nil {
+		ready := make(chan struct{})
+		select {
+		case at.events <- Event{Session: session, Type: Resume, Ready: ready}:
+			<-ready
+		case <-at.done:
+		}
+	}
+	if session.Name == "" {
+		session.Token = ""
+		room.auditFailure(session, "", "invalid or expired resume token")
+		return "Invalid or expired token", nil, false
+	}
+	return "", at, true
+}
+
+// source is the address failed logins are counted against, the client's
+// host without its port.
+func source(session *session.Session) string {
+	addr := session.Transport.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// auditFailure records a failed attempt to log in as name.
+func (room *Room) auditFailure(session *session.Session, name string, reason string) {
+	room.auditLog.Record(audit.Record{
+		Action:  audit.LoginFailed,
+		Target:  name,
+		Session: session.ID,
+		Addr:    session.Transport.RemoteAddr().String(),
+		Detail:  reason,
+	})
+}
+
+// offerCompression lets a client that logs in without a handshake switch to
+// DEFLATE with its first line. Anything else it sends is left for
+// HandleRead.
+func (room *Room) offerCompression(session *session.Session) {
+	if _, ok := session.Transport.(transport.Compressor); !ok {
+		return
+	}
+	var data []byte
+	for bytes.IndexByte(data, '\n') < 0 && len(data) < maxHandshakeLine {
+		frame, err := session.ReadFrame()
+		if err != nil {
+			break
+		}
+		data = append(data, frame...)
+	}
+
+	line, rest, _ := bytes.Cut(data, []byte("\n"))
+	fields := strings.Fields(string(line))
+	if len(fields) == 0 || strings.ToUpper(fields[0]) != "COMPRESS" {
+		session.Unread(data)
+		return
+	}
+	session.Unread(rest)
+	room.compress(session, fields)
+}
+
+func readLine(session *session.Session) (string, error) {
+	var line []byte
+	for len(line) < maxHandshakeLine {
+		frame, err := session.ReadFrame()
+		if err != nil {
+			return "", err
+		}
+		if end := bytes.IndexByte(frame, '\n'); end >= 0 {
+			session.Unread(frame[end+1:])
+			line = append(line, frame[:end]...)
+			return strings.TrimRight(string(line), "\r"), nil
+		}
+		line = append(line, frame...)
+	}
+	return "", errors.New("handshake line too long")
+}
@@ -0,0 +1,163 @@
+package room
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/Arun445/tcp-go/internal/message"
+	"github.com/Arun445/tcp-go/internal/role"
+	"github.com/Arun445/tcp-go/internal/session"
+)
+
+// find returns the position of a message in the history.
+func (room *Room) find(id uint64) (int, bool) {
+	for i, m := range room.history {
+		if m.ID == id {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// canModify reports whether sender may edit or delete m: its author or
+// anyone allowed to moderate messages. Authorship carries over to a later
+// login only for messages sent from a verified account.
+func (room *Room) canModify(sender *session.Session, m message.Message) bool {
+	if sender.Role.Can(role.ModerateMessages) || m.SessionID == sender.ID {
+		return true
+	}
+	return room.auth != nil && !sender.Guest && m.Account != "" && m.Account == sender.Name
+}
+
+func (room *Room) showHistory(sender *session.Session, args []string) {
+	count := 20
+	if len(args) == 1 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n <= 0 {
+			sender.Notice("Usage: /history [count]")
+			return
+		}
+		count = n
+	}
+	// Structured clients get one line per message.
+	if sender.Structured {
+		count = min(count, maxReplay)
+	}
+
+	start := len(room.history) - count
+	if start < 0 {
+		start = 0
+	}
+	if start == len(room.history) {
+		sender.Notice("No history")
+		return
+	}
+	if sender.Structured {
+		for _, m := range room.history[start:] {
+			sender.Deliver(room.config.Name, m)
+		}
+		return
+	}
+	var out strings.Builder
+	for _, m := range room.history[start:] {
+		fmt.Fprintf(&out, "#%d %s: %s\n", m.ID, m.From, strings.TrimRight(string(m.Body), "\r\n"))
+	}
+	sender.Send([]byte(out.String()))
+}
+
+func (room *Room) edit(sender *session.Session, idText string, text string) {
+	id, err := strconv.ParseUint(idText, 10, 64)
+	if err != nil {
+		sender.Notice(fmt.Sprintf("Invalid message id: %s", idText))
+		return
+	}
+	i, ok := room.find(id)
+	if !ok {
+		sender.Notice(fmt.Sprintf("Message %d is not in the history", id))
+		return
+	}
+	if !room.canModify(sender, room.history[i]) {
+		sender.Notice("You can only edit your own messages")
+		return
+	}
+
+	room.publish(message.Message{Action: message.Edit, Target: id, SessionID: sender.ID, From: room.history[i].From,
+		By: sender.DisplayName(), Body: []byte(text + "\n"), Time: room.clock.Now()})
+}
+
+func (room *Room) delete(sender *session.Session, idText string) {
+	id, err := strconv.ParseUint(idText, 10, 64)
+	if err != nil {
+		sender.Notice(fmt.Sprintf("Invalid message id: %s", idText))
+		return
+	}
+	i, ok := room.find(id)
+	if !ok {
+		sender.Notice(fmt.Sprintf("Message %d is not in the history", id))
+		return
+	}
+	if !room.canModify(sender, room.history[i]) {
+		sender.Notice("You can only delete your own messages")
+		return
+	}
+
+	room.publish(message.Message{Action: message.Delete, Target: id, SessionID: sender.ID, From: room.history[i].From,
+		By: sender.DisplayName(), Time: room.clock.Now()})
+}
+
+// amend applies an edit, deletion or topic change that came back from the
+// broker or a peer. The room that took it checked the permission, and a
+// copy of the room that no longer has the message still tells its members.
+func (room *Room) amend(m message.Message) {
+	i, ok := room.find(m.Target)
+	switch m.Action {
+	case message.Topic:
+		room.changeTopic(m)
+
+	case message.Edit:
+		text := strings.TrimRight(string(m.Body), "\r\n")
+		if ok {
+			room.history[i].Body = m.Body
+		}
+		for name, recent := range room.mentions {
+			for j := range recent {
+				if recent[j].ID == m.Target {
+					room.mentions[name][j].Body = text
+				}
+			}
+		}
+		if room.mailbox != nil {
+			room.mailbox.Amend(m.Target, text)
+		}
+		room.broadcastEvent(message.Event{Type: "edit", ID: m.Target, Room: room.config.Name, From: m.From, By: m.By, Body: text},
+			fmt.Sprintf("* %s edited message #%d: %s", m.By, m.Target, text))
+
+	case message.Delete:
+		if ok {
+			room.history = append(room.history[:i], room.history[i+1:]...)
+		}
+		delete(room.trackers, m.Target)
+		for name, recent := range room.mentions {
+			room.mentions[name] = slices.DeleteFunc(recent, func(entry mention) bool { return entry.ID == m.Target })
+		}
+		if room.mailbox != nil {
+			room.mailbox.Remove(m.Target)
+		}
+		room.withdraw(m.Target)
+		room.broadcastEvent(message.Event{Type: "delete", ID: m.Target, Room: room.config.Name, From: m.From, By: m.By},
+			fmt.Sprintf("* message #%d was deleted by %s", m.Target, m.By))
+	}
+}
+
+// broadcastEvent sends a room event to every member, including the actor,
+// behind the broadcasts already on their way.
+func (room *Room) broadcastEvent(event message.Event, fallback string) {
+	for _, member := range room.sessions {
+		room.sendAfterBroadcasts(member, message.EncodeEmit(event, fallback, member.Structured))
+	}
+	for _, record := range room.detached {
+		record.queue(0, message.EncodeEmit(event, fallback, record.Structured), room.config.ResumeQueue)
+	}
+}
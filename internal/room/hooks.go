@@ -0,0 +1,59 @@
+package room
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/Arun445/tcp-go/internal/message"
+	"github.com/Arun445/tcp-go/internal/session"
+)
+
+// Hooks let code embedding the server follow and change what a room does.
+// They run on the room's event loop, so they see a consistent room and must
+// not block. Any of them may be nil.
+type Hooks struct {
+	// Register and Unregister run when a session enters or leaves the room,
+	// including moves between rooms.
+	Register   func(room *Room, session *session.Session)
+	Unregister func(room *Room, session *session.Session)
+	// Message runs before a broadcast is published. It may change the
+	// message, and an error rejects it with the error text as the reply.
+	Message func(room *Room, session *session.Session, m *message.Message) error
+}
+
+// SetHooks installs hooks for this room and the rooms spawned from it. It
+// must be called before Open.
+func (room *Room) SetHooks(hooks Hooks) {
+	room.hooks = hooks
+}
+
+// Name returns the name of the room. Like Members and Post, it may only be
+// called from a hook, on the room's event loop.
+func (room *Room) Name() string {
+	return room.config.Name
+}
+
+// Members returns the sessions in the room, ordered by ID.
+func (room *Room) Members() []*session.Session {
+	members := make([]*session.Session, 0, len(room.sessions))
+	for _, s := range room.sessions {
+		members = append(members, s)
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].ID < members[j].ID })
+	return members
+}
+
+// Post broadcasts body as a message from from to everyone in the room and
+// every copy of it, ending it with a newline like a line read from a
+// client. It skips the filter and the Message hook.
+func (room *Room) Post(from string, body []byte) error {
+	if !bytes.HasSuffix(body, []byte("\n")) {
+		body = append(bytes.Clone(body), '\n')
+	}
+	return room.publish(message.Message{
+		ID:   message.NextID(),
+		Time: room.clock.Now(),
+		From: from,
+		Body: body,
+	})
+}
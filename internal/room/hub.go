@@ -0,0 +1,330 @@
+package room
+
+import (
+	"log"
+	"strings"
+
+	"github.com/Arun445/tcp-go/internal/message"
+	"github.com/Arun445/tcp-go/internal/session"
+	"github.com/Arun445/tcp-go/internal/transport"
+)
+
+// Hub owns the rooms of a server. Sessions log in through the lobby and move
+// between rooms with /join, while each room keeps its own event loop.
+type Hub struct {
+	lobby   *Room
+	rooms   map[string]*Room
+	lookups chan lookup
+	lists   chan chan []*Room
+	// closes asks for the rooms to shut down, after which no room is
+	// opened. stop ends Open.
+	closes  chan chan []*Room
+	closing bool
+	stop    chan struct{}
+}
+
+// lookup asks for a room by name. Guests may enter rooms but not create
+// them.
+type lookup struct {
+	Name  string
+	Guest bool
+	Reply chan lookupResult
+}
+
+// lookupResult is the room, or why there is none when Room is nil.
+type lookupResult struct {
+	Room    *Room
+	Created bool
+	Refused string
+}
+
+// NewHub creates a hub around lobby, which must be fully configured. Other
+// rooms are opened when someone joins them, with the settings persisted in
+// the lobby's state directory, and close once the last session leaves.
+func NewHub(lobby *Room) *Hub {
+	return &Hub{
+		lobby:   lobby,
+		rooms:   map[string]*Room{lobby.config.Name: lobby},
+		lookups: make(chan lookup),
+		lists:   make(chan chan []*Room),
+		closes:  make(chan chan []*Room),
+		stop:    make(chan struct{}),
+	}
+}
+
+// Open runs the hub, which opens rooms on demand.
+func (hub *Hub) Open() {
+	for {
+		select {
+		case req := <-hub.lookups:
+			req.Reply <- hub.lookup(req)
+
+		case reply := <-hub.lists:
+			reply <- hub.open()
+
+		case reply := <-hub.closes:
+			hub.closing = true
+			reply <- hub.open()
+
+		case <-hub.stop:
+			return
+		}
+	}
+}
+
+// Shutdown disconnects every session and bot and returns once every room
+// has closed. No room is opened from then on, and sessions that finish
+// logging in are turned away.
+func (hub *Hub) Shutdown() {
+	reply := make(chan []*Room)
+	hub.closes <- reply
+	rooms := <-reply
+	for _, room := range rooms {
+		select {
+		case room.events <- Event{Type: Shutdown}:
+		case <-room.done:
+		}
+	}
+	for _, room := range rooms {
+		<-room.done
+	}
+}
+
+// Close stops the hub and the services its rooms shared. It must be called
+// after Shutdown, once no connection is logging in any more.
+func (hub *Hub) Close() {
+	close(hub.stop)
+	hub.lobby.closeShared()
+}
+
+// open returns the rooms that are open.
+func (hub *Hub) open() []*Room {
+	hub.prune()
+	rooms := make([]*Room, 0, len(hub.rooms))
+	for _, room := range hub.rooms {
+		rooms = append(rooms, room)
+	}
+	return rooms
+}
+
+// lookup returns the named room, opening it if it is not open yet.
+func (hub *Hub) lookup(req lookup) lookupResult {
+	if hub.closing {
+		return lookupResult{Refused: "The server is shutting down"}
+	}
+	if room, ok := hub.rooms[req.Name]; ok && !isClosed(room) {
+		return lookupResult{Room: room}
+	}
+
+	hub.prune()
+	limit := hub.lobby.config.MaxRooms
+	if limit > 0 && len(hub.rooms) >= limit {
+		return lookupResult{Refused: "Too many rooms are open, try again later"}
+	}
+	persisted := hub.lobby.persisted(req.Name)
+	if !persisted && req.Guest {
+		return lookupResult{Refused: "Guests cannot create rooms"}
+	}
+	room := hub.lobby.spawn(req.Name)
+	hub.rooms[req.Name] = room
+	go room.Open()
+	if persisted {
+		log.Printf("Room opened: %s", req.Name)
+	} else {
+		log.Printf("Room created: %s", req.Name)
+	}
+	return lookupResult{Room: room, Created: !persisted}
+}
+
+// prune forgets rooms that closed.
+func (hub *Hub) prune() {
+	for name, room := range hub.rooms {
+		if isClosed(room) {
+			delete(hub.rooms, name)
+		}
+	}
+}
+
+func isClosed(room *Room) bool {
+	select {
+	case <-room.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (hub *Hub) NewSession(t transport.Transport) {
+	session, resumed := hub.lobby.connect(t)
+	if session == nil {
+		return
+	}
+	hub.run(session, resumed)
+}
+
+// run serves a registered session until it disconnects, starting in the
+// room it resumed in, if any. The room it is in last unregisters it and
+// keeps it for resuming.
+func (hub *Hub) run(session *session.Session, resumed *Room) {
+	inbox := make(chan message.Message)
+	left := make(chan *Room)
+	limit := hub.lobby.start(session)
+	go hub.route(session, inbox, left, resumed)
+	hub.lobby.read(session, inbox, limit)
+	close(inbox)
+
+	current := <-left
+	current.events <- Event{Session: session, Type: Unregister}
+}
+
+// route forwards what a session sends to the room it is in, handling the
+// commands that move it between rooms. Replies are always written by a room,
+// so the session is only ever touched from one room loop at a time.
+func (hub *Hub) route(session *session.Session, inbox <-chan message.Message, left chan<- *Room, resumed *Room) {
+	current := hub.lobby
+	if resumed != nil && resumed != hub.lobby {
+		current = hub.rejoin(session, resumed)
+	}
+	for m := range inbox {
+		fields := strings.Fields(string(m.Body))
+		if len(fields) == 0 {
+			current.messages <- m
+			continue
+		}
+
+		switch {
+		case fields[0] == "/join" && (len(fields) == 2 || len(fields) == 3) && validName.MatchString(strings.TrimPrefix(fields[1], "#")):
+			password := ""
+			if len(fields) == 3 {
+				password = fields[2]
+			}
+			current = hub.move(session, current, strings.TrimPrefix(fields[1], "#"), password)
+		case fields[0] == "/leave" && len(fields) == 1:
+			current = hub.move(session, current, hub.lobby.config.Name, "")
+		case fields[0] == "/rooms" && len(fields) == 1:
+			hub.list(session, current)
+		default:
+			current.messages <- m
+		}
+	}
+	left <- current
+}
+
+// move joins the named room, opening it if needed, and leaves the current
+// one once the new room let the session in. A room that closes before the
+// join gets there is looked up again.
+func (hub *Hub) move(session *session.Session, current *Room, name string, password string) *Room {
+	who := hub.identify(session, current)
+	for {
+		reply := make(chan lookupResult)
+		hub.lookups <- lookup{Name: name, Guest: who.Guest, Reply: reply}
+		result := <-reply
+		if result.Room == nil {
+			current.events <- Event{Session: session, Type: Notify, Text: result.Refused}
+			return current
+		}
+
+		joined := make(chan bool)
+		select {
+		case result.Room.events <- Event{Session: session, Type: Join, Password: password, Created: result.Created, Joined: joined}:
+		case <-result.Room.done:
+			continue
+		}
+		select {
+		case ok := <-joined:
+			if !ok {
+				return current
+			}
+		case <-result.Room.done:
+			continue
+		}
+
+		ready := make(chan struct{})
+		current.events <- Event{Session: session, Type: Leave, Ready: ready}
+		<-ready
+		return result.Room
+	}
+}
+
+// rejoin takes a resumed session from the lobby back to the room it dropped
+// from.
+func (hub *Hub) rejoin(session *session.Session, room *Room) *Room {
+	joined := make(chan bool, 1)
+	select {
+	case room.events <- Event{Session: session, Type: Join, Resumed: true, Joined: joined}:
+	case <-room.done:
+		return hub.lobby
+	}
+	if !<-joined {
+		return hub.lobby
+	}
+	ready := make(chan struct{})
+	hub.lobby.events <- Event{Session: session, Type: Leave, Ready: ready}
+	<-ready
+	return room
+}
+
+// identify asks the room a session is in who it is, since only that room
+// may read the session.
+func (hub *Hub) identify(session *session.Session, current *Room) identity {
+	reply := make(chan identity, 1)
+	current.events <- Event{Session: session, Type: Identify, Identity: reply}
+	return <-reply
+}
+
+func (hub *Hub) list(session *session.Session, current *Room) {
+	viewer := hub.identify(session, current)
+	reply := make(chan []*Room)
+	hub.lists <- reply
+
+	var infos []roomInfo
+	for _, room := range <-reply {
+		info := make(chan roomInfo, 1)
+		select {
+		case room.events <- Event{Session: session, Type: Describe, Viewer: viewer, Info: info}:
+			infos = append(infos, <-info)
+		case <-room.done:
+		}
+	}
+	current.events <- Event{Session: session, Type: List, Rooms: infos}
+}
+
+// closeShared stops the services NewRoom started for this room, which the
+// rooms spawned from it share, and the broker set in its place.
+func (room *Room) closeShared() {
+	room.directory.Close()
+	room.broker.Close()
+	if room.mailbox != nil {
+		room.mailbox.Close()
+	}
+	if room.auth != nil {
+		room.auth.Close()
+	}
+}
+
+// spawn creates a room sharing the accounts, roles, mailbox, names and
+// transports of this one, which closes once it is empty. It also shares the
+// clock and, in stepping mode, the steps.
+func (room *Room) spawn(name string) *Room {
+	roomConfig := *room.config
+	roomConfig.Name = name
+
+	child := newRoom(&roomConfig)
+	child.reap = true
+	child.loadState()
+	child.auth = room.auth
+	child.mailbox = room.mailbox
+	child.directory = room.directory
+	child.roles = room.roles
+	child.acl = room.acl
+	child.auditLog = room.auditLog
+	child.broker = room.broker
+	child.filter = room.filter
+	child.hooks = room.hooks
+	child.clock = room.clock
+	child.steps = room.steps
+	if room.cluster != nil {
+		child.SetCluster(room.cluster)
+	}
+	return child
+}
@@ -0,0 +1,149 @@
+package room
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Arun445/tcp-go/internal/message"
+	"github.com/Arun445/tcp-go/internal/session"
+)
+
+// maxMentions is how many mentions are kept per name for /mentions.
+const maxMentions = 50
+
+// everyone is the mention that highlights every member of the room.
+const everyone = "room"
+
+// mentionsEveryone reports whether a message body mentions @room.
+func mentionsEveryone(body []byte) bool {
+	for _, name := range mentions(strings.TrimRight(string(body), "\r\n")) {
+		if name == everyone {
+			return true
+		}
+	}
+	return false
+}
+
+// queueMentions stores broadcast messages that mention identities not
+// connected to any room and not about to resume.
+func (room *Room) queueMentions(sender *session.Session, m message.Message) {
+	text := strings.TrimRight(string(m.Body), "\r\n")
+	for _, name := range mentions(text) {
+		if name == everyone {
+			continue
+		}
+		if _, at := room.locate(name); at == nil {
+			room.enqueueOffline(m.ID, sender.DisplayName(), name, text)
+		}
+	}
+}
+
+// highlight notifies the sessions mentioned in a broadcast and records the
+// mention for verified identities, also those not connected right now. The
+// room that took the message checked that its sender may use @room.
+func (room *Room) highlight(m message.Message) {
+	text := strings.TrimRight(string(m.Body), "\r\n")
+	names := mentions(text)
+	if len(names) == 0 {
+		return
+	}
+
+	targets := make(map[string]*session.Session)
+	for _, name := range names {
+		if name == everyone {
+			for _, member := range room.sessions {
+				targets[member.DisplayName()] = member
+			}
+			continue
+		}
+		if member := room.lookup(name); member != nil {
+			targets[name] = member
+		} else if room.known(name) {
+			targets[name] = nil
+		}
+	}
+
+	at := m.Time
+	for name, member := range targets {
+		if member != nil && member.ID == m.SessionID {
+			continue
+		}
+		if member == nil || room.verified(member) {
+			room.recordMention(name, mention{ID: m.ID, From: m.From, Body: text, Time: m.Time})
+		}
+		if member != nil {
+			room.sendAfterBroadcasts(member, message.EncodeEmit(
+				message.Event{Type: "highlight", ID: m.ID, Seq: m.Seq, Room: room.config.Name, From: m.From, Time: &at, Body: text},
+				fmt.Sprintf("\a*** %s mentioned you: %s", m.From, text), member.Structured))
+		}
+	}
+}
+
+// known reports whether name belongs to an account, whose mentions are
+// kept while it is away. The account list is held in memory, so this does
+// not touch the disk.
+func (room *Room) known(name string) bool {
+	return room.auth != nil && room.auth.Exists(name)
+}
+
+// verified reports whether the session's name is an account it logged in
+// to, rather than one picked with /nick or as a guest.
+func (room *Room) verified(session *session.Session) bool {
+	return room.auth != nil && !session.Guest && session.Name != ""
+}
+
+func (room *Room) recordMention(name string, entry mention) {
+	recent := append(room.mentions[name], entry)
+	if len(recent) > maxMentions {
+		recent = recent[len(recent)-maxMentions:]
+	}
+	room.mentions[name] = recent
+}
+
+func (room *Room) listMentions(sender *session.Session, args []string) {
+	count := 10
+	if len(args) == 1 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n <= 0 {
+			sender.Notice("Usage: /mentions [count]")
+			return
+		}
+		count = n
+	}
+
+	if !room.verified(sender) {
+		sender.Notice("Mentions are only kept for accounts")
+		return
+	}
+	recent := room.mentions[sender.Name]
+	if len(recent) == 0 {
+		sender.Notice("No mentions")
+		return
+	}
+	if len(recent) > count {
+		recent = recent[len(recent)-count:]
+	}
+	for _, entry := range recent {
+		at := entry.Time
+		sender.Emit(message.Event{Type: "mention", ID: entry.ID, Room: room.config.Name, From: entry.From, Time: &at, Body: entry.Body},
+			fmt.Sprintf("[%s] #%d %s: %s", entry.Time.Format(time.RFC3339), entry.ID, entry.From, entry.Body))
+	}
+}
+
+func mentions(text string) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, word := range strings.Fields(text) {
+		if !strings.HasPrefix(word, "@") {
+			continue
+		}
+		name := strings.TrimRight(word[1:], ".,:;!?")
+		if validName.MatchString(name) && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
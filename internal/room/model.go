@@ -1,8 +1,19 @@
 package room
 
 import (
+	"time"
+
+	"github.com/Arun445/tcp-go/internal/acl"
+	"github.com/Arun445/tcp-go/internal/audit"
+	"github.com/Arun445/tcp-go/internal/auth"
+	"github.com/Arun445/tcp-go/internal/broker"
+	"github.com/Arun445/tcp-go/internal/clock"
+	"github.com/Arun445/tcp-go/internal/cluster"
 	"github.com/Arun445/tcp-go/internal/config"
+	"github.com/Arun445/tcp-go/internal/filter"
+	"github.com/Arun445/tcp-go/internal/mailbox"
 	"github.com/Arun445/tcp-go/internal/message"
+	"github.com/Arun445/tcp-go/internal/role"
 	"github.com/Arun445/tcp-go/internal/session"
 )
 
@@ -10,5 +21,153 @@ type Room struct {
 	config   *config.RoomConfig
 	events   chan Event
 	messages chan message.Message
+	remote   chan message.Message
 	sessions map[string]*session.Session
+	// names holds the named sessions in this room, directory those of every
+	// room sharing it.
+	names     map[string]*session.Session
+	directory *directory
+	mailbox   *mailbox.Store
+	auth      *auth.Service
+	roles     map[string]role.Role
+	state     state
+	acl       *acl.List
+	auditLog  *audit.Log
+	cluster   *cluster.Node
+	broker    broker.Broker
+	filter    *filter.Pipeline
+	hooks     Hooks
+	motd      string
+	clock     clock.Clock
+	// steps is set in stepping mode, where Open handles one event per Step.
+	// turn is where the Ack of the step under way goes, and published tells
+	// it that the message handled in it published something.
+	steps     chan chan Ack
+	turn      chan Ack
+	published bool
+
+	// reap closes a room spawned by a hub once nobody is in it or joining
+	// it. done is closed when the room stops, so senders from elsewhere can
+	// give up; closed tells Open to stop. stopping is set when the server
+	// shuts down: sessions are disconnected as they enter and do not stay
+	// to resume.
+	reap     bool
+	joining  int
+	closed   bool
+	stopping bool
+	done     chan struct{}
+
+	// Shards fan broadcasts out when BroadcastShards is set, and report
+	// back on deliveries.
+	shards     []chan<- shardOp
+	deliveries chan delivery
+
+	// Sessions that dropped from this room and may still come back with
+	// their token, and the old token of each session resuming here.
+	detached      map[string]*detached
+	detachedNames map[string]string
+	resuming      map[string]string
+	unsent        map[string][]queued
+
+	// Recent broadcasts, for retransmission and delivery reports.
+	seq      uint64
+	history  []message.Message
+	trackers map[uint64]*tracker
+
+	// Recent mentions per name, including names that were offline.
+	mentions map[string][]mention
+
+	transferSeq uint64
+	transfers   map[uint64]*transfer
+}
+
+// identity is who a session is, as copied by the room it is in for rooms
+// that must not read the session itself.
+type identity struct {
+	ID    string
+	Name  string
+	Guest bool
+}
+
+type tracker struct {
+	Sender    string
+	Delivered map[string]bool
+	Acked     map[string]bool
+}
+
+type mention struct {
+	ID   uint64
+	From string
+	Body string
+	Time time.Time
+}
+
+// queued is an encoded message waiting for a session. ID is the broadcast
+// it carries, so a deletion can take it back, and zero for anything else.
+type queued struct {
+	ID   uint64
+	Body []byte
+}
+
+type detached struct {
+	// SessionID is the ID the session had, under which the room still
+	// tracks what it received.
+	SessionID  string
+	Name       string
+	Guest      bool
+	Structured bool
+	Queue      []queued
+	Expires    time.Time
+}
+
+// directory keeps the names in use across the rooms of a hub, including
+// those of dropped sessions that may still resume. Its state is only
+// touched by the Open goroutine.
+type directory struct {
+	requests chan nameRequest
+	stop     chan struct{}
+	names    map[string]*listing
+	tokens   map[string]string
+}
+
+// listing is a name in use and the room its session is in. A dropped
+// session has no Session but the Token to resume it, in the room it
+// dropped from.
+type listing struct {
+	Session *session.Session
+	Room    *Room
+	Token   string
+}
+
+type nameRequestType int
+
+const (
+	claimName nameRequestType = iota
+	releaseName
+	enterRoom
+	locateName
+	detachName
+	locateToken
+	resumeToken
+	forgetToken
+)
+
+// nameRequest asks the directory about a name or a resume token. Takeover
+// lets a claim take a name from a dropped session.
+type nameRequest struct {
+	Type     nameRequestType
+	Session  *session.Session
+	Name     string
+	Room     *Room
+	Token    string
+	Guest    bool
+	Takeover bool
+	Reply    chan nameResponse
+}
+
+type nameResponse struct {
+	Name    string
+	OK      bool
+	Session *session.Session
+	Room    *Room
 }
@@ -0,0 +1,229 @@
+package room
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+
+	"github.com/Arun445/tcp-go/internal/audit"
+	"github.com/Arun445/tcp-go/internal/auth"
+	"github.com/Arun445/tcp-go/internal/message"
+	"github.com/Arun445/tcp-go/internal/role"
+	"github.com/Arun445/tcp-go/internal/session"
+)
+
+const (
+	modePublic   = "public"
+	modeInvite   = "invite"
+	modePassword = "password"
+)
+
+type roomInfo struct {
+	Name    string
+	Mode    string
+	Members int
+	Limit   int
+	Topic   string
+	Visible bool
+}
+
+func (room *Room) mode() string {
+	if room.state.Mode == "" {
+		return modePublic
+	}
+	return room.state.Mode
+}
+
+// isMember reports whether someone may enter regardless of the room mode.
+func (room *Room) isMember(who identity, r role.Role) bool {
+	if r.Can(role.BypassRoomLimits) {
+		return true
+	}
+	return !who.Guest && who.Name != "" && room.state.Members[who.Name]
+}
+
+// checkPassword hashes the password of a join into a password room off the
+// loop, since that takes a while, and sends the join back once it is
+// checked. It reports whether the join is on its way back.
+func (room *Room) checkPassword(event Event) bool {
+	who := identityOf(event.Session)
+	if event.Checked || event.Resumed || room.mode() != modePassword || room.state.Password == nil || room.isMember(who, room.roleFor(who)) {
+		return false
+	}
+	if _, ok := room.sessions[who.ID]; ok {
+		return false
+	}
+
+	password := room.state.Password
+	room.joining++
+	go func() {
+		event.Checked = true
+		if password.Verify(event.Password) {
+			event.Verified = password
+		}
+		select {
+		case room.events <- event:
+		case <-room.done:
+		}
+	}()
+	return true
+}
+
+// admit lets a session in from another room if the mode and member limit
+// allow it. A session creating the room becomes its owner.
+func (room *Room) admit(event Event) bool {
+	session := event.Session
+	if _, ok := room.sessions[session.ID]; ok {
+		session.Notice(fmt.Sprintf("You are already in #%s", room.config.Name))
+		return false
+	}
+	if event.Created {
+		room.auditLog.Record(audit.Record{Action: audit.RoomCreate, Actor: session.DisplayName(), Room: room.config.Name, Session: session.ID})
+	}
+	if event.Created && room.auth != nil && !session.Guest && session.Name != "" {
+		room.state.Roles[session.Name] = role.Owner
+		room.saveState()
+	}
+
+	r := room.roleOf(session)
+	member := room.isMember(identityOf(session), r)
+	switch {
+	case event.Resumed:
+		// It was in the room when it dropped.
+	case room.mode() == modeInvite && !member:
+		session.Notice(fmt.Sprintf("#%s is invite-only", room.config.Name))
+		return false
+	case room.mode() == modePassword && !member && (room.state.Password == nil || event.Verified != room.state.Password):
+		session.Notice(fmt.Sprintf("Wrong password for #%s", room.config.Name))
+		return false
+	case room.state.Limit > 0 && len(room.sessions) >= room.state.Limit && !r.Can(role.BypassRoomLimits):
+		session.Notice(fmt.Sprintf("#%s is full", room.config.Name))
+		return false
+	}
+
+	room.enter(session)
+	if session.Name != "" {
+		room.names[session.Name] = session
+	}
+	log.Printf("Session %s joined %s", session.ID, room.config.Name)
+	session.Notice(fmt.Sprintf("Joined #%s", room.config.Name))
+	if room.state.Topic != nil {
+		room.showTopic(session)
+	}
+	room.reclaim(session)
+	return true
+}
+
+// depart removes a session that moved to another room. Unlike unregister it
+// leaves the connection and resume state alone.
+func (room *Room) depart(session *session.Session, ready chan struct{}) {
+	if !room.remove(session) {
+		close(ready)
+		return
+	}
+	log.Printf("Session %s left %s", session.ID, room.config.Name)
+	room.release(session, ready, false)
+}
+
+// describe summarises the room for a /rooms listing. Invite-only rooms are
+// only visible to their members.
+func (room *Room) describe(viewer identity) roomInfo {
+	info := roomInfo{
+		Name:    room.config.Name,
+		Mode:    room.mode(),
+		Members: len(room.sessions),
+		Limit:   room.state.Limit,
+	}
+	if room.state.Topic != nil {
+		info.Topic = room.state.Topic.Text
+	}
+	_, present := room.sessions[viewer.ID]
+	info.Visible = info.Mode != modeInvite || present || room.isMember(viewer, room.roleFor(viewer))
+	return info
+}
+
+func (room *Room) listRooms(session *session.Session, rooms []roomInfo) {
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].Name < rooms[j].Name })
+	for _, info := range rooms {
+		if !info.Visible {
+			continue
+		}
+		size := strconv.Itoa(info.Members)
+		if info.Limit > 0 {
+			size += "/" + strconv.Itoa(info.Limit)
+		}
+		fallback := fmt.Sprintf("#%s (%s, %s members)", info.Name, info.Mode, size)
+		if info.Topic != "" {
+			fallback += " - " + info.Topic
+		}
+		session.Emit(message.Event{Type: "room", Room: info.Name, Mode: info.Mode, Members: info.Members, Limit: info.Limit, Text: info.Topic}, fallback)
+	}
+}
+
+func (room *Room) invite(sender *session.Session, name string) {
+	if !sender.Role.Can(role.ManageRoom) {
+		sender.Notice("You are not allowed to invite to this room")
+		return
+	}
+	if room.auth == nil || !room.auth.Exists(name) {
+		sender.Notice(fmt.Sprintf("No such account: %s", name))
+		return
+	}
+
+	room.state.Members[name] = true
+	room.saveState()
+	log.Printf("%s invited %s to %s", sender.DisplayName(), name, room.config.Name)
+	room.auditLog.Record(audit.Record{Action: audit.RoomConfig, Actor: sender.DisplayName(), Target: name, Room: room.config.Name, Detail: "invite"})
+	sender.Notice(fmt.Sprintf("Invited %s to #%s", name, room.config.Name))
+}
+
+func (room *Room) setMode(sender *session.Session, args []string) {
+	if !sender.Role.Can(role.ManageRoom) {
+		sender.Notice("You are not allowed to change the room mode")
+		return
+	}
+	usage := "Usage: /mode <public|invite> | /mode password <password>"
+	if len(args) == 0 {
+		sender.Notice(usage)
+		return
+	}
+
+	switch {
+	case args[0] == modePublic && len(args) == 1:
+		room.state.Mode, room.state.Password = "", nil
+	case args[0] == modeInvite && len(args) == 1:
+		room.state.Mode, room.state.Password = modeInvite, nil
+	case args[0] == modePassword && len(args) == 2:
+		password, err := auth.NewUser(room.config.Name, args[1])
+		if err != nil {
+			sender.Notice(fmt.Sprintf("Failed to set password: %v", err))
+			return
+		}
+		room.state.Mode, room.state.Password = modePassword, &password
+	default:
+		sender.Notice(usage)
+		return
+	}
+	room.saveState()
+	log.Printf("Mode of %s set to %s by %s", room.config.Name, room.mode(), sender.DisplayName())
+	room.auditLog.Record(audit.Record{Action: audit.RoomConfig, Actor: sender.DisplayName(), Room: room.config.Name, Detail: "mode " + room.mode()})
+	sender.Notice(fmt.Sprintf("#%s is now %s", room.config.Name, room.mode()))
+}
+
+func (room *Room) setLimit(sender *session.Session, arg string) {
+	if !sender.Role.Can(role.ManageRoom) {
+		sender.Notice("You are not allowed to change the member limit")
+		return
+	}
+	limit, err := strconv.Atoi(arg)
+	if err != nil || limit < 0 {
+		sender.Notice("Usage: /limit <members>, 0 removes the limit")
+		return
+	}
+
+	room.state.Limit = limit
+	room.saveState()
+	room.auditLog.Record(audit.Record{Action: audit.RoomConfig, Actor: sender.DisplayName(), Room: room.config.Name, Detail: fmt.Sprintf("limit %d", limit)})
+	sender.Notice(fmt.Sprintf("Member limit of #%s set to %d", room.config.Name, limit))
+}
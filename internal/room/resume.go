@@ -0,0 +1,182 @@
+package room
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Arun445/tcp-go/internal/session"
+)
+
+// issueToken gives the session a fresh resume token.
+func (room *Room) issueToken(session *session.Session) {
+	if room.config.ResumeGrace <= 0 {
+		return
+	}
+
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		log.Printf("Failed to create resume token: %v", err)
+		return
+	}
+	session.Token = hex.EncodeToString(token)
+	session.Notice(fmt.Sprintf("Resume token: %s", session.Token))
+	room.reclaim(session)
+}
+
+// detach keeps a dropped session around for the grace period, together with
+// anything that could not be written to it, and holds its name across the
+// hub meanwhile. It reports whether the session may resume.
+func (room *Room) detach(session *session.Session) bool {
+	unsent := room.unsent[session.ID]
+	delete(room.unsent, session.ID)
+	if session.Token == "" || room.config.ResumeGrace <= 0 || session.Name == "" {
+		return false
+	}
+
+	record := &detached{
+		SessionID:  session.ID,
+		Name:       session.Name,
+		Guest:      session.Guest,
+		Structured: session.Structured,
+		Expires:    room.clock.Now().Add(room.config.ResumeGrace),
+	}
+	for _, q := range unsent {
+		record.queue(q.ID, q.Body, room.config.ResumeQueue)
+	}
+	room.detached[session.Token] = record
+	room.detachedNames[session.Name] = session.Token
+	room.holdName(session, session.Name, session.Token)
+	return true
+}
+
+// resume restores the identity behind session.Token, which dropped from
+// this room. On failure the token is cleared and the session keeps no name.
+// The record goes on queueing until the session is back in the room.
+func (room *Room) resume(session *session.Session) {
+	record, ok := room.detached[session.Token]
+	if !ok || room.clock.Now().After(record.Expires) || !room.redeem(session, session.Token) {
+		session.Token = ""
+		return
+	}
+
+	session.Name = record.Name
+	session.Guest = record.Guest
+	session.Structured = record.Structured
+	room.resuming[session.ID] = session.Token
+	log.Printf("Session %s resumed %s", session.ID, record.Name)
+}
+
+// reclaim hands a resumed session what was queued for it while it was
+// away, once it is back in the room it dropped from.
+func (room *Room) reclaim(session *session.Session) {
+	token, ok := room.resuming[session.ID]
+	if !ok {
+		return
+	}
+	delete(room.resuming, session.ID)
+	record, ok := room.detached[token]
+	if !ok {
+		return
+	}
+	room.forget(token)
+	room.carryOver(record.SessionID, session)
+	for _, q := range record.Queue {
+		if session.Send(q.Body) {
+			if tracked, ok := room.trackers[q.ID]; ok {
+				tracked.Delivered[session.ID] = true
+			}
+		}
+	}
+}
+
+// carryOver moves what the room tracks under the ID a session had before it
+// dropped to the session that resumed it, which has a new ID: deliveries and
+// the transfers it sends or receives.
+func (room *Room) carryOver(old string, session *session.Session) {
+	for _, t := range room.transfers {
+		if t.Sender == old {
+			t.Sender = session.ID
+		}
+		if r, ok := t.Recipients[old]; ok {
+			delete(t.Recipients, old)
+			t.Recipients[session.ID] = r
+		}
+	}
+	for _, tracked := range room.trackers {
+		if tracked.Sender == old {
+			tracked.Sender = session.ID
+		}
+		if tracked.Delivered[old] {
+			delete(tracked.Delivered, old)
+			tracked.Delivered[session.ID] = true
+		}
+		if tracked.Acked[old] {
+			delete(tracked.Acked, old)
+			tracked.Acked[session.ID] = true
+		}
+	}
+}
+
+// keepUnsent remembers messages a closing session could no longer take.
+func (room *Room) keepUnsent(session *session.Session, id uint64, body []byte) {
+	if session.Token != "" {
+		room.unsent[session.ID] = append(room.unsent[session.ID], queued{ID: id, Body: body})
+	}
+}
+
+func (room *Room) expireDetached(now time.Time) {
+	for token, record := range room.detached {
+		if now.After(record.Expires) {
+			log.Printf("Resume grace expired for %s", record.Name)
+			room.forget(token)
+			room.forgetToken(token)
+		}
+	}
+	for id, token := range room.resuming {
+		if _, ok := room.detached[token]; !ok {
+			delete(room.resuming, id)
+		}
+	}
+}
+
+func (room *Room) forget(token string) {
+	if record, ok := room.detached[token]; ok {
+		if room.detachedNames[record.Name] == token {
+			delete(room.detachedNames, record.Name)
+		}
+		delete(room.detached, token)
+	}
+}
+
+// queue appends a message, dropping the oldest once limit is reached.
+func (record *detached) queue(id uint64, body []byte, limit int) {
+	record.Queue = append(record.Queue, queued{ID: id, Body: body})
+	if limit > 0 && len(record.Queue) > limit {
+		record.Queue = record.Queue[len(record.Queue)-limit:]
+	}
+}
+
+// withdraw drops a deleted broadcast from everything still waiting for a
+// session: resume queues and what closing sessions could not take.
+func (room *Room) withdraw(id uint64) {
+	for _, record := range room.detached {
+		record.Queue = without(record.Queue, id)
+	}
+	for sessionID, unsent := range room.unsent {
+		room.unsent[sessionID] = without(unsent, id)
+	}
+}
+
+func without(queue []queued, id uint64) []queued {
+	kept := queue[:0]
+	for _, q := range queue {
+		if q.ID != id {
+			kept = append(kept, q)
+		}
+	}
+	clear(queue[len(kept):])
+	return kept
+}
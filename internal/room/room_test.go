@@ -1,44 +1,119 @@
 package room
 
 import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"io"
+	"log"
 	"net"
-	"sync"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/Arun445/tcp-go/internal/audit"
 	"github.com/Arun445/tcp-go/internal/config"
 	"github.com/Arun445/tcp-go/internal/message"
 	"github.com/Arun445/tcp-go/internal/session"
+	"github.com/Arun445/tcp-go/internal/transport"
 )
 
+// stepped opens a room that handles one event per step.
+func stepped(roomConfig *config.RoomConfig) *Room {
+	room := NewRoom(roomConfig)
+	room.SetStepping()
+	go room.Open()
+	return room
+}
+
+func step(t *testing.T, room *Room) Ack {
+	t.Helper()
+	ack, ok := room.Step(time.Second)
+	if !ok {
+		t.Fatal("Room handled no event")
+	}
+	return ack
+}
+
+// post hands an event to a stepping room, which takes it on the next step.
+func post(room *Room, event Event) {
+	go func() { room.events <- event }()
+}
+
+// handle posts event and steps the room through it.
+func handle(t *testing.T, room *Room, event Event) Ack {
+	t.Helper()
+	post(room, event)
+	return step(t, room)
+}
+
+// say sends line from s to a stepping room and steps until the room has
+// handled it, and broadcast it when it was published.
+func say(t *testing.T, room *Room, s *session.Session, line string) {
+	t.Helper()
+	go func() { room.messages <- message.Message{SessionID: s.ID, Body: []byte(line + "\n")} }()
+	ack := step(t, room)
+	if ack.Kind != AckMessage || ack.SessionID != s.ID {
+		t.Fatalf("Expected the message from %s, got %+v", s.ID, ack)
+	}
+	if ack.Published {
+		if ack := step(t, room); ack.Kind != AckBroadcast || ack.SessionID != s.ID {
+			t.Fatalf("Expected the broadcast from %s, got %+v", s.ID, ack)
+		}
+	}
+}
+
+// receive returns the next message the room queued for s.
+func receive(t *testing.T, s *session.Session) *message.Buffer {
+	t.Helper()
+	select {
+	case msg := <-s.Messages:
+		return msg
+	default:
+		t.Fatalf("%s has no message", s.ID)
+		return nil
+	}
+}
+
+// quiet checks that the room queued nothing for s.
+func quiet(t *testing.T, s *session.Session) {
+	t.Helper()
+	select {
+	case msg := <-s.Messages:
+		t.Errorf("Expected nothing for %s, got %q", s.ID, msg.String())
+	default:
+	}
+}
+
 func TestRoom_Open_RegisterUnregister(t *testing.T) {
 	config := &config.RoomConfig{
 		ByteLimit: 100,
 	}
 
-	room := NewRoom(config)
-
-	done := make(chan struct{})
-	go func() {
-		room.Open()
-		close(done)
-	}()
+	room := stepped(config)
 
 	serverConn, clientConn := net.Pipe()
 	defer clientConn.Close()
 
 	testSession := &session.Session{
-		ID:       "test-session-1",
-		Conn:     serverConn,
-		Messages: make(chan []byte, 1),
-		Done:     make(chan struct{}),
+		ID:        "test-session-1",
+		Transport: transport.Conn(serverConn),
+		Messages:  make(chan *message.Buffer, 1),
+		Done:      make(chan struct{}),
 	}
 
 	// Test registration
-	room.events <- Event{Session: testSession, Type: Register}
-
-	time.Sleep(50 * time.Millisecond)
+	post(room, Event{Session: testSession, Type: Register})
+	if ack := step(t, room); ack.Kind != AckEvent || ack.Event != Register || ack.SessionID != "test-session-1" {
+		t.Errorf("Expected register ack, got %+v", ack)
+	}
 	if len(room.sessions) != 1 {
 		t.Errorf("Expected 1 session, got %d", len(room.sessions))
 	}
@@ -48,8 +123,8 @@ func TestRoom_Open_RegisterUnregister(t *testing.T) {
 	}
 
 	// Test unregistration
-	room.events <- Event{Session: testSession, Type: Unregister}
-	time.Sleep(50 * time.Millisecond)
+	post(room, Event{Session: testSession, Type: Unregister})
+	step(t, room)
 	if len(room.sessions) != 0 {
 		t.Errorf("Expected 0 sessions, got %d", len(room.sessions))
 	}
@@ -60,8 +135,7 @@ func TestRoom_Open_MessageBroadcast(t *testing.T) {
 		ByteLimit: 100,
 	}
 
-	room := NewRoom(config)
-	go room.Open()
+	room := stepped(config)
 
 	serverConn1, clientConn1 := net.Pipe()
 	defer clientConn1.Close()
@@ -70,23 +144,23 @@ func TestRoom_Open_MessageBroadcast(t *testing.T) {
 	defer clientConn2.Close()
 
 	session1 := &session.Session{
-		ID:       "session-1",
-		Conn:     serverConn1,
-		Messages: make(chan []byte, 10),
-		Done:     make(chan struct{}),
+		ID:        "session-1",
+		Transport: transport.Conn(serverConn1),
+		Messages:  make(chan *message.Buffer, 10),
+		Done:      make(chan struct{}),
 	}
 
 	session2 := &session.Session{
-		ID:       "session-2",
-		Conn:     serverConn2,
-		Messages: make(chan []byte, 10),
-		Done:     make(chan struct{}),
+		ID:        "session-2",
+		Transport: transport.Conn(serverConn2),
+		Messages:  make(chan *message.Buffer, 10),
+		Done:      make(chan struct{}),
 	}
 
-	room.events <- Event{Session: session1, Type: Register}
-	room.events <- Event{Session: session2, Type: Register}
-
-	time.Sleep(50 * time.Millisecond)
+	post(room, Event{Session: session1, Type: Register})
+	step(t, room)
+	post(room, Event{Session: session2, Type: Register})
+	step(t, room)
 
 	if len(room.sessions) != 2 {
 		t.Fatalf("Expected 2 sessions, got %d", len(room.sessions))
@@ -97,23 +171,27 @@ func TestRoom_Open_MessageBroadcast(t *testing.T) {
 		Body:      []byte("Message from session 1"),
 	}
 
-	room.messages <- testMessage
-
-	time.Sleep(50 * time.Millisecond)
+	go func() { room.messages <- testMessage }()
+	if ack := step(t, room); ack.Kind != AckMessage || !ack.Published {
+		t.Fatalf("Expected published message ack, got %+v", ack)
+	}
+	if ack := step(t, room); ack.Kind != AckBroadcast || ack.SessionID != "session-1" {
+		t.Fatalf("Expected broadcast ack, got %+v", ack)
+	}
 
 	select {
 	case msg := <-session2.Messages:
-		if string(msg) != "Message from session 1" {
-			t.Errorf("Expected 'Message from session 1', got %s", string(msg))
+		if msg.String() != "Message from session 1" {
+			t.Errorf("Expected 'Message from session 1', got %s", msg.String())
 		}
-	case <-time.After(100 * time.Millisecond):
+	default:
 		t.Error("Session2 did not receive message")
 	}
 
 	select {
 	case <-session1.Messages:
 		t.Error("Session1 received its own message")
-	case <-time.After(10 * time.Millisecond):
+	default:
 		// should not receive its own message
 	}
 }
@@ -123,23 +201,27 @@ func TestRoom_NewSession_Integration(t *testing.T) {
 		ByteLimit: 100,
 	}
 
-	room := NewRoom(config)
-	go room.Open()
+	room := stepped(config)
 
 	serverConn, clientConn := net.Pipe()
 
 	go room.NewSession(serverConn)
 
-	if len(room.sessions) == 1 {
+	step(t, room)
+	if len(room.sessions) != 1 {
 		t.Errorf("Expected 1 sessions, got %d", len(room.sessions))
 	}
 
 	clientConn.Write([]byte("test message"))
-	time.Sleep(1000 * time.Millisecond)
+	if ack := step(t, room); ack.Kind != AckMessage {
+		t.Errorf("Expected message ack, got %+v", ack)
+	}
+	step(t, room)
 	clientConn.Close()
 
-	time.Sleep(100 * time.Millisecond)
-
+	if ack := step(t, room); ack.Kind != AckEvent || ack.Event != Unregister {
+		t.Errorf("Expected unregister ack, got %+v", ack)
+	}
 	if len(room.sessions) != 0 {
 		t.Errorf("Expected 0 sessions, got %d", len(room.sessions))
 	}
@@ -150,8 +232,7 @@ func TestRoom_MessageBroadcast_MultipleSessions(t *testing.T) {
 		ByteLimit: 1000,
 	}
 
-	room := NewRoom(config)
-	go room.Open()
+	room := stepped(config)
 
 	numSessions := 5
 	sessions := make([]*session.Session, numSessions)
@@ -164,17 +245,16 @@ func TestRoom_MessageBroadcast_MultipleSessions(t *testing.T) {
 		clientConns[i] = clientConn
 
 		session := &session.Session{
-			ID:       fmt.Sprintf("session-%d", i),
-			Conn:     serverConn,
-			Messages: make(chan []byte, 10),
-			Done:     make(chan struct{}),
+			ID:        fmt.Sprintf("session-%d", i),
+			Transport: transport.Conn(serverConn),
+			Messages:  make(chan *message.Buffer, 10),
+			Done:      make(chan struct{}),
 		}
 		sessions[i] = session
-		room.events <- Event{Session: session, Type: Register}
+		post(room, Event{Session: session, Type: Register})
+		step(t, room)
 	}
 
-	time.Sleep(100 * time.Millisecond)
-
 	if len(room.sessions) != numSessions {
 		t.Fatalf("Expected %d sessions, got %d", numSessions, len(room.sessions))
 	}
@@ -183,17 +263,17 @@ func TestRoom_MessageBroadcast_MultipleSessions(t *testing.T) {
 		SessionID: "session-0",
 		Body:      []byte("random message"),
 	}
-	room.messages <- testMessage
-
-	time.Sleep(100 * time.Millisecond)
+	go func() { room.messages <- testMessage }()
+	step(t, room)
+	step(t, room)
 
 	for i := 1; i < numSessions; i++ {
 		select {
 		case msg := <-sessions[i].Messages:
-			if string(msg) != "random message" {
-				t.Errorf("Session %d: expected 'random message', got %s", i, string(msg))
+			if msg.String() != "random message" {
+				t.Errorf("Session %d: expected 'random message', got %s", i, msg.String())
 			}
-		case <-time.After(100 * time.Millisecond):
+		default:
 			t.Errorf("Session %d did not receive message", i)
 		}
 	}
@@ -201,7 +281,7 @@ func TestRoom_MessageBroadcast_MultipleSessions(t *testing.T) {
 	select {
 	case <-sessions[0].Messages:
 		t.Error("Session 0 received its own message")
-	case <-time.After(10 * time.Millisecond):
+	default:
 		// expected
 	}
 
@@ -216,30 +296,24 @@ func TestRoom_ConcurrentOperations(t *testing.T) {
 		ByteLimit: 1000,
 	}
 
-	room := NewRoom(config)
-	go room.Open()
+	room := stepped(config)
 
-	var wg sync.WaitGroup
 	numGoroutines := 10
 
 	for i := 0; i < numGoroutines; i++ {
-		wg.Add(1)
 		go func(id int) {
-			defer wg.Done()
-
 			serverConn, clientConn := net.Pipe()
 			defer clientConn.Close()
 			defer serverConn.Close()
 
 			session := &session.Session{
-				ID:       fmt.Sprintf("concurrent-session-%d", id),
-				Conn:     serverConn,
-				Messages: make(chan []byte, 10),
-				Done:     make(chan struct{}),
+				ID:        fmt.Sprintf("concurrent-session-%d", id),
+				Transport: transport.Conn(serverConn),
+				Messages:  make(chan *message.Buffer, 10),
+				Done:      make(chan struct{}),
 			}
 
 			room.events <- Event{Session: session, Type: Register}
-			time.Sleep(10 * time.Millisecond)
 
 			msg := message.Message{
 				SessionID: session.ID,
@@ -247,16 +321,1586 @@ func TestRoom_ConcurrentOperations(t *testing.T) {
 			}
 			room.messages <- msg
 
-			time.Sleep(10 * time.Millisecond)
-
 			room.events <- Event{Session: session, Type: Unregister}
 		}(i)
 	}
 
-	wg.Wait()
-	time.Sleep(100 * time.Millisecond)
+	// Every session registers, posts one broadcast and unregisters.
+	for unregistered := 0; unregistered < numGoroutines; {
+		if ack := step(t, room); ack.Kind == AckEvent && ack.Event == Unregister {
+			unregistered++
+		}
+	}
 
 	if len(room.sessions) != 0 {
 		t.Errorf("Expected 0 sessions after cleanup, got %d", len(room.sessions))
 	}
 }
+
+func TestRoom_OfflineDelivery(t *testing.T) {
+	config := &config.RoomConfig{
+		ByteLimit:     1000,
+		MailboxDir:    t.TempDir(),
+		MailboxSize:   10,
+		MailboxMaxAge: time.Hour,
+		Auth:          &config.AuthConfig{Mode: config.AuthRequired, UsersFile: filepath.Join(t.TempDir(), "users.json")},
+	}
+
+	room := stepped(config)
+	// The mailbox writes behind; let it finish before the directory goes.
+	t.Cleanup(room.mailbox.Sync)
+
+	alice := &session.Session{
+		ID:       "alice-session",
+		Name:     "alice",
+		Messages: make(chan *message.Buffer, 10),
+		Done:     make(chan struct{}),
+	}
+	bob := &session.Session{
+		ID:       "bob-session",
+		Name:     "bob",
+		Messages: make(chan *message.Buffer, 10),
+		Done:     make(chan struct{}),
+	}
+
+	handle(t, room, Event{Session: bob, Type: Register})
+	handle(t, room, Event{Session: bob, Type: Unregister})
+	handle(t, room, Event{Session: alice, Type: Register})
+
+	say(t, room, alice, "/msg bob first")
+	if msg := receive(t, alice); !strings.Contains(msg.String(), "bob is offline") {
+		t.Errorf("Expected offline notice, got %s", msg.String())
+	}
+	say(t, room, alice, "hey @bob, second")
+
+	returning := &session.Session{
+		ID:       "bob-session-2",
+		Name:     "bob",
+		Messages: make(chan *message.Buffer, 10),
+		Done:     make(chan struct{}),
+	}
+	handle(t, room, Event{Session: returning, Type: Register})
+
+	for _, expected := range []string{"alice: first", "alice: hey @bob, second"} {
+		if msg := receive(t, returning); !strings.Contains(msg.String(), expected) {
+			t.Errorf("Expected '%s', got %s", expected, msg.String())
+		}
+	}
+}
+
+func TestRoom_NewSession_Handshake(t *testing.T) {
+	config := &config.RoomConfig{
+		ByteLimit: 1000,
+		Auth: &config.AuthConfig{
+			Mode:          config.AuthRequired,
+			UsersFile:     filepath.Join(t.TempDir(), "users.json"),
+			AllowRegister: true,
+			MaxFailures:   3,
+			Lockout:       time.Minute,
+		},
+	}
+
+	room := stepped(config)
+
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	go room.NewSession(serverConn)
+
+	reader := bufio.NewReader(clientConn)
+	clientConn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := reader.ReadString('\n'); err != nil {
+		t.Fatalf("Failed to read greeting: %v", err)
+	}
+
+	clientConn.Write([]byte("GUEST mallory\n"))
+	reply, _ := reader.ReadString('\n')
+	if !strings.Contains(reply, "Guests are not allowed") {
+		t.Errorf("Expected guests to be rejected, got %s", reply)
+	}
+
+	clientConn.Write([]byte("REGISTER alice secret\n"))
+	reply, _ = reader.ReadString('\n')
+	if !strings.Contains(reply, "Welcome alice") {
+		t.Fatalf("Expected welcome, got %s", reply)
+	}
+	if ack := step(t, room); ack.Kind != AckEvent || ack.Event != Register {
+		t.Fatalf("Expected alice to be registered, got %+v", ack)
+	}
+
+	clientConn.Write([]byte("/nick bob\n"))
+	if ack := step(t, room); ack.Kind != AckMessage {
+		t.Fatalf("Expected the /nick message, got %+v", ack)
+	}
+	reply, _ = reader.ReadString('\n')
+	if !strings.Contains(reply, "set at login") {
+		t.Errorf("Expected /nick to be refused, got %s", reply)
+	}
+}
+
+func TestRoom_NewSession_Compression(t *testing.T) {
+	config := &config.RoomConfig{
+		ByteLimit: 1000,
+		Auth: &config.AuthConfig{
+			Mode:      config.AuthGuest,
+			UsersFile: filepath.Join(t.TempDir(), "users.json"),
+		},
+	}
+
+	room := NewRoom(config)
+	go room.Open()
+
+	serverConn, clientConn := net.Pipe()
+	client := transport.Compressible(transport.Conn(clientConn))
+	defer client.Close()
+	go room.Serve(transport.Compressible(transport.Conn(serverConn)))
+
+	readLine := func() string {
+		t.Helper()
+		var line []byte
+		for !strings.HasSuffix(string(line), "\n") {
+			frame, err := client.ReadFrame()
+			if err != nil {
+				t.Fatalf("Failed to read: %v", err)
+			}
+			line = append(line, frame...)
+		}
+		return string(line)
+	}
+
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	if greeting := readLine(); !strings.Contains(greeting, "COMPRESS deflate") {
+		t.Errorf("Expected compression to be offered, got %s", greeting)
+	}
+
+	client.WriteFrame([]byte("COMPRESS gzip\n"))
+	if reply := readLine(); !strings.Contains(reply, "Usage: COMPRESS deflate") {
+		t.Errorf("Expected usage, got %s", reply)
+	}
+
+	client.WriteFrame([]byte("COMPRESS deflate\n"))
+	if reply := readLine(); reply != "Compression enabled\n" {
+		t.Fatalf("Expected compression to be enabled, got %s", reply)
+	}
+	client.Compress(nil, nil)
+
+	client.WriteFrame([]byte("GUEST alice\n"))
+	if reply := readLine(); !strings.Contains(reply, "Welcome alice") {
+		t.Fatalf("Expected a compressed welcome, got %s", reply)
+	}
+	client.WriteFrame([]byte("/topic\n"))
+	if reply := readLine(); !strings.Contains(reply, "No topic") {
+		t.Errorf("Expected a compressed reply, got %s", reply)
+	}
+}
+
+func TestRoom_NewSession_CompressionWithoutAuth(t *testing.T) {
+	room := NewRoom(&config.RoomConfig{ByteLimit: 1000})
+	go room.Open()
+
+	serverConn, clientConn := net.Pipe()
+	client := transport.Compressible(transport.Conn(clientConn))
+	defer client.Close()
+	go room.Serve(transport.Compressible(transport.Conn(serverConn)))
+
+	reader := bufio.NewReader(&frameReader{t: client})
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	if offer, _ := reader.ReadString('\n'); !strings.Contains(offer, "COMPRESS deflate") {
+		t.Errorf("Expected compression to be offered, got %s", offer)
+	}
+
+	client.WriteFrame([]byte("COMPRESS deflate\n"))
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read: %v", err)
+		}
+		if line == "Compression enabled\n" {
+			break
+		}
+	}
+	client.Compress(nil, nil)
+
+	client.WriteFrame([]byte("/topic\n"))
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Expected a compressed reply: %v", err)
+		}
+		if strings.Contains(line, "No topic") {
+			break
+		}
+	}
+}
+
+// frameReader reads the frames of a transport as a stream.
+type frameReader struct {
+	t       transport.Transport
+	pending []byte
+}
+
+func (r *frameReader) Read(p []byte) (int, error) {
+	if len(r.pending) == 0 {
+		frame, err := r.t.ReadFrame()
+		if err != nil {
+			return 0, err
+		}
+		r.pending = frame
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+func TestRoom_Audit(t *testing.T) {
+	dir := t.TempDir()
+	auditLog, err := audit.Load(filepath.Join(dir, "audit.jsonl"))
+	if err != nil {
+		t.Fatalf("Failed to load audit log: %v", err)
+	}
+	go auditLog.Open()
+
+	room := NewRoom(&config.RoomConfig{
+		ByteLimit: 30,
+		Auth: &config.AuthConfig{
+			Mode:          config.AuthRequired,
+			UsersFile:     filepath.Join(dir, "users.json"),
+			AllowRegister: true,
+			MaxFailures:   3,
+			Lockout:       time.Minute,
+		},
+	})
+	room.SetAudit(auditLog)
+	go room.Open()
+
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	go room.NewSession(serverConn)
+
+	reader := bufio.NewReader(clientConn)
+	clientConn.SetReadDeadline(time.Now().Add(time.Second))
+	reader.ReadString('\n')
+	clientConn.Write([]byte("REGISTER alice secret\n"))
+	reader.ReadString('\n')
+	clientConn.Write([]byte("this message is over the thirty byte limit\n"))
+	if reply, _ := reader.ReadString('\n'); !strings.Contains(reply, "Upload limit reached") {
+		t.Fatalf("Expected the upload limit, got %s", reply)
+	}
+
+	serverConn, clientConn = net.Pipe()
+	defer clientConn.Close()
+	go room.NewSession(serverConn)
+
+	reader = bufio.NewReader(clientConn)
+	clientConn.SetReadDeadline(time.Now().Add(time.Second))
+	reader.ReadString('\n')
+	clientConn.Write([]byte("LOGIN alice wrong\n"))
+	reader.ReadString('\n')
+
+	auditLog.Flush()
+	file, err := os.Open(filepath.Join(dir, "audit.jsonl"))
+	if err != nil {
+		t.Fatalf("Failed to open audit log: %v", err)
+	}
+	defer file.Close()
+	if _, count, err := audit.Verify(file); err != nil || count != 3 {
+		t.Fatalf("Expected 3 chained records, got %d: %v", count, err)
+	}
+
+	file.Seek(0, io.SeekStart)
+	var records []audit.Record
+	decoder := json.NewDecoder(file)
+	for {
+		var record audit.Record
+		if decoder.Decode(&record) != nil {
+			break
+		}
+		records = append(records, record)
+	}
+	if r := records[0]; r.Action != audit.Login || r.Actor != "alice" || r.Detail != "register" {
+		t.Errorf("Expected alice's registration, got %+v", r)
+	}
+	if r := records[1]; r.Action != audit.LimitDisconnect || r.Target != "alice" || r.Session != records[0].Session || r.Detail != "upload limit reached" {
+		t.Errorf("Expected alice's session to be disconnected at the upload limit, got %+v", r)
+	}
+	if r := records[2]; r.Action != audit.LoginFailed || r.Target != "alice" || r.Detail != "invalid credentials" {
+		t.Errorf("Expected a failed login as alice, got %+v", r)
+	}
+}
+
+func TestRoom_Kick_Permissions(t *testing.T) {
+	dir := t.TempDir()
+	rolesFile := filepath.Join(dir, "roles.json")
+	os.WriteFile(rolesFile, []byte(`{"alice": "moderator"}`), 0o600)
+
+	config := &config.RoomConfig{
+		Name:      "test",
+		ByteLimit: 1000,
+		StateDir:  dir,
+		RolesFile: rolesFile,
+		Auth:      &config.AuthConfig{Mode: config.AuthRequired, UsersFile: filepath.Join(dir, "users.json")},
+	}
+
+	room := stepped(config)
+
+	alice := &session.Session{
+		ID:       "alice-session",
+		Name:     "alice",
+		Messages: make(chan *message.Buffer, 10),
+		Done:     make(chan struct{}),
+	}
+	bob := &session.Session{
+		ID:       "bob-session",
+		Name:     "bob",
+		Messages: make(chan *message.Buffer, 10),
+		Done:     make(chan struct{}),
+	}
+	handle(t, room, Event{Session: alice, Type: Register})
+	handle(t, room, Event{Session: bob, Type: Register})
+
+	say(t, room, bob, "/kick alice")
+	if msg := receive(t, bob); !strings.Contains(msg.String(), "not allowed") {
+		t.Errorf("Expected member kick to be refused, got %s", msg.String())
+	}
+
+	say(t, room, alice, "/kick bob spamming")
+	if msg := receive(t, bob); !strings.Contains(msg.String(), "kicked by alice: spamming") {
+		t.Errorf("Expected kick notice, got %s", msg.String())
+	}
+	if msg := receive(t, bob); msg != nil {
+		t.Errorf("Expected disconnect marker, got %s", msg.String())
+	}
+}
+
+func TestRoom_Resume(t *testing.T) {
+	config := &config.RoomConfig{
+		ByteLimit:   1000,
+		ResumeGrace: time.Minute,
+		ResumeQueue: 10,
+		Auth:        &config.AuthConfig{Mode: config.AuthGuest, UsersFile: filepath.Join(t.TempDir(), "users.json")},
+	}
+
+	room := stepped(config)
+
+	alice := &session.Session{
+		ID:       "alice-session",
+		Name:     "alice",
+		Messages: make(chan *message.Buffer, 10),
+		Done:     make(chan struct{}),
+	}
+	bob := &session.Session{
+		ID:       "bob-session",
+		Name:     "bob",
+		Messages: make(chan *message.Buffer, 10),
+		Done:     make(chan struct{}),
+	}
+	handle(t, room, Event{Session: alice, Type: Register})
+	handle(t, room, Event{Session: bob, Type: Register})
+
+	token := strings.TrimSpace(strings.TrimPrefix(receive(t, alice).String(), "Resume token:"))
+
+	handle(t, room, Event{Session: alice, Type: Unregister})
+	say(t, room, bob, "while you were away")
+
+	resumed := &session.Session{
+		ID:       "alice-session-2",
+		Token:    token,
+		Messages: make(chan *message.Buffer, 10),
+		Done:     make(chan struct{}),
+	}
+	handle(t, room, Event{Session: resumed, Type: Resume, Ready: make(chan struct{})})
+	if resumed.Name != "alice" {
+		t.Fatalf("Expected resumed session to be alice, got '%s'", resumed.Name)
+	}
+	handle(t, room, Event{Session: resumed, Type: Register})
+
+	for _, expected := range []string{"Resume token:", "while you were away"} {
+		if msg := receive(t, resumed); !strings.Contains(msg.String(), expected) {
+			t.Errorf("Expected '%s', got %s", expected, msg.String())
+		}
+	}
+
+	reused := &session.Session{
+		ID:       "alice-session-3",
+		Token:    token,
+		Messages: make(chan *message.Buffer, 10),
+		Done:     make(chan struct{}),
+	}
+	handle(t, room, Event{Session: reused, Type: Resume, Ready: make(chan struct{})})
+	if reused.Name != "" || reused.Token != "" {
+		t.Error("Expected a used token to be rejected")
+	}
+}
+
+func TestRoom_ResumeKeepsDeliveries(t *testing.T) {
+	config := &config.RoomConfig{
+		ByteLimit:   1000,
+		HistorySize: 10,
+		ResumeGrace: time.Minute,
+		ResumeQueue: 10,
+		Auth:        &config.AuthConfig{Mode: config.AuthGuest, UsersFile: filepath.Join(t.TempDir(), "users.json")},
+	}
+
+	room := stepped(config)
+
+	alice := &session.Session{
+		ID:       "alice-session",
+		Name:     "alice",
+		Messages: make(chan *message.Buffer, 10),
+		Done:     make(chan struct{}),
+	}
+	bob := &session.Session{
+		ID:       "bob-session",
+		Name:     "bob",
+		Messages: make(chan *message.Buffer, 10),
+		Done:     make(chan struct{}),
+	}
+	handle(t, room, Event{Session: alice, Type: Register})
+	handle(t, room, Event{Session: bob, Type: Register})
+	token := strings.TrimSpace(strings.TrimPrefix(receive(t, alice).String(), "Resume token:"))
+	receive(t, bob)
+
+	say(t, room, bob, "before you left")
+	before := room.history[0].ID
+	say(t, room, alice, fmt.Sprintf("/ack %d", before))
+
+	handle(t, room, Event{Session: alice, Type: Unregister})
+	say(t, room, bob, "while you were away")
+	away := room.history[1].ID
+
+	resumed := &session.Session{
+		ID:       "alice-session-2",
+		Token:    token,
+		Messages: make(chan *message.Buffer, 10),
+		Done:     make(chan struct{}),
+	}
+	handle(t, room, Event{Session: resumed, Type: Resume, Ready: make(chan struct{})})
+	handle(t, room, Event{Session: resumed, Type: Register})
+	say(t, room, resumed, fmt.Sprintf("/ack %d", away))
+
+	// The ack from before the drop and the one for what was queued meanwhile
+	// both count for the resumed session.
+	for _, id := range []uint64{before, away} {
+		say(t, room, bob, fmt.Sprintf("/delivery %d", id))
+		if reply := receive(t, bob).String(); !strings.Contains(reply, "acked by 1 of 1") {
+			t.Errorf("Expected message %d acked by the resumed session, got %s", id, reply)
+		}
+	}
+}
+
+func TestRoom_ResendStopsAtReplayLimit(t *testing.T) {
+	room := stepped(&config.RoomConfig{Name: "lobby", ByteLimit: 100000, HistorySize: 200})
+
+	alice := &session.Session{
+		ID:       "alice-session",
+		Name:     "alice",
+		Messages: make(chan *message.Buffer, 10),
+		Done:     make(chan struct{}),
+	}
+	bot := &session.Session{
+		ID:       "bot-session",
+		Name:     "bot",
+		Messages: make(chan *message.Buffer, 200),
+		Done:     make(chan struct{}),
+	}
+	handle(t, room, Event{Session: alice, Type: Register})
+	handle(t, room, Event{Session: bot, Type: Register})
+	for i := 0; i < maxReplay+1; i++ {
+		say(t, room, alice, fmt.Sprintf("line %d", i))
+		receive(t, bot)
+	}
+
+	say(t, room, bot, "/resend 1")
+	for i := 0; i < maxReplay; i++ {
+		if msg := receive(t, bot).String(); msg != fmt.Sprintf("line %d\n", i) {
+			t.Fatalf("Expected line %d resent, got %q", i, msg)
+		}
+	}
+	if reply := receive(t, bot).String(); !strings.Contains(reply, "send /resend 101 101 for more") {
+		t.Errorf("Expected the replay to stop at the limit, got %s", reply)
+	}
+}
+
+func TestRoom_SequenceAckAndResend(t *testing.T) {
+	config := &config.RoomConfig{
+		Name:        "lobby",
+		ByteLimit:   1000,
+		HistorySize: 10,
+	}
+
+	room := stepped(config)
+
+	alice := &session.Session{
+		ID:       "alice-session",
+		Name:     "alice",
+		Messages: make(chan *message.Buffer, 10),
+		Done:     make(chan struct{}),
+	}
+	bot := &session.Session{
+		ID:       "bot-session",
+		Name:     "bot",
+		Messages: make(chan *message.Buffer, 10),
+		Done:     make(chan struct{}),
+	}
+	handle(t, room, Event{Session: alice, Type: Register})
+	handle(t, room, Event{Session: bot, Type: Register})
+
+	say(t, room, bot, "/proto json")
+	receive(t, bot)
+
+	say(t, room, alice, "first")
+	say(t, room, alice, "second")
+
+	var events []message.Event
+	for i := 0; i < 2; i++ {
+		var event message.Event
+		if err := json.Unmarshal(receive(t, bot).Bytes(), &event); err != nil {
+			t.Fatalf("Expected JSON event: %v", err)
+		}
+		events = append(events, event)
+	}
+	if events[0].Type != "message" || events[0].From != "alice" || events[0].Body != "first" || events[0].Room != "lobby" {
+		t.Errorf("Unexpected event: %+v", events[0])
+	}
+	if events[1].Seq != events[0].Seq+1 || events[1].ID <= events[0].ID {
+		t.Errorf("Expected increasing seq and id, got %+v and %+v", events[0], events[1])
+	}
+
+	say(t, room, alice, fmt.Sprintf("/delivery %d", events[0].ID))
+	if reply := receive(t, alice).String(); !strings.Contains(reply, "acked by 0 of 1, pending: bot") {
+		t.Errorf("Expected pending report, got %s", reply)
+	}
+
+	say(t, room, bot, fmt.Sprintf("/ack %d", events[0].ID))
+	say(t, room, alice, fmt.Sprintf("/delivery %d", events[0].ID))
+	if reply := receive(t, alice).String(); !strings.Contains(reply, "acked by 1 of 1") {
+		t.Errorf("Expected complete report, got %s", reply)
+	}
+
+	say(t, room, bot, fmt.Sprintf("/resend %d", events[1].Seq))
+	var resent message.Event
+	json.Unmarshal(receive(t, bot).Bytes(), &resent)
+	if resent.Seq != events[1].Seq || resent.Body != "second" {
+		t.Errorf("Expected retransmission of seq %d, got %+v", events[1].Seq, resent)
+	}
+}
+
+func TestRoom_EditDelete(t *testing.T) {
+	config := &config.RoomConfig{
+		Name:        "lobby",
+		ByteLimit:   1000,
+		HistorySize: 10,
+	}
+
+	room := stepped(config)
+
+	alice := &session.Session{
+		ID:       "alice-session",
+		Name:     "alice",
+		Messages: make(chan *message.Buffer, 10),
+		Done:     make(chan struct{}),
+	}
+	bob := &session.Session{
+		ID:       "bob-session",
+		Name:     "bob",
+		Messages: make(chan *message.Buffer, 10),
+		Done:     make(chan struct{}),
+	}
+	handle(t, room, Event{Session: alice, Type: Register})
+	handle(t, room, Event{Session: bob, Type: Register})
+
+	say(t, room, alice, "my pasword is hunter2")
+	receive(t, bob)
+
+	say(t, room, bob, "/history")
+	listing := receive(t, bob).String()
+	var id uint64
+	fmt.Sscanf(listing, "#%d", &id)
+	if id == 0 {
+		t.Fatalf("Expected history listing with an id, got %s", listing)
+	}
+
+	say(t, room, bob, fmt.Sprintf("/delete %d", id))
+	if reply := receive(t, bob).String(); !strings.Contains(reply, "only delete your own") {
+		t.Errorf("Expected non-author delete to be refused, got %s", reply)
+	}
+
+	say(t, room, alice, fmt.Sprintf("/edit %d my password is secret", id))
+	for _, s := range []*session.Session{alice, bob} {
+		if reply := receive(t, s).String(); !strings.Contains(reply, fmt.Sprintf("alice edited message #%d: my password is secret", id)) {
+			t.Errorf("Expected edit notice, got %s", reply)
+		}
+	}
+
+	say(t, room, alice, fmt.Sprintf("/delete %d", id))
+	for _, s := range []*session.Session{alice, bob} {
+		if reply := receive(t, s).String(); !strings.Contains(reply, fmt.Sprintf("message #%d was deleted by alice", id)) {
+			t.Errorf("Expected delete notice, got %s", reply)
+		}
+	}
+
+	say(t, room, bob, "/history")
+	if reply := receive(t, bob).String(); reply != "No history\n" {
+		t.Errorf("Expected empty history after delete, got %s", reply)
+	}
+}
+
+func TestRoom_EditFollowsAccount(t *testing.T) {
+	config := &config.RoomConfig{
+		Name:        "lobby",
+		ByteLimit:   1000,
+		HistorySize: 10,
+		Auth:        &config.AuthConfig{Mode: config.AuthGuest, UsersFile: filepath.Join(t.TempDir(), "users.json")},
+	}
+	room := NewRoom(config)
+	for _, name := range []string{"alice", "bob"} {
+		if err := room.auth.Register(name, "secret"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	room.SetStepping()
+	go room.Open()
+
+	newSession := func(id, name string, guest bool) *session.Session {
+		s := &session.Session{ID: id, Name: name, Guest: guest, Messages: make(chan *message.Buffer, 10), Done: make(chan struct{})}
+		handle(t, room, Event{Session: s, Type: Register})
+		return s
+	}
+
+	// A guest called alice posts before the account alice logs in.
+	impostor := newSession("impostor-session", "alice", true)
+	say(t, room, impostor, "posted as a guest")
+	handle(t, room, Event{Session: impostor, Type: Unregister})
+	bob := newSession("bob-session", "bob", false)
+	say(t, room, bob, "posted from an account")
+	handle(t, room, Event{Session: bob, Type: Unregister})
+
+	alice := newSession("alice-session", "alice", false)
+	say(t, room, alice, fmt.Sprintf("/edit %d mine now", room.history[0].ID))
+	if reply := receive(t, alice).String(); !strings.Contains(reply, "only edit your own") {
+		t.Errorf("Expected the account to be refused a guest's message of the same name, got %s", reply)
+	}
+
+	again := newSession("bob-session-2", "bob", false)
+	say(t, room, again, fmt.Sprintf("/edit %d still mine", room.history[1].ID))
+	if reply := receive(t, again).String(); !strings.Contains(reply, "bob edited message") {
+		t.Errorf("Expected the account to edit its own message from a new session, got %s", reply)
+	}
+}
+
+func TestRoom_DeleteWithdraws(t *testing.T) {
+	dir := t.TempDir()
+	rolesFile := filepath.Join(dir, "roles.json")
+	os.WriteFile(rolesFile, []byte(`{"bob": "moderator"}`), 0o600)
+
+	config := &config.RoomConfig{
+		Name:        "lobby",
+		ByteLimit:   1000,
+		HistorySize: 10,
+		RolesFile:   rolesFile,
+		ResumeGrace: time.Hour,
+		ResumeQueue: 10,
+		Auth:        &config.AuthConfig{Mode: config.AuthRequired, UsersFile: filepath.Join(dir, "users.json")},
+	}
+
+	room := NewRoom(config)
+	room.detached["carol-token"] = &detached{Name: "carol", Expires: time.Now().Add(time.Hour)}
+	room.SetStepping()
+	go room.Open()
+
+	alice := &session.Session{
+		ID:       "alice-session",
+		Name:     "alice",
+		Messages: make(chan *message.Buffer, 10),
+		Done:     make(chan struct{}),
+	}
+	bob := &session.Session{
+		ID:         "bob-session",
+		Name:       "bob",
+		Structured: true,
+		Messages:   make(chan *message.Buffer, 10),
+		Done:       make(chan struct{}),
+	}
+	handle(t, room, Event{Session: alice, Type: Register})
+	handle(t, room, Event{Session: bob, Type: Register})
+
+	// Skip the resume tokens handed out on registering.
+	next := func(s *session.Session) string {
+		t.Helper()
+		for {
+			if msg := receive(t, s).String(); !strings.Contains(msg, "Resume token") {
+				return msg
+			}
+		}
+	}
+
+	say(t, room, alice, "oops @carol")
+	var posted message.Event
+	json.Unmarshal([]byte(next(bob)), &posted)
+	if posted.ID == 0 {
+		t.Fatalf("Expected the broadcast with an id, got %+v", posted)
+	}
+
+	say(t, room, bob, fmt.Sprintf("/delete %d", posted.ID))
+	var deleted message.Event
+	json.Unmarshal([]byte(next(bob)), &deleted)
+	if deleted.Type != "delete" || deleted.ID != posted.ID || deleted.From != "alice" || deleted.By != "bob" {
+		t.Errorf("Expected a deletion of alice's message by bob, got %+v", deleted)
+	}
+	if reply := next(alice); !strings.Contains(reply, fmt.Sprintf("message #%d was deleted by bob", posted.ID)) {
+		t.Errorf("Expected delete notice, got %s", reply)
+	}
+
+	// Only the deletion itself is left for carol to resume to. The room is
+	// parked between steps, so its state can be read.
+	record := room.detached["carol-token"]
+	if len(record.Queue) != 1 || !strings.Contains(string(record.Queue[0].Body), "was deleted by bob") {
+		t.Errorf("Expected the broadcast withdrawn from the resume queue, got %d entries", len(record.Queue))
+	}
+}
+
+func TestRoom_FileTransfer(t *testing.T) {
+	config := &config.RoomConfig{
+		Name:          "lobby",
+		ByteLimit:     1000,
+		TransferLimit: 100,
+		TransferTTL:   time.Minute,
+		ResumeGrace:   time.Minute,
+		ResumeQueue:   10,
+		Auth:          &config.AuthConfig{Mode: config.AuthGuest, UsersFile: filepath.Join(t.TempDir(), "users.json")},
+	}
+
+	room := stepped(config)
+
+	alice := &session.Session{
+		ID:       "alice-session",
+		Name:     "alice",
+		Messages: make(chan *message.Buffer, 20),
+		Done:     make(chan struct{}),
+	}
+	bob := &session.Session{
+		ID:        "bob-session",
+		Name:      "bob",
+		Messages:  make(chan *message.Buffer, 20),
+		Transfers: make(chan []byte, 20),
+		Done:      make(chan struct{}),
+	}
+	handle(t, room, Event{Session: alice, Type: Register})
+	handle(t, room, Event{Session: bob, Type: Register})
+	receive(t, alice)
+	token := strings.TrimSpace(strings.TrimPrefix(receive(t, bob).String(), "Resume token:"))
+
+	transfer := func(s *session.Session) string {
+		t.Helper()
+		select {
+		case chunk := <-s.Transfers:
+			return string(chunk)
+		default:
+			t.Fatal("No chunk received")
+			return ""
+		}
+	}
+
+	data := []byte("hello world")
+	sum := sha256.Sum256(data)
+	checksum := hex.EncodeToString(sum[:])
+
+	say(t, room, alice, "/offer bob big.bin 1000 "+checksum)
+	if reply := receive(t, alice).String(); !strings.Contains(reply, "transfer limit") {
+		t.Errorf("Expected oversized offer to be refused, got %s", reply)
+	}
+
+	say(t, room, alice, fmt.Sprintf("/offer bob notes.txt %d %s", len(data), checksum))
+	if offer := receive(t, bob).String(); !strings.Contains(offer, "alice offers notes.txt (11 bytes) as transfer 1") {
+		t.Errorf("Expected offer, got %s", offer)
+	}
+	receive(t, alice)
+
+	say(t, room, bob, "/accept 1")
+	receive(t, bob)
+	if reply := receive(t, alice).String(); !strings.Contains(reply, "bob accepted transfer 1 from offset 0") {
+		t.Errorf("Expected accept notice, got %s", reply)
+	}
+
+	first := base64.StdEncoding.EncodeToString(data[:5])
+	say(t, room, alice, "/chunk 1 0 "+first)
+	if got := transfer(bob); got != "/chunk 1 0 "+first+"\n" {
+		t.Errorf("Expected relayed chunk, got %q", got)
+	}
+	for _, s := range []*session.Session{alice, bob} {
+		if reply := receive(t, s).String(); !strings.Contains(reply, "Transfer 1: 45% (5/11 bytes)") {
+			t.Errorf("Expected progress, got %s", reply)
+		}
+	}
+
+	// bob drops, comes back with his resume token as a new session and
+	// resumes from the start, so alice resends, this time with the rest of
+	// the file in the same chunk.
+	handle(t, room, Event{Session: bob, Type: Unregister})
+	returning := &session.Session{
+		ID:        "bob-session-2",
+		Token:     token,
+		Messages:  make(chan *message.Buffer, 20),
+		Transfers: make(chan []byte, 20),
+		Done:      make(chan struct{}),
+	}
+	handle(t, room, Event{Session: returning, Type: Resume, Ready: make(chan struct{})})
+	handle(t, room, Event{Session: returning, Type: Register})
+	receive(t, returning)
+
+	say(t, room, returning, "/accept 1 0")
+	if reply := receive(t, returning).String(); !strings.Contains(reply, "Receiving transfer 1") {
+		t.Errorf("Expected the resumed session to accept again, got %s", reply)
+	}
+	if reply := receive(t, alice).String(); !strings.Contains(reply, "bob accepted transfer 1 from offset 0") {
+		t.Errorf("Expected accept notice, got %s", reply)
+	}
+	whole := base64.StdEncoding.EncodeToString(data)
+	say(t, room, alice, "/chunk 1 0 "+whole)
+	if got := transfer(returning); got != "/chunk 1 0 "+whole+"\n" {
+		t.Errorf("Expected the overlapping chunk relayed, got %q", got)
+	}
+	for _, s := range []*session.Session{alice, returning} {
+		if reply := receive(t, s).String(); !strings.Contains(reply, "Transfer 1 complete, checksum ok") {
+			t.Errorf("Expected completion, got %s", reply)
+		}
+	}
+}
+
+func TestRoom_Mentions(t *testing.T) {
+	dir := t.TempDir()
+	rolesFile := filepath.Join(dir, "roles.json")
+	os.WriteFile(rolesFile, []byte(`{"alice": "moderator"}`), 0o600)
+
+	config := &config.RoomConfig{
+		Name:        "lobby",
+		ByteLimit:   1000,
+		HistorySize: 10,
+		RolesFile:   rolesFile,
+		Auth:        &config.AuthConfig{Mode: config.AuthGuest, UsersFile: filepath.Join(dir, "users.json")},
+	}
+
+	room := NewRoom(config)
+	for _, name := range []string{"alice", "bob", "carol"} {
+		if err := room.auth.Register(name, "secret"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	room.SetStepping()
+	go room.Open()
+
+	alice := &session.Session{
+		ID:       "alice-session",
+		Name:     "alice",
+		Messages: make(chan *message.Buffer, 10),
+		Done:     make(chan struct{}),
+	}
+	bob := &session.Session{
+		ID:       "bob-session",
+		Name:     "bob",
+		Messages: make(chan *message.Buffer, 10),
+		Done:     make(chan struct{}),
+	}
+	guest := &session.Session{
+		ID:       "guest-session",
+		Name:     "guest-1",
+		Guest:    true,
+		Messages: make(chan *message.Buffer, 10),
+		Done:     make(chan struct{}),
+	}
+	handle(t, room, Event{Session: alice, Type: Register})
+	handle(t, room, Event{Session: bob, Type: Register})
+	handle(t, room, Event{Session: guest, Type: Register})
+
+	say(t, room, alice, "@bob, can you review?")
+	receive(t, bob)
+	receive(t, guest)
+	if highlight := receive(t, bob).String(); highlight != "\a*** alice mentioned you: @bob, can you review?\n" {
+		t.Errorf("Expected highlight, got %q", highlight)
+	}
+
+	say(t, room, bob, "@room lunch?")
+	if reply := receive(t, bob).String(); !strings.Contains(reply, "not allowed to mention @room") {
+		t.Errorf("Expected member @room to be refused, got %q", reply)
+	}
+
+	say(t, room, alice, "@room standup, @carol too")
+	receive(t, bob)
+	receive(t, guest)
+	if highlight := receive(t, bob).String(); !strings.Contains(highlight, "mentioned you: @room standup") {
+		t.Errorf("Expected room highlight, got %q", highlight)
+	}
+	if highlight := receive(t, guest).String(); !strings.Contains(highlight, "mentioned you: @room standup") {
+		t.Errorf("Expected guest highlight, got %q", highlight)
+	}
+	// The sender is not highlighted.
+	quiet(t, alice)
+
+	say(t, room, bob, "/mentions")
+	first, second := receive(t, bob).String(), receive(t, bob).String()
+	if !strings.Contains(first, "alice: @bob, can you review?") || !strings.Contains(second, "alice: @room standup") {
+		t.Errorf("Expected both mentions listed, got %q and %q", first, second)
+	}
+
+	// Mentions are kept for accounts, including those not connected, but
+	// not for guest names.
+	if got := len(room.mentions["carol"]); got != 1 {
+		t.Errorf("Expected one mention kept for carol, got %d", got)
+	}
+	say(t, room, guest, "/mentions")
+	if reply := receive(t, guest).String(); !strings.Contains(reply, "only kept for accounts") {
+		t.Errorf("Expected guest /mentions to be refused, got %q", reply)
+	}
+	if _, ok := room.mentions[guest.Name]; ok {
+		t.Errorf("Expected no mentions kept for a guest name")
+	}
+}
+
+func TestRoom_TopicAndMOTD(t *testing.T) {
+	dir := t.TempDir()
+	rolesFile := filepath.Join(dir, "roles.json")
+	os.WriteFile(rolesFile, []byte(`{"alice": "moderator"}`), 0o600)
+
+	config := &config.RoomConfig{
+		Name:      "ops",
+		ByteLimit: 1000,
+		StateDir:  dir,
+		RolesFile: rolesFile,
+		Auth:      &config.AuthConfig{Mode: config.AuthRequired, UsersFile: filepath.Join(dir, "users.json")},
+	}
+
+	room := NewRoom(config)
+	room.SetMOTD("Be nice")
+	room.detached["carol-token"] = &detached{Name: "carol", Expires: time.Now().Add(time.Hour)}
+	room.SetStepping()
+	go room.Open()
+
+	alice := &session.Session{
+		ID:       "alice-session",
+		Name:     "alice",
+		Messages: make(chan *message.Buffer, 10),
+		Done:     make(chan struct{}),
+	}
+	handle(t, room, Event{Session: alice, Type: Register})
+	if motd := receive(t, alice).String(); motd != "Be nice\n" {
+		t.Errorf("Expected MOTD, got %q", motd)
+	}
+
+	say(t, room, alice, "/topic incident 42 bridge")
+	if reply := receive(t, alice).String(); !strings.Contains(reply, "*** alice set the topic: incident 42 bridge") {
+		t.Errorf("Expected topic broadcast, got %s", reply)
+	}
+	if queue := room.detached["carol-token"].Queue; len(queue) != 1 || !strings.Contains(string(queue[0].Body), "set the topic") {
+		t.Errorf("Expected the topic change queued for a dropped session, got %d entries", len(queue))
+	}
+
+	// A fresh room with the same state dir keeps the topic and shows it on join.
+	restarted := stepped(config)
+
+	bob := &session.Session{
+		ID:       "bob-session",
+		Name:     "bob",
+		Messages: make(chan *message.Buffer, 10),
+		Done:     make(chan struct{}),
+	}
+	handle(t, restarted, Event{Session: bob, Type: Register})
+	if reply := receive(t, bob).String(); !strings.Contains(reply, "Topic for #ops: incident 42 bridge (set by alice)") {
+		t.Errorf("Expected topic on join, got %s", reply)
+	}
+
+	say(t, restarted, bob, "/topic mine now")
+	if reply := receive(t, bob).String(); !strings.Contains(reply, "not allowed") {
+		t.Errorf("Expected member topic change to be refused, got %s", reply)
+	}
+}
+
+func TestHub_RoomModes(t *testing.T) {
+	dir := t.TempDir()
+	config := &config.RoomConfig{
+		Name:      "lobby",
+		ByteLimit: 1000,
+		StateDir:  dir,
+		Auth:      &config.AuthConfig{Mode: config.AuthRequired, UsersFile: filepath.Join(dir, "users.json"), AllowRegister: true},
+	}
+	hub := steppedHub(config)
+
+	connect := func(name string) *client {
+		c := dial(t, hub, name)
+		c.login("REGISTER " + name + " secret")
+		c.expect("Welcome " + name)
+		return c
+	}
+	alice := connect("alice")
+	bob := connect("bob")
+	carol := connect("carol")
+
+	alice.route("/join #ops")
+	alice.expect("Joined #ops")
+	alice.send("/mode invite")
+	alice.expect("#ops is now invite")
+
+	bob.route("/join ops")
+	bob.expect("#ops is invite-only")
+	bob.route("/rooms")
+	bob.expect("#lobby (public, 2 members)")
+	bob.route("/rooms") // the listing must not include #ops
+	if line := bob.next(); !strings.Contains(line, "#lobby") {
+		t.Errorf("Expected only #lobby to be listed, got %s", line)
+	}
+
+	bob.send("/invite carol")
+	bob.expect("not allowed to invite")
+	alice.send("/invite bob")
+	alice.expect("Invited bob to #ops")
+	bob.route("/join ops")
+	bob.expect("Joined #ops")
+	alice.send("hello ops")
+	bob.expect("hello ops")
+
+	alice.send("/mode password hunter2")
+	alice.expect("#ops is now password")
+	alice.send("/limit 2")
+	alice.expect("Member limit of #ops set to 2")
+	carol.route("/join ops wrong")
+	carol.expect("Wrong password for #ops")
+	carol.route("/join ops hunter2")
+	carol.expect("#ops is full")
+
+	bob.route("/leave")
+	bob.expect("Joined #lobby")
+	carol.route("/join ops hunter2")
+	carol.expect("Joined #ops")
+}
+
+func TestHub_RoleChangesByteLimit(t *testing.T) {
+	dir := t.TempDir()
+	hub := steppedHub(&config.RoomConfig{
+		Name:      "lobby",
+		ByteLimit: 300,
+		StateDir:  dir,
+		Auth:      &config.AuthConfig{Mode: config.AuthRequired, UsersFile: filepath.Join(dir, "users.json"), AllowRegister: true},
+	})
+
+	alice := dial(t, hub, "alice")
+	alice.login("REGISTER alice secret")
+	alice.expect("Welcome alice")
+	alice.route("/join ops")
+	alice.expect("Joined #ops")
+	bob := dial(t, hub, "bob")
+	bob.login("REGISTER bob secret")
+	bob.expect("Welcome bob")
+	bob.route("/join ops")
+	bob.expect("Joined #ops")
+
+	// A moderator may go past the limit.
+	alice.send("/role bob moderator")
+	bob.expect("Your role is now moderator")
+	for i := 0; i < 5; i++ {
+		bob.send(strings.Repeat("x", 80))
+	}
+	bob.send("/topic")
+	bob.expect("No topic")
+
+	// Once demoted, the limit applies again to what was sent so far, before
+	// the message reaches the room.
+	alice.send("/role bob member")
+	bob.expect("Your role is now member")
+	bob.write("one more")
+	bob.expect("Upload limit reached")
+}
+
+func TestHub_RoomLifecycle(t *testing.T) {
+	dir := t.TempDir()
+	config := &config.RoomConfig{
+		Name:      "lobby",
+		ByteLimit: 1000,
+		MaxRooms:  2,
+		Auth:      &config.AuthConfig{Mode: config.AuthGuest, UsersFile: filepath.Join(dir, "users.json"), AllowRegister: true},
+	}
+	hub := steppedHub(config)
+
+	alice := dial(t, hub, "alice")
+	alice.login("REGISTER alice secret")
+	alice.expect("Welcome alice")
+	bob := dial(t, hub, "bob")
+	bob.login("REGISTER bob secret")
+	bob.expect("Welcome bob")
+	guest := dial(t, hub, "guest")
+	guest.login("GUEST visitor")
+	guest.expect("Welcome visitor")
+
+	guest.route("/join scratch")
+	guest.expect("Guests cannot create rooms")
+
+	alice.route("/join ops")
+	alice.expect("Joined #ops")
+	guest.route("/join ops")
+	guest.expect("Joined #ops")
+	bob.route("/join dev")
+	bob.expect("Too many rooms are open")
+
+	reply := make(chan lookupResult)
+	hub.lookups <- lookup{Name: "ops", Reply: reply}
+	ops := (<-reply).Room
+
+	guest.route("/leave")
+	guest.expect("Joined #lobby")
+	alice.route("/leave")
+	alice.expect("Joined #lobby")
+	// #ops stops right after the step that emptied it.
+	select {
+	case <-ops.done:
+	case <-time.After(time.Second):
+		t.Fatal("#ops did not close once it was empty")
+	}
+
+	// That makes room for #dev.
+	bob.route("/join dev")
+	bob.expect("Joined #dev")
+	bob.route("/rooms")
+	bob.expect("#dev")
+	bob.expect("#lobby")
+}
+
+func TestHub_Resume(t *testing.T) {
+	dir := t.TempDir()
+	hub := steppedHub(&config.RoomConfig{
+		Name:        "lobby",
+		ByteLimit:   1000,
+		ResumeGrace: time.Minute,
+		ResumeQueue: 10,
+		Auth:        &config.AuthConfig{Mode: config.AuthGuest, UsersFile: filepath.Join(dir, "users.json"), AllowRegister: true},
+	})
+
+	alice := dial(t, hub, "alice")
+	alice.login("REGISTER alice secret")
+	token := strings.TrimPrefix(alice.expect("Resume token: "), "Resume token: ")
+	alice.route("/join ops")
+	alice.expect("Joined #ops")
+	bob := dial(t, hub, "bob")
+	bob.login("REGISTER bob secret")
+	bob.route("/join ops")
+	bob.expect("Joined #ops")
+
+	alice.close()
+	if hub.lobby.tokenRoom(token) == nil {
+		t.Fatal("alice was not kept for resuming")
+	}
+
+	// The name stays taken while alice may come back.
+	visitor := dial(t, hub, "visitor")
+	visitor.write("GUEST alice")
+	visitor.expect("Name belongs to a registered user")
+	bob.send("/msg alice psst")
+	bob.expect("alice is reconnecting, message queued")
+	bob.send("while you were away")
+
+	// The resumed session logs in through the lobby and goes back to #ops.
+	resumed := dial(t, hub, "returning")
+	resumed.login("RESUME " + token)
+	resumed.until(func(ack Ack) bool { return ack.Event == Leave && ack.Room == "lobby" })
+	resumed.expect("Welcome alice")
+	resumed.expect("Joined #ops")
+	resumed.expect("bob (private): psst")
+	resumed.expect("while you were away")
+	resumed.send("back again")
+	bob.expect("back again")
+}
+
+func TestHub_ResumeKeepsGuestName(t *testing.T) {
+	hub := steppedHub(&config.RoomConfig{
+		Name:        "lobby",
+		ByteLimit:   1000,
+		ResumeGrace: time.Minute,
+		Auth:        &config.AuthConfig{Mode: config.AuthGuest, UsersFile: filepath.Join(t.TempDir(), "users.json")},
+	})
+
+	first := dial(t, hub, "first")
+	first.login("GUEST visitor")
+	token := strings.TrimPrefix(first.expect("Resume token: "), "Resume token: ")
+	first.close()
+	if hub.lobby.tokenRoom(token) == nil {
+		t.Fatal("visitor was not kept for resuming")
+	}
+
+	second := dial(t, hub, "second")
+	second.login("GUEST visitor")
+	second.expect("Name in use, you are now visitor-2")
+
+	resumed := dial(t, hub, "third")
+	resumed.login("RESUME " + token)
+	resumed.expect("Welcome visitor")
+	second.send("/msg visitor hello")
+	resumed.expect("visitor-2 (private): hello")
+}
+
+// steppedHub opens a hub whose rooms handle one event per step, stepped
+// through the lobby.
+func steppedHub(roomConfig *config.RoomConfig) *Hub {
+	hub := NewHub(stepped(roomConfig))
+	go hub.Open()
+	return hub
+}
+
+// client is a connection to a stepping hub as a user sees it. Each method
+// that sends steps the rooms until what was sent is handled.
+type client struct {
+	t     *testing.T
+	hub   *Hub
+	name  string
+	id    string
+	conn  net.Conn
+	lines chan string
+}
+
+// dial connects to hub as name, without logging in.
+func dial(t *testing.T, hub *Hub, name string) *client {
+	serverConn, clientConn := net.Pipe()
+	go hub.NewSession(transport.Conn(namedConn{Conn: serverConn, name: name}))
+	c := &client{t: t, hub: hub, name: name, conn: clientConn, lines: make(chan string, 100)}
+	go func() {
+		scanner := bufio.NewScanner(clientConn)
+		for scanner.Scan() {
+			c.lines <- scanner.Text()
+		}
+	}()
+	t.Cleanup(func() { clientConn.Close() })
+	return c
+}
+
+// until steps the rooms until match accepts an Ack about the client.
+func (c *client) until(match func(Ack) bool) Ack {
+	c.t.Helper()
+	for {
+		ack := step(c.t, c.hub.lobby)
+		if ack.SessionID == c.id && match(ack) {
+			return ack
+		}
+	}
+}
+
+// write sends line without stepping, for lines no room sees.
+func (c *client) write(line string) {
+	c.conn.Write([]byte(line + "\n"))
+}
+
+// login sends line, unless it is empty, and steps until the lobby has
+// registered the client.
+func (c *client) login(line string) {
+	c.t.Helper()
+	if line != "" {
+		c.write(line)
+	}
+	for {
+		ack := step(c.t, c.hub.lobby)
+		if ack.Event == Register && strings.HasPrefix(ack.SessionID, c.name+"-") {
+			c.id = ack.SessionID
+			return
+		}
+	}
+}
+
+// send sends line to the client's room and steps until it is handled, and
+// broadcast when it was published.
+func (c *client) send(line string) {
+	c.t.Helper()
+	c.write(line)
+	ack := c.until(func(ack Ack) bool { return ack.Kind == AckMessage })
+	if ack.Published {
+		c.until(func(ack Ack) bool { return ack.Kind == AckBroadcast })
+	}
+}
+
+// route sends /join, /leave or /rooms, which the hub handles, and steps
+// until the client has its answer: it left its room, was told why it
+// cannot, was turned away or got the listing.
+func (c *client) route(line string) {
+	c.t.Helper()
+	c.write(line)
+	c.until(func(ack Ack) bool {
+		return ack.Kind == AckEvent && (ack.Event == Leave || ack.Event == Notify || ack.Event == List || ack.Event == Join && ack.Refused)
+	})
+}
+
+// close disconnects the client and steps until its room let it go.
+func (c *client) close() {
+	c.t.Helper()
+	c.conn.Close()
+	c.until(func(ack Ack) bool { return ack.Event == Unregister })
+}
+
+// next returns the next line written to the client.
+func (c *client) next() string {
+	c.t.Helper()
+	select {
+	case line := <-c.lines:
+		return line
+	case <-time.After(time.Second):
+		c.t.Fatal("No line received")
+		return ""
+	}
+}
+
+// expect skips lines until one contains want, and returns it.
+func (c *client) expect(want string) string {
+	c.t.Helper()
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case line := <-c.lines:
+			if strings.Contains(line, want) {
+				return line
+			}
+		case <-timeout:
+			c.t.Fatalf("Expected %q", want)
+		}
+	}
+}
+
+func TestHub_Names(t *testing.T) {
+	hub := steppedHub(&config.RoomConfig{Name: "lobby", ByteLimit: 1000})
+
+	alice := dial(t, hub, "alice")
+	alice.login("")
+	alice.send("/nick alice")
+	alice.expect("You are now alice")
+	alice.route("/join ops")
+	alice.expect("Joined #ops")
+
+	// Names are unique across rooms, and private messages find their
+	// target in any room.
+	bob := dial(t, hub, "bob")
+	bob.login("")
+	bob.send("/nick alice")
+	bob.expect("Name already in use")
+	bob.send("/nick bob")
+	bob.expect("You are now bob")
+	bob.send("/msg alice hi from the lobby")
+	alice.until(func(ack Ack) bool { return ack.Event == Direct })
+	alice.expect("bob (private): hi from the lobby")
+
+	alice.send("/msg bob hi back")
+	bob.until(func(ack Ack) bool { return ack.Event == Direct })
+	bob.expect("alice (private): hi back")
+	alice.send("/msg carol anyone?")
+	alice.expect("No such user: carol")
+}
+
+func TestHub_DuplicateLogin(t *testing.T) {
+	dir := t.TempDir()
+	hub := steppedHub(&config.RoomConfig{
+		Name:      "lobby",
+		ByteLimit: 1000,
+		Auth:      &config.AuthConfig{Mode: config.AuthRequired, UsersFile: filepath.Join(dir, "users.json"), AllowRegister: true},
+	})
+
+	alice := dial(t, hub, "alice")
+	alice.login("REGISTER alice secret")
+	alice.expect("Welcome alice")
+
+	again := dial(t, hub, "again")
+	again.write("LOGIN alice secret")
+	again.expect("Already logged in")
+}
+
+func TestRoom_DropsSlowSession(t *testing.T) {
+	for _, shards := range []int{0, 1} {
+		t.Run(fmt.Sprintf("shards=%d", shards), func(t *testing.T) {
+			room := NewRoom(&config.RoomConfig{Name: "lobby", ByteLimit: 1000, BroadcastShards: shards})
+			go room.Open()
+
+			server, client := transport.Pipe("slow")
+			slow := &session.Session{
+				ID:        "slow-session",
+				Transport: server,
+				Messages:  make(chan *message.Buffer, 1),
+				Done:      make(chan struct{}),
+			}
+			fast := &session.Session{
+				ID:       "fast-session",
+				Messages: make(chan *message.Buffer, 10),
+				Done:     make(chan struct{}),
+			}
+			room.events <- Event{Session: slow, Type: Register}
+			room.events <- Event{Session: fast, Type: Register}
+
+			// Nobody writes to the slow session, so the second broadcast
+			// finds its queue full. The fast one still gets everything.
+			for _, body := range []string{"one\n", "two\n", "three\n"} {
+				room.messages <- message.Message{SessionID: "sender", Body: []byte(body)}
+			}
+			for _, want := range []string{"one\n", "two\n", "three\n"} {
+				select {
+				case msg := <-fast.Messages:
+					if msg.String() != want {
+						t.Errorf("Expected %q, got %q", want, msg.String())
+					}
+				case <-time.After(time.Second):
+					t.Fatal("Fast session did not receive every broadcast")
+				}
+			}
+			if _, err := client.ReadFrame(); err == nil {
+				t.Error("Expected the slow session's connection to be closed")
+			}
+		})
+	}
+}
+
+func TestRoom_ChunkDropsSlowRecipient(t *testing.T) {
+	room := stepped(&config.RoomConfig{Name: "lobby", ByteLimit: 1000, TransferLimit: 100, TransferTTL: time.Minute})
+
+	alice := &session.Session{
+		ID:       "alice-session",
+		Name:     "alice",
+		Messages: make(chan *message.Buffer, 20),
+		Done:     make(chan struct{}),
+	}
+	stopped := false
+	bob := &session.Session{
+		ID:        "bob-session",
+		Name:      "bob",
+		Messages:  make(chan *message.Buffer, 20),
+		Transfers: make(chan []byte, 1),
+		Done:      make(chan struct{}),
+		Stop:      func() { stopped = true },
+	}
+	handle(t, room, Event{Session: alice, Type: Register})
+	handle(t, room, Event{Session: bob, Type: Register})
+
+	data := []byte("0123456789")
+	sum := sha256.Sum256(data)
+	say(t, room, alice, fmt.Sprintf("/offer bob notes.txt %d %s", len(data), hex.EncodeToString(sum[:])))
+	receive(t, alice)
+	receive(t, bob)
+	say(t, room, bob, "/accept 1 0")
+	receive(t, bob)
+	receive(t, alice)
+
+	// bob never reads, so the second chunk finds his transfer queue full
+	// and the room drops him instead of waiting.
+	say(t, room, alice, "/chunk 1 0 "+base64.StdEncoding.EncodeToString(data[:5]))
+	if stopped {
+		t.Fatal("Expected bob to take the first chunk")
+	}
+	say(t, room, alice, "/chunk 1 5 "+base64.StdEncoding.EncodeToString(data[5:]))
+	if !stopped {
+		t.Error("Expected bob to be dropped with his transfer queue full")
+	}
+}
+
+// namedConn gives pipe connections distinct remote addresses, which session
+// IDs are derived from.
+type namedConn struct {
+	net.Conn
+	name string
+}
+
+func (conn namedConn) RemoteAddr() net.Addr {
+	return &net.UnixAddr{Name: conn.name, Net: "pipe"}
+}
+
+// benchmarkRoom opens a room with members sessions whose writers just count
+// what they receive. Callers keep them less than a session queue behind, as
+// the room drops sessions that fall further back.
+func benchmarkRoom(b *testing.B, members int, shards int, structured bool, stepping bool) (*Room, *atomic.Int64) {
+	b.Helper()
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	room := NewRoom(&config.RoomConfig{Name: "lobby", ByteLimit: 1 << 30, BroadcastShards: shards})
+	if stepping {
+		room.SetStepping()
+	}
+	go room.Open()
+
+	received := &atomic.Int64{}
+	for i := 0; i < members; i++ {
+		member := &session.Session{
+			ID:         fmt.Sprintf("member-%d", i),
+			Messages:   make(chan *message.Buffer, sessionQueue),
+			Done:       make(chan struct{}),
+			Structured: structured,
+		}
+		go func() {
+			for buffer := range member.Messages {
+				buffer.Release()
+				received.Add(1)
+			}
+		}()
+		if stepping {
+			post(room, Event{Session: member, Type: Register})
+			room.Step(time.Second)
+		} else {
+			room.join(member)
+		}
+	}
+	return room, received
+}
+
+// catchUp waits for the members to receive every message up to the i-th
+// once per half a session queue.
+func catchUp(received *atomic.Int64, i int, members int) {
+	if (i+1)%(sessionQueue/2) != 0 {
+		return
+	}
+	for received.Load() < int64((i+1)*members) {
+		runtime.Gosched()
+	}
+}
+
+func BenchmarkRoom_FanOut(b *testing.B) {
+	for _, members := range []int{100, 1000} {
+		for _, shards := range []int{0, 8} {
+			for _, structured := range []bool{false, true} {
+				name := fmt.Sprintf("members=%d/shards=%d/structured=%t", members, shards, structured)
+				b.Run(name, func(b *testing.B) {
+					room, received := benchmarkRoom(b, members, shards, structured, false)
+					body := []byte("benchmark message\n")
+
+					b.ReportAllocs()
+					b.ResetTimer()
+					for i := 0; i < b.N; i++ {
+						room.messages <- message.Message{SessionID: "sender", Body: body}
+						catchUp(received, i, members)
+					}
+					for received.Load() < int64(b.N*members) {
+						runtime.Gosched()
+					}
+				})
+			}
+		}
+	}
+}
+
+// BenchmarkRoom_BroadcastStep measures how long the room loop is busy with
+// one broadcast, which is time it cannot register or move sessions.
+func BenchmarkRoom_BroadcastStep(b *testing.B) {
+	for _, shards := range []int{0, 8} {
+		b.Run(fmt.Sprintf("members=2000/shards=%d", shards), func(b *testing.B) {
+			room, received := benchmarkRoom(b, 2000, shards, false, true)
+			body := []byte("benchmark message\n")
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				go func() { room.messages <- message.Message{SessionID: "sender", Body: body} }()
+				for {
+					ack, ok := room.Step(time.Minute)
+					if !ok {
+						b.Fatal("Room handled no event")
+					}
+					if ack.Kind == AckBroadcast {
+						break
+					}
+				}
+				b.StopTimer()
+				catchUp(received, i, 2000)
+				b.StartTimer()
+			}
+			b.StopTimer()
+			for received.Load() < int64(b.N*2000) {
+				if _, ok := room.Step(10 * time.Millisecond); !ok {
+					runtime.Gosched()
+				}
+			}
+		})
+	}
+}
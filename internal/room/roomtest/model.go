@@ -0,0 +1,42 @@
+// Package roomtest runs a room one event at a time on a fake clock, with
+// scripted clients on in-memory transports, so room tests neither sleep nor
+// race the room goroutine.
+package roomtest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Arun445/tcp-go/internal/clock"
+	"github.com/Arun445/tcp-go/internal/room"
+	"github.com/Arun445/tcp-go/internal/transport"
+)
+
+// Harness owns a room in stepping mode. The room only moves when the harness
+// steps it, and time only moves with Advance.
+type Harness struct {
+	Room  *room.Room
+	Clock *clock.Fake
+
+	t testing.TB
+	// elapsed is how far the clock has moved since Start.
+	elapsed time.Duration
+}
+
+// Option configures the room before it is opened.
+type Option func(*Harness)
+
+// Client is a fake client. Lines the room writes to it are collected as they
+// arrive and checked with the Expect methods.
+type Client struct {
+	Name string
+	// ID is the session ID the room gave the client.
+	ID string
+
+	harness *Harness
+	conn    transport.Transport
+	lines   chan string
+}
+
+// Action is one step of a client script.
+type Action func(*Client)
@@ -0,0 +1,113 @@
+package roomtest
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Arun445/tcp-go/internal/config"
+	"github.com/Arun445/tcp-go/internal/filter"
+)
+
+func TestHarness_Broadcast(t *testing.T) {
+	harness := New(t, &config.RoomConfig{Name: "lobby", ByteLimit: 1000})
+	alice := harness.Connect("alice")
+	bob := harness.Connect("bob")
+
+	alice.Run(
+		Send("/nick alice"),
+		Expect("You are now alice"),
+		Send("hello"),
+		ExpectNothing(),
+	)
+	bob.Expect("hello")
+
+	bob.Close()
+	alice.Send("anyone?")
+	alice.ExpectNothing()
+}
+
+func TestHarness_TransferExpiry(t *testing.T) {
+	harness := New(t, &config.RoomConfig{
+		Name:          "lobby",
+		ByteLimit:     1000,
+		TransferLimit: 100,
+		TransferTTL:   time.Minute,
+	})
+	alice := harness.Connect("alice")
+	bob := harness.Connect("bob")
+	alice.Run(Send("/nick alice"), Expect("You are now alice"))
+	bob.Run(Send("/nick bob"), Expect("You are now bob"))
+
+	alice.Send("/offer bob notes.txt 11 b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9")
+	alice.Expect("Transfer 1 offered to bob")
+	bob.ExpectContains("notes.txt")
+
+	alice.Run(
+		Advance(59*time.Second),
+		Send("/transfers"),
+		ExpectContains("notes.txt"),
+		Advance(2*time.Second),
+		Send("/transfers"),
+		Expect("No transfers"),
+	)
+}
+
+func TestHarness_FloodMute(t *testing.T) {
+	pipeline, err := filter.New(filter.Config{
+		Rules:     []filter.Rule{{Type: filter.FloodRule, Action: filter.Reject, Count: 3, Window: "10s"}},
+		MuteAfter: 1,
+		MuteFor:   "1m",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create filter: %v", err)
+	}
+	harness := New(t, &config.RoomConfig{Name: "lobby", ByteLimit: 1000}, WithFilter(pipeline))
+	alice := harness.Connect("alice")
+	bob := harness.Connect("bob")
+
+	alice.Run(
+		Send("one"),
+		Send("two"),
+		Send("three"),
+		Expect("Message blocked by the flood filter, you are muted for 1m0s"),
+		Advance(30*time.Second),
+	)
+
+	// Reconnecting from the same address does not lift the mute.
+	alice.Close()
+	alice = harness.Connect("alice")
+	alice.Run(
+		Send("four"),
+		Expect("You are muted for 30s"),
+		Advance(31*time.Second),
+		Send("five"),
+		ExpectNothing(),
+	)
+	for _, want := range []string{"one", "two", "five"} {
+		bob.Expect(want)
+	}
+	bob.ExpectNothing()
+}
+
+func TestHarness_ShardedBroadcast(t *testing.T) {
+	harness := New(t, &config.RoomConfig{Name: "lobby", ByteLimit: 10000, BroadcastShards: 3})
+	alice := harness.Connect("alice")
+	bob := harness.Connect("bob")
+	carol := harness.Connect("carol")
+
+	for i := 0; i < 20; i++ {
+		alice.Send(fmt.Sprintf("message %d", i))
+	}
+	for _, client := range []*Client{bob, carol} {
+		for i := 0; i < 20; i++ {
+			client.Expect(fmt.Sprintf("message %d", i))
+		}
+	}
+	alice.ExpectNothing()
+
+	bob.Run(Send("/proto json"), ExpectContains("Protocol set to json"))
+	carol.Close()
+	alice.Send("structured")
+	bob.ExpectContains(`"body":"structured"`)
+}
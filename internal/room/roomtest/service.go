@@ -0,0 +1,242 @@
+package roomtest
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Arun445/tcp-go/internal/clock"
+	"github.com/Arun445/tcp-go/internal/config"
+	"github.com/Arun445/tcp-go/internal/filter"
+	"github.com/Arun445/tcp-go/internal/room"
+	"github.com/Arun445/tcp-go/internal/transport"
+)
+
+// Timeout bounds every wait, so a missing event fails the test instead of
+// hanging it.
+const Timeout = time.Second
+
+// quiet is how long ExpectNothing waits for output that should not come.
+const quiet = 20 * time.Millisecond
+
+// Start is where the fake clock of every harness begins.
+var Start = time.Date(2024, time.January, 1, 9, 0, 0, 0, time.UTC)
+
+// New opens a room for roomConfig in stepping mode on a fake clock.
+func New(t testing.TB, roomConfig *config.RoomConfig, options ...Option) *Harness {
+	harness := &Harness{
+		Room:  room.NewRoom(roomConfig),
+		Clock: clock.NewFake(Start),
+		t:     t,
+	}
+	harness.Room.SetClock(harness.Clock)
+	harness.Room.SetStepping()
+	for _, option := range options {
+		option(harness)
+	}
+
+	go harness.Room.Open()
+	harness.Clock.BlockUntil(1)
+	return harness
+}
+
+// WithFilter opens pipeline and runs messages through it, on the harness
+// clock.
+func WithFilter(pipeline *filter.Pipeline) Option {
+	return func(harness *Harness) {
+		pipeline.SetClock(harness.Clock)
+		go pipeline.Open()
+		harness.Room.SetFilter(pipeline)
+	}
+}
+
+// Step lets the room handle its next event.
+func (harness *Harness) Step() room.Ack {
+	harness.t.Helper()
+	ack, ok := harness.Room.Step(Timeout)
+	if !ok {
+		harness.t.Fatal("Room handled no event")
+	}
+	return ack
+}
+
+// StepUntil steps until match accepts an Ack and returns it.
+func (harness *Harness) StepUntil(match func(room.Ack) bool) room.Ack {
+	harness.t.Helper()
+	for {
+		if ack := harness.Step(); match(ack) {
+			return ack
+		}
+	}
+}
+
+// Advance moves the clock forward by d. When that crosses a sweep tick it
+// steps until the room has swept, so expiries are done when it returns.
+func (harness *Harness) Advance(d time.Duration) {
+	harness.t.Helper()
+	before := harness.elapsed / room.SweepInterval
+	harness.elapsed += d
+	harness.Clock.Advance(d)
+	if harness.elapsed/room.SweepInterval > before {
+		harness.StepUntil(func(ack room.Ack) bool { return ack.Kind == room.AckSweep })
+	}
+}
+
+// Connect opens a client named name and steps until the room has registered
+// it. Rooms with a login handshake are not supported.
+func (harness *Harness) Connect(name string) *Client {
+	harness.t.Helper()
+	server, conn := transport.Pipe(name)
+	client := &Client{Name: name, harness: harness, conn: conn, lines: make(chan string, 1000)}
+	go client.read()
+	go harness.Room.Serve(server)
+
+	ack := harness.StepUntil(func(ack room.Ack) bool {
+		return ack.Kind == room.AckEvent && ack.Event == room.Register && strings.HasPrefix(ack.SessionID, name+"-")
+	})
+	client.ID = ack.SessionID
+	return client
+}
+
+// read collects complete lines written to the client.
+func (client *Client) read() {
+	var pending []byte
+	for {
+		frame, err := client.conn.ReadFrame()
+		if err != nil {
+			close(client.lines)
+			return
+		}
+		pending = append(pending, frame...)
+		for {
+			end := bytes.IndexByte(pending, '\n')
+			if end < 0 {
+				break
+			}
+			client.lines <- string(pending[:end])
+			pending = pending[end+1:]
+		}
+	}
+}
+
+// Run performs actions in order.
+func (client *Client) Run(actions ...Action) {
+	client.harness.t.Helper()
+	for _, action := range actions {
+		action(client)
+	}
+}
+
+// Write sends line without stepping the room, for input that never reaches
+// it, such as shadowed messages.
+func (client *Client) Write(line string) {
+	client.harness.t.Helper()
+	if err := client.conn.WriteFrame([]byte(line + "\n")); err != nil {
+		client.harness.t.Fatalf("%s failed to write: %v", client.Name, err)
+	}
+}
+
+// Send writes line and steps until the room has handled it, including its
+// broadcast when it was published.
+func (client *Client) Send(line string) {
+	client.harness.t.Helper()
+	client.Write(line)
+	ack := client.harness.StepUntil(func(ack room.Ack) bool {
+		return ack.Kind == room.AckMessage && ack.SessionID == client.ID
+	})
+	if ack.Published {
+		client.harness.StepUntil(func(ack room.Ack) bool {
+			return ack.Kind == room.AckBroadcast && ack.SessionID == client.ID
+		})
+	}
+}
+
+// Close disconnects the client and steps until the room has let it go.
+func (client *Client) Close() {
+	client.harness.t.Helper()
+	client.conn.Close()
+	client.harness.StepUntil(func(ack room.Ack) bool {
+		return ack.Kind == room.AckEvent && ack.Event == room.Unregister && ack.SessionID == client.ID
+	})
+}
+
+// Next returns the next line written to the client.
+func (client *Client) Next() string {
+	client.harness.t.Helper()
+	select {
+	case line, ok := <-client.lines:
+		if !ok {
+			client.harness.t.Fatalf("%s was disconnected", client.Name)
+		}
+		return line
+	case <-time.After(Timeout):
+		client.harness.t.Fatalf("%s received nothing", client.Name)
+		return ""
+	}
+}
+
+// Expect checks that the next line is want.
+func (client *Client) Expect(want string) {
+	client.harness.t.Helper()
+	if line := client.Next(); line != want {
+		client.harness.t.Errorf("%s expected %q, got %q", client.Name, want, line)
+	}
+}
+
+// ExpectContains checks that the next line contains part.
+func (client *Client) ExpectContains(part string) {
+	client.harness.t.Helper()
+	if line := client.Next(); !strings.Contains(line, part) {
+		client.harness.t.Errorf("%s expected a line containing %q, got %q", client.Name, part, line)
+	}
+}
+
+// ExpectNothing checks that no line is waiting for the client.
+func (client *Client) ExpectNothing() {
+	client.harness.t.Helper()
+	select {
+	case line, ok := <-client.lines:
+		if ok {
+			client.harness.t.Errorf("%s expected nothing, got %q", client.Name, line)
+		}
+	case <-time.After(quiet):
+	}
+}
+
+// Send, Expect, ExpectContains, ExpectNothing and Advance are the script
+// forms of the methods of the same name.
+func Send(line string) Action {
+	return func(client *Client) {
+		client.harness.t.Helper()
+		client.Send(line)
+	}
+}
+
+func Expect(want string) Action {
+	return func(client *Client) {
+		client.harness.t.Helper()
+		client.Expect(want)
+	}
+}
+
+func ExpectContains(part string) Action {
+	return func(client *Client) {
+		client.harness.t.Helper()
+		client.ExpectContains(part)
+	}
+}
+
+func ExpectNothing() Action {
+	return func(client *Client) {
+		client.harness.t.Helper()
+		client.ExpectNothing()
+	}
+}
+
+func Advance(d time.Duration) Action {
+	return func(client *Client) {
+		client.harness.t.Helper()
+		client.harness.Advance(d)
+	}
+}
@@ -6,62 +6,663 @@ import (
 	"net"
 	"time"
 
+	"github.com/Arun445/tcp-go/internal/acl"
+	"github.com/Arun445/tcp-go/internal/audit"
+	"github.com/Arun445/tcp-go/internal/auth"
+	"github.com/Arun445/tcp-go/internal/broker"
+	"github.com/Arun445/tcp-go/internal/clock"
+	"github.com/Arun445/tcp-go/internal/cluster"
 	"github.com/Arun445/tcp-go/internal/config"
+	"github.com/Arun445/tcp-go/internal/filter"
+	"github.com/Arun445/tcp-go/internal/mailbox"
 	"github.com/Arun445/tcp-go/internal/message"
+	"github.com/Arun445/tcp-go/internal/role"
 	"github.com/Arun445/tcp-go/internal/session"
+	"github.com/Arun445/tcp-go/internal/transport"
 )
 
+// SweepInterval is how often rooms expire detached sessions and idle
+// transfers.
+const SweepInterval = time.Second
+
+// sessionQueue is how many messages may wait for a session's writer. The
+// room drops a session whose queue is full rather than wait for it.
+const sessionQueue = 256
+
+// transferQueue is how many relayed chunks may wait for a session's writer,
+// with the same policy.
+const transferQueue = 16
+
 func NewRoom(roomConfig *config.RoomConfig) *Room {
+	room := newRoom(roomConfig)
+	room.loadState()
+	room.directory = newDirectory()
+	go room.directory.Open()
+
+	memory := broker.NewMemory()
+	go memory.Open()
+	room.broker = memory
+
+	if roomConfig.RolesFile != "" {
+		roles, err := role.Load(roomConfig.RolesFile)
+		if err != nil {
+			log.Printf("Roles disabled: %v", err)
+		} else {
+			room.roles = roles
+		}
+	}
+
+	if roomConfig.MailboxDir != "" {
+		store, err := mailbox.NewStore(roomConfig.MailboxDir, roomConfig.MailboxSize, roomConfig.MailboxMaxAge)
+		if err != nil {
+			log.Printf("Mailbox disabled: %v", err)
+		} else {
+			room.mailbox = store
+			go store.Open()
+		}
+	}
+
+	if roomConfig.Auth != nil {
+		service, err := auth.NewService(roomConfig.Auth)
+		if err != nil {
+			log.Fatalf("Failed to load users from %s: %v", roomConfig.Auth.UsersFile, err)
+		}
+		room.auth = service
+		go service.Open()
+	}
+
+	return room
+}
+
+func newRoom(roomConfig *config.RoomConfig) *Room {
 	return &Room{
 		config:   roomConfig,
 		events:   make(chan Event),
 		messages: make(chan message.Message),
+		remote:   make(chan message.Message),
+		done:     make(chan struct{}),
 		sessions: make(map[string]*session.Session),
+		names:    make(map[string]*session.Session),
+		roles:    make(map[string]role.Role),
+
+		detached:      make(map[string]*detached),
+		detachedNames: make(map[string]string),
+		resuming:      make(map[string]string),
+		unsent:        make(map[string][]queued),
+		trackers:      make(map[uint64]*tracker),
+		transfers:     make(map[uint64]*transfer),
+		mentions:      make(map[string][]mention),
+
+		clock: clock.Real(),
 	}
 }
 
+// SetACL enables runtime management of the access list through /acl.
+// It must be called before Open.
+func (room *Room) SetACL(list *acl.List) {
+	room.acl = list
+}
+
+// SetFilter runs every session's messages through pipeline before they reach
+// the room. It must be called before Open, and pipeline must be open.
+func (room *Room) SetFilter(pipeline *filter.Pipeline) {
+	room.filter = pipeline
+}
+
+// SetAudit records security-relevant events of this room and the rooms it
+// spawns to auditLog. It must be called before Open.
+func (room *Room) SetAudit(auditLog *audit.Log) {
+	room.auditLog = auditLog
+}
+
+// SetBroker replaces the in-process broker, which is stopped. It must be
+// called once, before Open.
+func (room *Room) SetBroker(b broker.Broker) {
+	room.broker.Close()
+	room.broker = b
+}
+
+// SetClock replaces the system clock, so tests can move time by hand. It
+// must be called before Open.
+func (room *Room) SetClock(c clock.Clock) {
+	room.clock = c
+}
+
+// SetCluster shares the room with rooms of the same name on other nodes.
+// It must be called before Open.
+func (room *Room) SetCluster(node *cluster.Node) {
+	room.cluster = node
+	node.Join(room.config.Name, room.remote, room.done)
+}
+
 func (room *Room) NewSession(conn net.Conn) {
+	room.Serve(transport.Conn(conn))
+}
+
+// Serve runs a session on t until it disconnects.
+func (room *Room) Serve(t transport.Transport) {
+	session, _ := room.connect(t)
+	if session == nil {
+		return
+	}
+	room.serve(session, room.messages)
+	room.events <- Event{Session: session, Type: Unregister}
+}
+
+// connect authenticates a client and registers the resulting session, or
+// returns nil when the handshake fails. A resumed session also gets the
+// room it dropped from.
+func (room *Room) connect(t transport.Transport) (*session.Session, *Room) {
 	// uuid preferred
-	sessionID := fmt.Sprintf("%s-%d", conn.RemoteAddr(), time.Now().Unix())
-	session := &session.Session{
-		ID:       sessionID,
-		Conn:     conn,
-		Messages: make(chan []byte),
-		Done:     make(chan struct{}),
+	session := newSession(fmt.Sprintf("%s-%d", t.RemoteAddr(), room.clock.Now().Unix()), t)
+	var resumed *Room
+	if room.auth != nil {
+		var ok bool
+		if resumed, ok = room.handshake(session); !ok {
+			t.Close()
+			return nil, nil
+		}
+	} else if _, ok := t.(transport.Compressor); ok {
+		t.WriteFrame([]byte("Send COMPRESS deflate first to enable compression\n"))
 	}
 
-	room.events <- Event{Session: session, Type: Register}
+	if !room.join(session) {
+		t.Close()
+		return nil, nil
+	}
+	return session, resumed
+}
 
-	go session.HandleWrite(room.config.ByteLimit)
-	session.HandleRead(room.messages, room.config.ByteLimit)
+func newSession(id string, t transport.Transport) *session.Session {
+	return &session.Session{
+		ID:        id,
+		Transport: t,
+		Messages:  make(chan *message.Buffer, sessionQueue),
+		Transfers: make(chan []byte, transferQueue),
+		Done:      make(chan struct{}),
+	}
+}
 
+// join registers a session and waits until the room is ready for its
+// writer, reporting false if the room closed first.
+func (room *Room) join(session *session.Session) bool {
+	ready := make(chan struct{})
+	select {
+	case room.events <- Event{Session: session, Type: Register, Ready: ready}:
+		<-ready
+		return true
+	case <-room.done:
+		return false
+	}
+}
+
+// serve runs the session until its connection ends, passing what it reads
+// to messages.
+func (room *Room) serve(session *session.Session, messages chan<- message.Message) {
+	room.read(session, messages, room.start(session))
+}
+
+// start applies the room's limits to the session and starts its writer,
+// returning the byte limit for its reader.
+func (room *Room) start(session *session.Session) int {
+	limit := room.config.ByteLimit
+	session.TransferLimit = room.config.TransferLimit
+	session.BatchBytes = room.config.WriteBatchBytes
+	session.FlushDelay = room.config.WriteFlushDelay
+	session.Clock = room.clock
+	session.WireLimits = room.config.LimitCompressed
+	session.Audit = room.auditLog
+
+	go session.HandleWrite(limit)
+	return limit
+}
+
+// read passes what the session sends to messages until its connection ends.
+func (room *Room) read(session *session.Session, messages chan<- message.Message, limit int) {
+	if room.auth == nil {
+		room.offerCompression(session)
+	}
+	room.filtered(session, messages, func(read chan<- message.Message) {
+		session.HandleRead(read, limit)
+	})
 	close(session.Done)
+}
 
-	room.events <- Event{Session: session, Type: Unregister}
+// filtered runs produce, which sends what a session posts, with the filter
+// in between unless the session is exempt.
+func (room *Room) filtered(session *session.Session, messages chan<- message.Message, produce func(chan<- message.Message)) {
+	if room.filter == nil || session.Unlimited {
+		produce(messages)
+		return
+	}
+	read := make(chan message.Message)
+	filtered := make(chan struct{})
+	go func() {
+		room.filter.Run(room.filterKey(session), read, messages)
+		close(filtered)
+	}()
+	produce(read)
+	close(read)
+	<-filtered
+}
+
+// filterKey is who filter violations and mutes count against: the account
+// the session logged in to, or else its address, so reconnecting does not
+// lift a mute.
+func (room *Room) filterKey(session *session.Session) string {
+	if room.verified(session) {
+		return "account " + session.Name
+	}
+	if session.Transport == nil {
+		return "session " + session.ID
+	}
+	return "address " + source(session)
 }
 
 func (room *Room) Open() {
-	for {
-		select {
-		case event := <-room.events:
-			if event.Type == Register {
-				room.sessions[event.Session.ID] = event.Session
-				log.Printf("Session registered: %s", event.Session.ID)
+	subscription, err := room.broker.Subscribe(room.config.Name)
+	if err != nil {
+		log.Fatalf("Failed to subscribe room %s: %v", room.config.Name, err)
+	}
+
+	sweep := room.clock.NewTicker(SweepInterval)
+	defer sweep.Stop()
+
+	if room.config.BroadcastShards > 0 {
+		room.startShards(room.config.BroadcastShards)
+	}
+
+	for !room.closed {
+		ack := room.step(subscription, sweep.C())
+		if room.turn != nil {
+			room.turn <- ack
+			room.turn = nil
+		}
+	}
+
+	room.broker.Unsubscribe(room.config.Name, subscription)
+	close(room.done)
+	room.stopShards()
+	log.Printf("Room closed: %s", room.config.Name)
+}
+
+// step waits for one event and handles it.
+func (room *Room) step(subscription <-chan message.Message, sweep <-chan time.Time) Ack {
+	select {
+	case event := <-room.events:
+		room.wait()
+		ack := Ack{Kind: AckEvent, Event: event.Type, Room: room.config.Name}
+		if event.Session != nil {
+			ack.SessionID = event.Session.ID
+		}
+		switch event.Type {
+		case Register:
+			room.register(event)
+		case Unregister:
+			room.unregister(event)
+		case Resume:
+			room.resume(event.Session)
+			close(event.Ready)
+		case Join:
+			if event.Checked {
+				room.joining--
 			}
-			if event.Type == Unregister {
-				if _, ok := room.sessions[event.Session.ID]; ok {
-					delete(room.sessions, event.Session.ID)
-					close(event.Session.Messages)
-					log.Printf("Session unregistered: %s", event.Session.ID)
-				}
+			if !room.checkPassword(event) {
+				admitted := room.admit(event)
+				event.Joined <- admitted
+				ack.Refused = !admitted
 			}
+		case Leave:
+			room.depart(event.Session, event.Ready)
+		case Identify:
+			event.Identity <- identityOf(event.Session)
+		case Describe:
+			event.Info <- room.describe(event.Viewer)
+		case Direct:
+			room.directed(event)
+		case List:
+			room.listRooms(event.Session, event.Rooms)
+		case Notify:
+			event.Session.Notice(event.Text)
+		case Shutdown:
+			room.shutdown()
+		}
+		room.closeIfEmpty()
+		return ack
+
+	case m := <-room.messages:
+		room.wait()
+		room.published = false
+		room.receive(m)
+		return Ack{Kind: AckMessage, Room: room.config.Name, SessionID: m.SessionID, Published: room.published}
+
+	case m := <-subscription:
+		room.wait()
+		room.fanOut(m)
+		return Ack{Kind: AckBroadcast, Room: room.config.Name, SessionID: m.SessionID}
+
+	case m := <-room.remote:
+		room.wait()
+		room.fanOut(m)
+		return Ack{Kind: AckBroadcast, Room: room.config.Name, SessionID: m.SessionID}
+
+	case report := <-room.deliveries:
+		room.wait()
+		room.delivered(report)
+		return Ack{Kind: AckDelivery, Room: room.config.Name}
+
+	case now := <-sweep:
+		room.wait()
+		room.expireDetached(now)
+		room.expireTransfers(now)
+		room.closeIfEmpty()
+		return Ack{Kind: AckSweep, Room: room.config.Name}
+	}
+}
+
+// shutdown disconnects everyone in the room and forgets the sessions that
+// could resume here, so the room closes once the last one has left.
+func (room *Room) shutdown() {
+	room.reap = true
+	room.stopping = true
+	for token := range room.detached {
+		room.forget(token)
+		room.forgetToken(token)
+	}
+	for _, session := range room.sessions {
+		session.Disconnect("Server is shutting down")
+	}
+}
+
+// closeIfEmpty closes a spawned room once nobody is in it, joining it or
+// may resume in it.
+func (room *Room) closeIfEmpty() {
+	if room.reap && len(room.sessions) == 0 && room.joining == 0 && len(room.detached) == 0 {
+		room.closed = true
+	}
+}
 
-		case m := <-room.messages:
-			for _, session := range room.sessions {
-				if m.SessionID != session.ID {
-					session.Messages <- m.Body
-				}
+// receive handles a message read from a session.
+func (room *Room) receive(m message.Message) {
+	sender, ok := room.sessions[m.SessionID]
+	if m.Filtered != "" {
+		log.Printf("Message from %s filtered: %s", m.SessionID, m.Filtered)
+		if ok {
+			sender.Notice(m.Filtered)
+		}
+		return
+	}
+	if ok && isCommand(m.Body) {
+		room.handleCommand(sender, m.Body)
+		return
+	}
+	if ok && !sender.Role.Can(role.Post) {
+		sender.Notice("You are not allowed to post here")
+		return
+	}
+	if ok && !sender.Role.Can(role.MentionRoom) && mentionsEveryone(m.Body) {
+		sender.Notice("You are not allowed to mention @" + everyone)
+		return
+	}
+	if ok {
+		m.From = sender.DisplayName()
+		if room.auth != nil && !sender.Guest {
+			m.Account = sender.Name
+		}
+	}
+	m.Time = room.clock.Now()
+	if ok && room.hooks.Message != nil {
+		if err := room.hooks.Message(room, sender, &m); err != nil {
+			sender.Notice(err.Error())
+			return
+		}
+	}
+
+	m.ID = message.NextID()
+	room.publish(m)
+	if ok {
+		room.queueMentions(sender, m)
+	}
+}
+
+// publish hands a message to the broker, which brings it back to this room
+// and any other subscriber, and to the cluster peers. A broadcast is
+// numbered here, so every node shows it with the same sequence number.
+func (room *Room) publish(m message.Message) error {
+	if m.Action == "" {
+		room.seq++
+		m.Seq = room.seq
+	}
+	err := room.broker.Publish(room.config.Name, m)
+	if err != nil {
+		log.Printf("Failed to publish message from %s: %v", m.SessionID, err)
+	} else {
+		room.published = true
+	}
+	if room.cluster != nil {
+		room.cluster.Publish(room.config.Name, m)
+	}
+	return err
+}
+
+func (room *Room) register(event Event) {
+	session := event.Session
+	room.enter(session)
+	log.Printf("Session registered: %s", session.ID)
+
+	// The writer only starts once Ready is closed, so nothing may be sent
+	// to the session before this point.
+	if event.Ready != nil {
+		close(event.Ready)
+	}
+
+	room.welcome(session)
+	if room.auth != nil {
+		room.issueToken(session)
+	}
+	if session.Name != "" {
+		room.identify(session)
+	}
+	if room.state.Topic != nil {
+		room.showTopic(session)
+	}
+}
+
+func (room *Room) unregister(event Event) {
+	session := event.Session
+	if !room.remove(session) {
+		return
+	}
+	room.release(session, nil, true)
+	log.Printf("Session unregistered: %s", session.ID)
+	detached := !room.stopping && room.detach(session)
+	if !detached && session.Name != "" {
+		room.releaseName(session, session.Name)
+	}
+}
+
+// enter adds a session to the room and resolves its role here.
+func (room *Room) enter(session *session.Session) {
+	room.sessions[session.ID] = session
+	if room.cluster != nil && len(room.sessions) == 1 {
+		room.cluster.SetMembers(room.config.Name, true)
+	}
+	room.resolveRole(session)
+	if session.Name != "" {
+		room.directory.do(nameRequest{Type: enterRoom, Session: session, Name: session.Name, Room: room})
+	}
+	if room.shards != nil {
+		room.shardFor(session) <- shardOp{Type: shardAdd, Session: session, Structured: session.Structured}
+	}
+	if room.hooks.Register != nil {
+		room.hooks.Register(room, session)
+	}
+	if room.stopping {
+		session.Disconnect("Server is shutting down")
+	}
+}
+
+// remove takes a session out of the room, reporting whether it was in it.
+func (room *Room) remove(session *session.Session) bool {
+	if _, ok := room.sessions[session.ID]; !ok {
+		return false
+	}
+
+	delete(room.sessions, session.ID)
+	if room.names[session.Name] == session {
+		delete(room.names, session.Name)
+	}
+	if room.cluster != nil && len(room.sessions) == 0 {
+		room.cluster.SetMembers(room.config.Name, false)
+	}
+	if room.hooks.Unregister != nil {
+		room.hooks.Unregister(room, session)
+	}
+	return true
+}
+
+// fanOut delivers a broadcast to every session except its sender, directly
+// or through the shards, and queues it for detached sessions waiting to
+// resume. Edits and deletions are applied instead. Broadcasts published by
+// other nodes move this room's sequence on past theirs; ones that come
+// without a number get one here.
+func (room *Room) fanOut(m message.Message) {
+	if m.Action != "" {
+		room.amend(m)
+		return
+	}
+	if m.Seq == 0 {
+		room.seq++
+		m.Seq = room.seq
+	}
+	room.seq = max(room.seq, m.Seq)
+	if m.ID == 0 {
+		m.ID = message.NextID()
+	}
+	if m.Time.IsZero() {
+		m.Time = room.clock.Now()
+	}
+	tracked := room.remember(m)
+
+	if room.shards != nil {
+		room.shardMessage(m)
+	} else {
+		room.deliver(m, tracked)
+	}
+	for _, record := range room.detached {
+		record.queue(m.ID, message.Encode(room.config.Name, m, record.Structured), room.config.ResumeQueue)
+	}
+	room.highlight(m)
+}
+
+// deliver writes a broadcast to every session in turn from the room loop,
+// without waiting for any of them. Sessions on the same protocol share one
+// encoded buffer.
+func (room *Room) deliver(m message.Message, tracked *tracker) {
+	var shared [2]*message.Buffer
+	defer func() {
+		for _, buffer := range shared {
+			if buffer != nil {
+				buffer.Release()
 			}
 		}
+	}()
+
+	for _, session := range room.sessions {
+		if m.SessionID == session.ID {
+			continue
+		}
+		i := 0
+		if session.Structured {
+			i = 1
+		}
+		if shared[i] == nil {
+			shared[i] = message.Shared(room.config.Name, m, session.Structured)
+		}
+		buffer := shared[i].Retain()
+		if session.OfferBuffer(buffer) {
+			tracked.Delivered[session.ID] = true
+		} else {
+			lagging(session)
+			room.keepUnsent(session, m.ID, buffer.Copy())
+			buffer.Release()
+		}
+	}
+}
+
+// roleOf resolves the effective role of a session.
+func (room *Room) roleOf(session *session.Session) role.Role {
+	return room.roleFor(identityOf(session))
+}
+
+// roleFor resolves the role of an identity. Without authentication names
+// are not verified, so everyone is a member and nobody can moderate.
+func (room *Room) roleFor(who identity) role.Role {
+	if who.Guest {
+		return role.Guest
 	}
+	if room.auth == nil || who.Name == "" {
+		return role.Member
+	}
+
+	effective := role.Member
+	if r, ok := room.roles[who.Name]; ok {
+		effective = role.Max(effective, r)
+	}
+	if r, ok := room.state.Roles[who.Name]; ok {
+		effective = role.Max(effective, r)
+	}
+	return effective
+}
+
+// resolveRole sets the session's role here, and with it whether it is
+// exempt from byte limits.
+func (room *Room) resolveRole(session *session.Session) {
+	session.Role = room.roleOf(session)
+	session.Exempt.Store(session.Unlimited || session.Role.Can(role.ExceedLimits))
+}
+
+func identityOf(session *session.Session) identity {
+	return identity{ID: session.ID, Name: session.Name, Guest: session.Guest}
+}
+
+// identify binds the session to its name across the hub and flushes any
+// offline mailbox. Only verified identities have a mailbox, so a name
+// picked with /nick or as a guest never becomes known.
+func (room *Room) identify(session *session.Session) {
+	name, ok := room.claim(session, session.Name, session.Guest)
+	if !ok {
+		session.Notice(fmt.Sprintf("Name %s is already in use", session.Name))
+		session.Name = ""
+		return
+	}
+	if name != session.Name {
+		session.Name = name
+		session.Notice(fmt.Sprintf("Name in use, you are now %s", session.Name))
+	}
+	room.names[session.Name] = session
+	if room.mailbox == nil || room.auth == nil || session.Guest {
+		return
+	}
+
+	room.mailbox.Touch(session.Name)
+	for _, entry := range room.mailbox.Drain(session.Name) {
+		at := entry.Time
+		session.Emit(message.Event{Type: "offline", From: entry.From, Time: &at, Body: entry.Body},
+			fmt.Sprintf("[%s] %s: %s", entry.Time.Format(time.RFC3339), entry.From, entry.Body))
+	}
+}
+
+// enqueueOffline stores a message for a known identity that is not connected.
+// id is the broadcast it came from, zero for a private message.
+func (room *Room) enqueueOffline(id uint64, from string, to string, body string) bool {
+	if _, ok := room.detachedNames[to]; ok {
+		return false
+	}
+	if room.mailbox == nil || !room.mailbox.Known(to) {
+		return false
+	}
+
+	room.mailbox.Enqueue(to, mailbox.Entry{ID: id, From: from, Body: body, Time: room.clock.Now()})
+	return true
 }
@@ -0,0 +1,255 @@
+package room
+
+import (
+	"hash/fnv"
+
+	"github.com/Arun445/tcp-go/internal/message"
+	"github.com/Arun445/tcp-go/internal/session"
+)
+
+type shardOpType int
+
+const (
+	shardAdd shardOpType = iota
+	shardRemove
+	shardProtocol
+	shardDeliver
+	shardSend
+)
+
+// shardOp is an instruction for a shard. A shard handles its ops in order,
+// so every member sees broadcasts in the order the room numbered them.
+type shardOp struct {
+	Type       shardOpType
+	Session    *session.Session
+	Structured bool
+	Broadcast  broadcast
+	Body       []byte
+
+	// Release, when set on a remove, is closed once the shard no longer
+	// writes to the session. Close closes the session's Messages then.
+	Release chan struct{}
+	Close   bool
+}
+
+// broadcast is a room message encoded once for each protocol. Every shard
+// holds a reference to both buffers.
+type broadcast struct {
+	ID         uint64
+	Sender     string
+	Plain      *message.Buffer
+	Structured *message.Buffer
+}
+
+// delivery reports one broadcast back to the room.
+type delivery struct {
+	ID        uint64
+	Delivered []string
+	Failed    []failed
+}
+
+type failed struct {
+	Session *session.Session
+	Body    []byte
+}
+
+// member is a session as a shard knows it. The protocol is copied because
+// the session itself is only changed by the room.
+type member struct {
+	session    *session.Session
+	structured bool
+}
+
+// shardQueue bounds the ops waiting for a shard. Shards never wait for a
+// session, so the room only waits when a shard is this far behind.
+const shardQueue = 1024
+
+// startShards hands fan-out to count shard goroutines. Reports come back
+// through a relay, so a shard never waits for a room that is itself waiting
+// for a full shard queue.
+func (room *Room) startShards(count int) {
+	deliveries := make(chan delivery)
+	room.deliveries = make(chan delivery)
+	go relay(deliveries, room.deliveries, room.done)
+
+	for i := 0; i < count; i++ {
+		ops := make(chan shardOp, shardQueue)
+		go runShard(ops, deliveries, room.done)
+		room.shards = append(room.shards, ops)
+	}
+}
+
+// stopShards lets every shard finish the ops queued for it and stop. Once
+// the room is done their reports are dropped.
+func (room *Room) stopShards() {
+	for _, shard := range room.shards {
+		close(shard)
+	}
+	room.shards = nil
+}
+
+// runShard owns a subset of the room's sessions and writes broadcasts to
+// them until ops is closed. A session whose queue is full is dropped, and
+// what it missed is reported like a write to a closed session.
+func runShard(ops <-chan shardOp, deliveries chan<- delivery, done <-chan struct{}) {
+	members := make(map[string]*member)
+	for op := range ops {
+		switch op.Type {
+		case shardAdd:
+			members[op.Session.ID] = &member{session: op.Session, structured: op.Structured}
+		case shardRemove:
+			delete(members, op.Session.ID)
+			if op.Close {
+				close(op.Session.Messages)
+			}
+			if op.Release != nil {
+				close(op.Release)
+			}
+		case shardProtocol:
+			if m, ok := members[op.Session.ID]; ok {
+				m.structured = op.Structured
+			}
+		case shardDeliver:
+			b := op.Broadcast
+			report := delivery{ID: b.ID}
+			for id, m := range members {
+				if id == b.Sender {
+					continue
+				}
+				buffer := b.Plain
+				if m.structured {
+					buffer = b.Structured
+				}
+				buffer.Retain()
+				if offer(members, m, buffer) {
+					report.Delivered = append(report.Delivered, id)
+				} else {
+					report.Failed = append(report.Failed, failed{Session: m.session, Body: buffer.Copy()})
+					buffer.Release()
+				}
+			}
+			b.Plain.Release()
+			b.Structured.Release()
+			select {
+			case deliveries <- report:
+			case <-done:
+			}
+		case shardSend:
+			if m, ok := members[op.Session.ID]; ok {
+				buffer := message.NewBuffer(op.Body)
+				if !offer(members, m, buffer) {
+					buffer.Release()
+				}
+			}
+		}
+	}
+}
+
+// offer queues buffer for a member without waiting. A member too slow to
+// take it is dropped and forgotten.
+func offer(members map[string]*member, m *member, buffer *message.Buffer) bool {
+	if m.session.OfferBuffer(buffer) {
+		return true
+	}
+	if lagging(m.session) {
+		delete(members, m.session.ID)
+	}
+	return false
+}
+
+// lagging drops a session that could not take what the room offered it
+// although it is not done, as too slow to keep up, and reports whether it
+// did. The room hears of it when the session's reader ends.
+func lagging(session *session.Session) bool {
+	select {
+	case <-session.Done:
+		return false
+	default:
+		session.Drop("too slow to keep up with the room")
+		return true
+	}
+}
+
+func (room *Room) shardFor(session *session.Session) chan<- shardOp {
+	hash := fnv.New32a()
+	hash.Write([]byte(session.ID))
+	return room.shards[hash.Sum32()%uint32(len(room.shards))]
+}
+
+// release stops writing to a session that left the room. Without shards
+// that is immediate; with shards it waits for the deliveries already queued.
+func (room *Room) release(session *session.Session, ready chan struct{}, closeMessages bool) {
+	if room.shards == nil {
+		if closeMessages {
+			close(session.Messages)
+		}
+		if ready != nil {
+			close(ready)
+		}
+		return
+	}
+	room.shardFor(session) <- shardOp{Type: shardRemove, Session: session, Release: ready, Close: closeMessages}
+}
+
+// sendAfterBroadcasts writes body to a session once the broadcasts already
+// queued for it are written, so a notice about a message never overtakes it.
+func (room *Room) sendAfterBroadcasts(session *session.Session, body []byte) {
+	if room.shards == nil {
+		session.Send(body)
+		return
+	}
+	room.shardFor(session) <- shardOp{Type: shardSend, Session: session, Body: body}
+}
+
+// shardMessage queues a broadcast on every shard.
+func (room *Room) shardMessage(m message.Message) {
+	plain := message.Shared(room.config.Name, m, false)
+	structured := message.Shared(room.config.Name, m, true)
+	for _, shard := range room.shards {
+		b := broadcast{ID: m.ID, Sender: m.SessionID, Plain: plain.Retain(), Structured: structured.Retain()}
+		shard <- shardOp{Type: shardDeliver, Broadcast: b}
+	}
+	plain.Release()
+	structured.Release()
+}
+
+// delivered records what a shard reported for a broadcast.
+func (room *Room) delivered(report delivery) {
+	if tracked, ok := room.trackers[report.ID]; ok {
+		for _, id := range report.Delivered {
+			tracked.Delivered[id] = true
+		}
+	}
+	for _, f := range report.Failed {
+		if _, ok := room.sessions[f.Session.ID]; ok {
+			room.keepUnsent(f.Session, report.ID, f.Body)
+		} else if record, ok := room.detached[f.Session.Token]; ok {
+			record.queue(report.ID, f.Body, room.config.ResumeQueue)
+		}
+	}
+}
+
+// relay forwards in to out through an unbounded queue, so a shard never
+// waits for the room. It stops once done is closed.
+func relay[T any](in <-chan T, out chan<- T, done <-chan struct{}) {
+	var queue []T
+	for {
+		var send chan<- T
+		var next T
+		if len(queue) > 0 {
+			send = out
+			next = queue[0]
+		}
+
+		select {
+		case item := <-in:
+			queue = append(queue, item)
+		case <-done:
+			return
+		case send <- next:
+			var zero T
+			queue[0] = zero
+			queue = queue[1:]
+		}
+	}
+}
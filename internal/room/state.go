@@ -0,0 +1,92 @@
+package room
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/Arun445/tcp-go/internal/auth"
+	"github.com/Arun445/tcp-go/internal/role"
+)
+
+// state is the part of a room that survives restarts.
+type state struct {
+	Roles map[string]role.Role `json:"roles"`
+	Topic *topic               `json:"topic,omitempty"`
+
+	// Mode is public when empty. Members are names invited with /invite,
+	// who may enter without the password too.
+	Mode     string          `json:"mode,omitempty"`
+	Password *auth.User      `json:"password,omitempty"`
+	Limit    int             `json:"limit,omitempty"`
+	Members  map[string]bool `json:"members,omitempty"`
+}
+
+func (room *Room) statePath() string {
+	return statePath(room.config.StateDir, room.config.Name)
+}
+
+func statePath(dir, name string) string {
+	return filepath.Join(dir, name+".json")
+}
+
+// persisted reports whether a room of the given name has saved state next
+// to this room's.
+func (room *Room) persisted(name string) bool {
+	if room.config.StateDir == "" {
+		return false
+	}
+	_, err := os.Stat(statePath(room.config.StateDir, name))
+	return err == nil
+}
+
+func (room *Room) loadState() {
+	room.state = state{Roles: make(map[string]role.Role), Members: make(map[string]bool)}
+	if room.config.StateDir == "" {
+		return
+	}
+
+	data, err := os.ReadFile(room.statePath())
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to read room state: %v", err)
+		return
+	}
+	if err := json.Unmarshal(data, &room.state); err != nil {
+		log.Printf("Failed to parse room state: %v", err)
+	}
+	if room.state.Roles == nil {
+		room.state.Roles = make(map[string]role.Role)
+	}
+	if room.state.Members == nil {
+		room.state.Members = make(map[string]bool)
+	}
+}
+
+func (room *Room) saveState() {
+	if room.config.StateDir == "" {
+		return
+	}
+
+	data, err := json.MarshalIndent(room.state, "", "  ")
+	if err != nil {
+		log.Printf("Failed to encode room state: %v", err)
+		return
+	}
+	if err := os.MkdirAll(room.config.StateDir, 0o755); err != nil {
+		log.Printf("Failed to create room state dir: %v", err)
+		return
+	}
+	tmp := room.statePath() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		log.Printf("Failed to write room state: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, room.statePath()); err != nil {
+		log.Printf("Failed to write room state: %v", err)
+	}
+}
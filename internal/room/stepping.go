@@ -0,0 +1,39 @@
+package room
+
+import "time"
+
+// SetStepping makes Open take each event and then wait for Step before
+// handling it, so tests can run the room one event at a time. Between steps
+// the room goroutine is parked and its state can be inspected. Rooms a hub
+// spawns from this one step along with it: a Step goes to whichever room
+// has an event waiting. It must be called before Open.
+func (room *Room) SetStepping() {
+	room.steps = make(chan chan Ack)
+}
+
+// Step lets a room handle its next event and reports what it was. It gives
+// up after timeout; the Ack of a step already under way is then dropped.
+func (room *Room) Step(timeout time.Duration) (Ack, bool) {
+	reply := make(chan Ack, 1)
+	expired := time.After(timeout)
+
+	select {
+	case room.steps <- reply:
+	case <-expired:
+		return Ack{}, false
+	}
+	select {
+	case ack := <-reply:
+		return ack, true
+	case <-expired:
+		return Ack{}, false
+	}
+}
+
+// wait holds an event taken in stepping mode until Step lets the room
+// handle it.
+func (room *Room) wait() {
+	if room.steps != nil {
+		room.turn = <-room.steps
+	}
+}
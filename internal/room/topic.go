@@ -0,0 +1,74 @@
+package room
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Arun445/tcp-go/internal/message"
+	"github.com/Arun445/tcp-go/internal/role"
+	"github.com/Arun445/tcp-go/internal/session"
+)
+
+const maxTopic = 300
+
+type topic struct {
+	Text  string    `json:"text"`
+	SetBy string    `json:"set_by"`
+	SetAt time.Time `json:"set_at"`
+}
+
+// SetMOTD sets the message of the day sent to every session after login.
+// It must be called before Open.
+func (room *Room) SetMOTD(text string) {
+	room.motd = text
+}
+
+// welcome greets a newly registered session with the MOTD.
+func (room *Room) welcome(session *session.Session) {
+	if room.motd == "" {
+		return
+	}
+	session.Emit(message.Event{Type: "motd", Text: room.motd}, room.motd)
+}
+
+func (room *Room) showTopic(session *session.Session) {
+	current := room.state.Topic
+	if current == nil {
+		session.Notice(fmt.Sprintf("No topic is set for #%s", room.config.Name))
+		return
+	}
+	at := current.SetAt
+	session.Emit(message.Event{Type: "topic", Room: room.config.Name, From: current.SetBy, Time: &at, Text: current.Text},
+		fmt.Sprintf("Topic for #%s: %s (set by %s)", room.config.Name, current.Text, current.SetBy))
+}
+
+func (room *Room) setTopic(sender *session.Session, text string) {
+	if !sender.Role.Can(role.SetTopic) {
+		sender.Notice("You are not allowed to change the topic")
+		return
+	}
+	if len(text) > maxTopic {
+		sender.Notice(fmt.Sprintf("Topic is longer than %d bytes", maxTopic))
+		return
+	}
+
+	room.publish(message.Message{Action: message.Topic, SessionID: sender.ID, From: sender.DisplayName(),
+		Body: []byte(text), Time: room.clock.Now()})
+}
+
+// changeTopic stores a published topic change and announces it, also to
+// the sessions that may resume here.
+func (room *Room) changeTopic(m message.Message) {
+	current := &topic{Text: string(m.Body), SetBy: m.From, SetAt: m.Time}
+	if current.SetAt.IsZero() {
+		current.SetAt = room.clock.Now()
+	}
+	room.state.Topic = current
+	room.saveState()
+	log.Printf("Topic of %s set by %s", room.config.Name, current.SetBy)
+
+	at := current.SetAt
+	room.broadcastEvent(message.Event{Type: "topic", Room: room.config.Name, From: current.SetBy, Time: &at, Text: current.Text},
+		fmt.Sprintf("*** %s set the topic: %s", current.SetBy, current.Text))
+}
@@ -0,0 +1,286 @@
+package room
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Arun445/tcp-go/internal/message"
+	"github.com/Arun445/tcp-go/internal/session"
+)
+
+// transfer is a file streamed from one sender through the room. The room
+// keeps no file data: chunks are relayed as they arrive and only the running
+// checksum is kept, so resuming means sending the missing chunks again. The
+// sender and recipients are sessions, so a later holder of a name cannot
+// take a transfer over, but a session that resumes with its token keeps its
+// transfers.
+type transfer struct {
+	ID         uint64
+	Sender     string
+	From       string
+	Target     string
+	File       string
+	Size       int64
+	Checksum   string
+	Received   int64
+	Recipients map[string]*recipient
+	LastActive time.Time
+
+	hash     hash.Hash
+	reported int
+}
+
+type recipient struct {
+	Accepted bool
+	Offset   int64
+}
+
+func (room *Room) offer(sender *session.Session, args []string) {
+	if len(args) != 4 {
+		sender.Notice("Usage: /offer <name|#room> <file> <size> <sha256>")
+		return
+	}
+	size, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil || size <= 0 {
+		sender.Notice("Invalid size")
+		return
+	}
+	if room.config.TransferLimit > 0 && size > int64(room.config.TransferLimit) {
+		sender.Notice(fmt.Sprintf("File exceeds the transfer limit of %d bytes", room.config.TransferLimit))
+		return
+	}
+	checksum := strings.ToLower(args[3])
+	if decoded, err := hex.DecodeString(checksum); err != nil || len(decoded) != sha256.Size {
+		sender.Notice("Checksum must be a hex encoded SHA-256")
+		return
+	}
+
+	var recipients []*session.Session
+	if args[0] == "#"+room.config.Name {
+		for _, member := range room.sessions {
+			if member != sender {
+				recipients = append(recipients, member)
+			}
+		}
+	} else if target := room.lookup(args[0]); target != nil && target != sender {
+		recipients = append(recipients, target)
+	}
+	if len(recipients) == 0 {
+		sender.Notice(fmt.Sprintf("No recipients for %s", args[0]))
+		return
+	}
+
+	room.transferSeq++
+	t := &transfer{
+		ID:         room.transferSeq,
+		Sender:     sender.ID,
+		From:       sender.DisplayName(),
+		Target:     args[0],
+		File:       args[1],
+		Size:       size,
+		Checksum:   checksum,
+		Recipients: make(map[string]*recipient),
+		LastActive: room.clock.Now(),
+		hash:       sha256.New(),
+	}
+	room.transfers[t.ID] = t
+
+	for _, member := range recipients {
+		t.Recipients[member.ID] = &recipient{}
+		member.Emit(message.Event{Type: "offer", ID: t.ID, From: t.From, File: t.File, Size: t.Size, Checksum: t.Checksum},
+			fmt.Sprintf("%s offers %s (%d bytes) as transfer %d, /accept %d to receive it", t.From, t.File, t.Size, t.ID, t.ID))
+	}
+	sender.Emit(message.Event{Type: "offered", ID: t.ID, File: t.File, Size: t.Size},
+		fmt.Sprintf("Transfer %d offered to %s", t.ID, t.Target))
+}
+
+// accept starts or resumes receiving a transfer from offset.
+func (room *Room) accept(sender *session.Session, args []string) {
+	if len(args) < 1 || len(args) > 2 {
+		sender.Notice("Usage: /accept <id> [offset]")
+		return
+	}
+	t, ok := room.transferFor(sender, args[0])
+	if !ok {
+		return
+	}
+	r, ok := t.Recipients[sender.ID]
+	if !ok {
+		sender.Notice(fmt.Sprintf("Transfer %d was not offered to you", t.ID))
+		return
+	}
+
+	var offset int64
+	if len(args) == 2 {
+		parsed, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil || parsed < 0 || parsed > t.Size {
+			sender.Notice("Invalid offset")
+			return
+		}
+		offset = parsed
+	}
+	r.Accepted = true
+	r.Offset = offset
+	t.LastActive = room.clock.Now()
+
+	sender.Emit(message.Event{Type: "accepted", ID: t.ID, File: t.File, Size: t.Size, Offset: offset, Checksum: t.Checksum},
+		fmt.Sprintf("Receiving transfer %d (%s) from offset %d", t.ID, t.File, offset))
+	if from, ok := room.sessions[t.Sender]; ok {
+		from.Emit(message.Event{Type: "accepted", ID: t.ID, From: sender.DisplayName(), Offset: offset},
+			fmt.Sprintf("%s accepted transfer %d from offset %d", sender.DisplayName(), t.ID, offset))
+	}
+}
+
+// chunk relays transfer data. New data must not leave a gap after the
+// received offset. Each recipient gets the part from its own offset on, so
+// data resent for one resuming recipient also carries the others forward
+// when it runs past what was received.
+func (room *Room) chunk(sender *session.Session, args []string) {
+	if len(args) != 3 {
+		sender.Notice("Usage: /chunk <id> <offset> <base64>")
+		return
+	}
+	t, ok := room.transferFor(sender, args[0])
+	if !ok {
+		return
+	}
+	if t.Sender != sender.ID {
+		sender.Notice(fmt.Sprintf("Transfer %d is not yours", t.ID))
+		return
+	}
+	offset, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil || offset < 0 {
+		sender.Notice("Invalid offset")
+		return
+	}
+	data, err := base64.StdEncoding.DecodeString(args[2])
+	if err != nil || len(data) == 0 {
+		sender.Notice("Invalid chunk data")
+		return
+	}
+	if offset > t.Received || offset+int64(len(data)) > t.Size {
+		sender.Notice(fmt.Sprintf("Transfer %d expects offset %d", t.ID, t.Received))
+		return
+	}
+	t.LastActive = room.clock.Now()
+
+	end := offset + int64(len(data))
+	for id, r := range t.Recipients {
+		member, ok := room.sessions[id]
+		if !r.Accepted || r.Offset < offset || r.Offset >= end || !ok {
+			continue
+		}
+		part := args[2]
+		if r.Offset > offset {
+			part = base64.StdEncoding.EncodeToString(data[r.Offset-offset:])
+		}
+		encoded := message.EncodeEmit(message.Event{Type: "chunk", ID: t.ID, Offset: r.Offset, Data: part},
+			fmt.Sprintf("/chunk %d %d %s", t.ID, r.Offset, part), member.Structured)
+		if member.OfferTransfer(encoded) {
+			r.Offset = end
+		} else {
+			lagging(member)
+		}
+	}
+
+	if end <= t.Received {
+		return
+	}
+	t.hash.Write(data[t.Received-offset:])
+	t.Received = end
+	room.reportProgress(t)
+}
+
+func (room *Room) reportProgress(t *transfer) {
+	percent := int(t.Received * 100 / t.Size)
+	if percent/10 == t.reported/10 && t.Received < t.Size {
+		return
+	}
+	t.reported = percent
+
+	event := message.Event{Type: "progress", ID: t.ID, Offset: t.Received, Size: t.Size, Percent: percent}
+	fallback := fmt.Sprintf("Transfer %d: %d%% (%d/%d bytes)", t.ID, percent, t.Received, t.Size)
+	if t.Received == t.Size {
+		sum := hex.EncodeToString(t.hash.Sum(nil))
+		event = message.Event{Type: "complete", ID: t.ID, Size: t.Size, Checksum: sum, Complete: sum == t.Checksum}
+		fallback = fmt.Sprintf("Transfer %d complete, checksum ok", t.ID)
+		if sum != t.Checksum {
+			fallback = fmt.Sprintf("Transfer %d complete, checksum mismatch: got %s", t.ID, sum)
+		}
+		log.Printf("Transfer %d from %s complete (%d bytes, checksum ok: %v)", t.ID, t.From, t.Size, sum == t.Checksum)
+	}
+
+	if from, ok := room.sessions[t.Sender]; ok {
+		from.Emit(event, fallback)
+	}
+	for id, r := range t.Recipients {
+		if member, ok := room.sessions[id]; ok && r.Accepted {
+			member.Emit(event, fallback)
+		}
+	}
+}
+
+func (room *Room) listTransfers(sender *session.Session) {
+	var ids []uint64
+	for id, t := range room.transfers {
+		if _, ok := t.Recipients[sender.ID]; ok || t.Sender == sender.ID {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		sender.Notice("No transfers")
+		return
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	for _, id := range ids {
+		t := room.transfers[id]
+		offset := t.Received
+		if r, ok := t.Recipients[sender.ID]; ok {
+			offset = r.Offset
+		}
+		sender.Emit(message.Event{Type: "transfer", ID: t.ID, From: t.From, File: t.File, Size: t.Size, Offset: offset, Percent: int(offset * 100 / t.Size)},
+			fmt.Sprintf("Transfer %d: %s from %s, %d/%d bytes", t.ID, t.File, t.From, offset, t.Size))
+	}
+}
+
+func (room *Room) transferFor(sender *session.Session, idText string) (*transfer, bool) {
+	id, err := strconv.ParseUint(idText, 10, 64)
+	if err != nil {
+		sender.Notice(fmt.Sprintf("Invalid transfer id: %s", idText))
+		return nil, false
+	}
+	t, ok := room.transfers[id]
+	if !ok {
+		sender.Notice(fmt.Sprintf("No such transfer: %d", id))
+	}
+	return t, ok
+}
+
+func (room *Room) expireTransfers(now time.Time) {
+	for id, t := range room.transfers {
+		if now.Sub(t.LastActive) > room.config.TransferTTL {
+			log.Printf("Transfer %d from %s expired", id, t.From)
+			delete(room.transfers, id)
+		}
+	}
+}
+
+// lookup finds a connected session by its display name.
+func (room *Room) lookup(name string) *session.Session {
+	if member, ok := room.names[name]; ok {
+		return member
+	}
+	if member, ok := room.sessions[name]; ok && member.Name == "" {
+		return member
+	}
+	return nil
+}
@@ -1,15 +1,89 @@
 package room
 
-import "github.com/Arun445/tcp-go/internal/session"
+import (
+	"github.com/Arun445/tcp-go/internal/auth"
+	"github.com/Arun445/tcp-go/internal/session"
+)
 
 type SessionEventType int
 
 const (
 	Register SessionEventType = iota
 	Unregister
+	Resume
+	// Join, Leave, Identify, Describe, List and Notify are sent by the hub
+	// as sessions move between rooms.
+	Join
+	Leave
+	Identify
+	Describe
+	List
+	Notify
+	// Direct carries a private message to a session from another room.
+	Direct
+	// Shutdown is sent by the hub when the server stops, without a session.
+	Shutdown
 )
 
 type Event struct {
 	Session *session.Session
 	Type    SessionEventType
+	// Ready, when set, is closed once the room has processed the event.
+	Ready chan struct{}
+
+	// Join carries the password given by the session and whether the room
+	// was just created for it, and receives whether the session got in.
+	// Checked marks a join whose password was hashed off the loop, Verified
+	// is the room password it matched. Resumed brings a resumed session back
+	// to the room it dropped from, whatever its mode.
+	Password string
+	Created  bool
+	Joined   chan bool
+	Checked  bool
+	Verified *auth.User
+	Resumed  bool
+
+	// Identify receives who the session is from the room it is in, which
+	// Describe then carries as the Viewer. Describe receives a summary of
+	// the room on Info, List carries the summaries to show.
+	Identity chan identity
+	Viewer   identity
+	Info     chan roomInfo
+	Rooms    []roomInfo
+
+	// Direct carries the sender, the name the message was sent to and the
+	// text, Notify the text of a notice.
+	From string
+	To   string
+	Text string
+}
+
+type AckKind int
+
+const (
+	// AckEvent is a session event, AckMessage a message read from a session,
+	// AckBroadcast a published message delivered to the room, AckDelivery a
+	// shard's report on a broadcast and AckSweep a tick of the expiry sweep.
+	AckEvent AckKind = iota
+	AckMessage
+	AckBroadcast
+	AckDelivery
+	AckSweep
+)
+
+// Ack reports what the room handled in one step.
+type Ack struct {
+	Kind AckKind
+	// Room is the name of the room that took the step.
+	Room string
+	// Event is the type of an AckEvent.
+	Event     SessionEventType
+	SessionID string
+	// Published is set for an AckMessage that published something, the
+	// message itself or a change a command made, which is followed by its
+	// AckBroadcast.
+	Published bool
+	// Refused is set for a Join the room turned away. A Join waiting on its
+	// password check is neither let in nor refused yet.
+	Refused bool
 }
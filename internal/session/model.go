@@ -1,12 +1,68 @@
 package session
 
-import "net"
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/Arun445/tcp-go/internal/audit"
+	"github.com/Arun445/tcp-go/internal/clock"
+	"github.com/Arun445/tcp-go/internal/message"
+	"github.com/Arun445/tcp-go/internal/role"
+	"github.com/Arun445/tcp-go/internal/transport"
+)
 
 type Session struct {
-	ID              string
-	Conn            net.Conn
-	Messages        chan []byte
+	ID         string
+	Name       string
+	Guest      bool
+	Role       role.Role
+	Token      string
+	Structured bool
+	// Compressed is set once the client negotiated compression.
+	Compressed bool
+	// Unlimited sessions skip byte limits and filters, for exempted bots.
+	Unlimited bool
+	// Exempt is set while the session may exceed byte limits, by its role
+	// in the room it is in or as Unlimited. Rooms change it while the
+	// reader and writer check it.
+	Exempt    atomic.Bool
+	Transport transport.Transport
+	// Stop ends an in-process session, which has no Transport to close.
+	Stop            func()
+	Messages        chan *message.Buffer
+	Transfers       chan []byte
 	Done            chan struct{}
 	DownloadedBytes int
 	UploadedBytes   int
+
+	// The writer gathers queued messages into one write until BatchBytes are
+	// pending or FlushDelay has passed since the first. Zero BatchBytes leaves
+	// the size to maxBatch, zero FlushDelay writes once the queue is empty.
+	BatchBytes int
+	FlushDelay time.Duration
+	// Clock times FlushDelay. Nil uses the system clock.
+	Clock clock.Clock
+
+	// WireLimits counts byte limits on the connection, after compression,
+	// instead of on messages.
+	WireLimits bool
+
+	// Audit records disconnects at a limit. Nil records nothing.
+	Audit *audit.Log
+
+	// File transfer data is accounted separately from chat traffic.
+	TransferLimit      int
+	TransferUploaded   int
+	TransferDownloaded int
+
+	unread []byte
+}
+
+// outbox holds what the writer has gathered for its next write.
+type outbox struct {
+	buffers []*message.Buffer
+	frames  [][]byte
+	size    int
+	// timer runs while a batch waits for FlushDelay.
+	timer clock.Timer
 }
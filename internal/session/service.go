@@ -1,57 +1,474 @@
 package session
 
 import (
+	"bytes"
+	"errors"
 	"log"
+	"strings"
+	"time"
 
+	"github.com/Arun445/tcp-go/internal/audit"
+	"github.com/Arun445/tcp-go/internal/clock"
 	"github.com/Arun445/tcp-go/internal/message"
+	"github.com/Arun445/tcp-go/internal/transport"
 )
 
+const maxChunkLine = 64 * 1024
+
+// arenaSize is the block read messages are copied into.
+const arenaSize = 4096
+
+// maxBatch bounds how many queued messages go out in one write.
+const maxBatch = 64
+
+var (
+	chunkPrefix = []byte("/chunk ")
+	chunkStart  = []byte("\n/chunk ")
+)
+
+// Send queues a message for the writer, giving up once the session is done.
+// A nil body tells the writer to close the connection.
+func (session *Session) Send(body []byte) bool {
+	if body == nil {
+		return session.SendBuffer(nil)
+	}
+	return session.SendBuffer(message.NewBuffer(body))
+}
+
+// SendBuffer queues a buffer, which the writer releases once written. When
+// the session is done the buffer is not taken and the caller keeps it.
+func (session *Session) SendBuffer(buffer *message.Buffer) bool {
+	select {
+	case session.Messages <- buffer:
+		return true
+	case <-session.Done:
+		return false
+	}
+}
+
+// OfferBuffer queues a buffer without waiting, for writers that must not
+// stall behind one slow session. It reports false and leaves the buffer with
+// the caller when the session is done or its queue is full.
+func (session *Session) OfferBuffer(buffer *message.Buffer) bool {
+	select {
+	case <-session.Done:
+		return false
+	default:
+	}
+	select {
+	case session.Messages <- buffer:
+		return true
+	default:
+		return false
+	}
+}
+
+// Drop closes the connection of a session that cannot keep up. Its reader
+// then ends the session as if the client had gone.
+func (session *Session) Drop(reason string) {
+	log.Printf("Dropping session %s: %s", session.ID, reason)
+	if session.Transport == nil {
+		session.Stop()
+		return
+	}
+	session.Transport.Close()
+}
+
+// Deliver sends a room message in the session's protocol.
+func (session *Session) Deliver(room string, m message.Message) bool {
+	return session.Send(message.Encode(room, m, session.Structured))
+}
+
+// Notice sends server text, such as a command reply.
+func (session *Session) Notice(text string) bool {
+	return session.Send(message.EncodeNotice(text, session.Structured))
+}
+
+// Emit sends a typed event to structured sessions and fallback text to the rest.
+func (session *Session) Emit(event message.Event, fallback string) bool {
+	return session.Send(message.EncodeEmit(event, fallback, session.Structured))
+}
+
+// Disconnect queues a final notice and tells the writer to close the connection.
+func (session *Session) Disconnect(reason string) {
+	if session.Notice(reason) {
+		session.Send(nil)
+	}
+}
+
+// DisplayName returns the identity of the session, or its ID before one is set.
+func (session *Session) DisplayName() string {
+	if session.Name != "" {
+		return session.Name
+	}
+	return session.ID
+}
+
 func (session *Session) HandleWrite(limit int) {
-	defer session.Conn.Close()
+	defer session.Transport.Close()
 
+	out := &outbox{
+		buffers: make([]*message.Buffer, 0, maxBatch),
+		frames:  make([][]byte, 0, maxBatch),
+	}
+	if session.FlushDelay > 0 {
+		c := session.Clock
+		if c == nil {
+			c = clock.Real()
+		}
+		out.timer = c.NewTimer(session.FlushDelay)
+		out.timer.Stop()
+		defer out.timer.Stop()
+	}
 	for {
 		select {
 		case <-session.Done:
 			return
-		case message, ok := <-session.Messages:
+		case first, ok := <-session.Messages:
 			if !ok {
 				return
 			}
-
-			session.DownloadedBytes += len(message)
-			if session.DownloadedBytes >= limit {
-				session.Conn.Write([]byte("Download limit reached. Disconnecting...\n"))
+			if !session.writeMessages(first, out, limit) {
 				return
 			}
-			if _, err := session.Conn.Write(message); err != nil {
+		case data := <-session.Transfers:
+			meter := session.meter()
+			if meter == nil {
+				session.TransferDownloaded += len(data)
+				if session.TransferLimit > 0 && session.atLimit(session.TransferDownloaded, session.TransferLimit) {
+					session.disconnectAtLimit("Transfer limit reached")
+					return
+				}
+			}
+			sent, err := session.write(meter, func() error { return session.Transport.WriteFrame(data) })
+			if err != nil {
 				log.Printf("Error writing to session %s: %v", session.ID, err)
 				return
 			}
+			if meter != nil {
+				session.TransferDownloaded += sent
+				if session.TransferLimit > 0 && session.atLimit(session.TransferDownloaded, session.TransferLimit) {
+					session.disconnectAtLimit("Transfer limit reached")
+					return
+				}
+			}
+		}
+	}
+}
+
+// writeMessages writes first together with the messages queued behind it,
+// and those arriving within FlushDelay, reporting whether the session goes
+// on. Each message counts against the download limit on its own, unless
+// the limit counts bytes on the connection, which flush does per write.
+func (session *Session) writeMessages(first *message.Buffer, out *outbox, limit int) bool {
+	defer out.reset()
+	wire := session.meter() != nil
+
+	var flush <-chan time.Time
+	if out.timer != nil {
+		out.timer.Reset(session.FlushDelay)
+		flush = out.timer.C()
+	}
+
+	next, open := first, true
+	for next != nil {
+		if !wire {
+			session.DownloadedBytes += next.Len()
+		}
+		if session.atLimit(session.DownloadedBytes, limit) {
+			next.Release()
+			if session.flush(out, limit) {
+				session.disconnectAtLimit("Download limit reached")
+			}
+			return false
+		}
+		out.buffers = append(out.buffers, next)
+		out.size += next.Len()
+		if len(out.buffers) == maxBatch || (session.BatchBytes > 0 && out.size >= session.BatchBytes) {
+			return session.flush(out, limit)
+		}
+
+		select {
+		case next, open = <-session.Messages:
+		default:
+			if flush == nil {
+				return session.flush(out, limit)
+			}
+			select {
+			case next, open = <-session.Messages:
+			case <-flush:
+				return session.flush(out, limit)
+			case <-session.Done:
+				return false
+			}
+		}
+		if !open {
+			next = nil
 		}
 	}
+
+	// A nil message or a closed channel ends the session once the rest is
+	// written.
+	session.flush(out, limit)
+	return false
 }
 
+// flush writes the gathered messages in one go.
+func (session *Session) flush(out *outbox, limit int) bool {
+	if len(out.buffers) == 0 {
+		return true
+	}
+	meter := session.meter()
+	sent, err := session.write(meter, func() error {
+		if len(out.buffers) == 1 {
+			return session.Transport.WriteFrame(out.buffers[0].Bytes())
+		}
+		for _, buffer := range out.buffers {
+			out.frames = append(out.frames, buffer.Bytes())
+		}
+		return session.Transport.WriteFrames(out.frames)
+	})
+	if err != nil {
+		log.Printf("Error writing to session %s: %v", session.ID, err)
+		return false
+	}
+	if meter != nil {
+		session.DownloadedBytes += sent
+		if session.atLimit(session.DownloadedBytes, limit) {
+			session.disconnectAtLimit("Download limit reached")
+			return false
+		}
+	}
+	return true
+}
+
+// disconnectAtLimit tells the client which limit it reached and records the
+// disconnect. The caller ends the session.
+func (session *Session) disconnectAtLimit(text string) {
+	session.Audit.Record(audit.Record{
+		Action:  audit.LimitDisconnect,
+		Target:  session.DisplayName(),
+		Session: session.ID,
+		Addr:    session.Transport.RemoteAddr().String(),
+		Detail:  strings.ToLower(text),
+	})
+	session.Transport.WriteFrame([]byte(text + ". Disconnecting...\n"))
+}
+
+// meter returns the transport when byte limits count what goes over the
+// connection, and nil when they count messages.
+func (session *Session) meter() transport.Compressor {
+	if !session.WireLimits {
+		return nil
+	}
+	compressor, _ := session.Transport.(transport.Compressor)
+	return compressor
+}
+
+// write runs fn and returns how many bytes it put on the connection, when
+// there is a meter to tell.
+func (session *Session) write(meter transport.Compressor, fn func() error) (int, error) {
+	if meter == nil {
+		return 0, fn()
+	}
+	_, before := meter.Wire()
+	err := fn()
+	_, after := meter.Wire()
+	return after - before, err
+}
+
+// atLimit reports whether used bytes reached limit, which does not apply
+// while the session is exempt.
+func (session *Session) atLimit(used, limit int) bool {
+	return used >= limit && !session.Exempt.Load()
+}
+
+// cost scales n bytes of a frame of size bytes, which took wire bytes on
+// the connection, to what they count against a limit. Every message costs
+// at least a byte, even one decompressed from input read for an earlier
+// frame.
+func cost(n, size, wire int) int {
+	if size == 0 {
+		return n
+	}
+	return max(1, (n*wire+size-1)/size)
+}
+
+// reset releases what was gathered, written or not, and stops the timer.
+func (out *outbox) reset() {
+	for i, buffer := range out.buffers {
+		buffer.Release()
+		out.buffers[i] = nil
+	}
+	clear(out.frames)
+	out.buffers = out.buffers[:0]
+	out.frames = out.frames[:0]
+	out.size = 0
+	if out.timer != nil {
+		out.timer.Stop()
+	}
+}
+
+// OfferTransfer queues file transfer data, which is written outside of the
+// chat byte limit. It does not wait, and reports false when the session is
+// done or its transfer queue is full.
+func (session *Session) OfferTransfer(data []byte) bool {
+	if session.Transfers == nil {
+		return false
+	}
+	select {
+	case <-session.Done:
+		return false
+	default:
+	}
+	select {
+	case session.Transfers <- data:
+		return true
+	default:
+		return false
+	}
+}
+
+// ReadFrame returns input given back with Unread before reading from the
+// transport.
+func (session *Session) ReadFrame() ([]byte, error) {
+	if len(session.unread) > 0 {
+		frame := session.unread
+		session.unread = nil
+		return frame, nil
+	}
+	return session.Transport.ReadFrame()
+}
+
+// Unread gives data back to be returned by the next ReadFrame, for readers
+// such as the login handshake that stop in the middle of a frame.
+func (session *Session) Unread(data []byte) {
+	if len(data) > 0 {
+		session.unread = append([]byte(nil), data...)
+	}
+}
+
+// Compress switches the transport to DEFLATE after the client asked for it,
+// writing confirm as the last uncompressed frame. Input already read past the
+// request is handed to the transport to decompress.
+func (session *Session) Compress(confirm []byte) error {
+	compressor, ok := session.Transport.(transport.Compressor)
+	if !ok {
+		return errors.New("compression is not available")
+	}
+	if session.Compressed {
+		return transport.ErrCompressed
+	}
+	pending := session.unread
+	session.unread = nil
+	if err := compressor.Compress(pending, confirm); err != nil {
+		return err
+	}
+	session.Compressed = true
+	return nil
+}
+
+// HandleRead forwards every frame as one message, except "/chunk" lines of a
+// file transfer. Those are reassembled across reads and counted against the
+// transfer limit instead of the byte limit. When limits count bytes on the
+// connection, each message costs its share of the frame it arrived in.
 func (session *Session) HandleRead(messages chan<- message.Message, limit int) {
-	buffer := make([]byte, 1024)
+	var pending, chunk, arena []byte
+	inChunk := false
 
+	meter := session.meter()
+	var read int
+	if meter != nil {
+		read, _ = meter.Wire()
+	}
 	for {
-		bytesRead, err := session.Conn.Read(buffer)
+		frame, err := session.ReadFrame()
 		if err != nil {
 			log.Printf("Session %s read error: %v", session.ID, err)
 			return
 		}
-		if bytesRead == 0 {
+		if len(frame) == 0 {
 			continue
 		}
+		size, wire := len(frame), len(frame)
+		if meter != nil {
+			total, _ := meter.Wire()
+			wire, read = total-read, total
+		}
 
-		session.UploadedBytes += bytesRead
-		if session.UploadedBytes >= limit {
-			session.Conn.Write([]byte("Upload limit reached. Disconnecting...\n"))
-			return
+		// Lines are kept straight from the read buffer; only a held back
+		// prefix is joined with the frame that follows it.
+		data := frame
+		if pending != nil {
+			data = append(pending, frame...)
+			pending = nil
 		}
+		for len(data) > 0 {
+			if inChunk {
+				end := bytes.IndexByte(data, '\n')
+				if end < 0 {
+					end = len(data) - 1
+				}
+				session.TransferUploaded += cost(end+1, size, wire)
+				if session.TransferLimit > 0 && session.atLimit(session.TransferUploaded, session.TransferLimit) {
+					session.disconnectAtLimit("Transfer limit reached")
+					return
+				}
+				chunk = append(chunk, data[:end+1]...)
+				data = data[end+1:]
+				if len(chunk) > maxChunkLine {
+					session.disconnectAtLimit("Transfer chunk too large")
+					return
+				}
+				if chunk[len(chunk)-1] == '\n' {
+					messages <- message.Message{SessionID: session.ID, Body: chunk}
+					chunk = nil
+					inChunk = false
+				}
+				continue
+			}
 
-		copied := make([]byte, bytesRead)
-		copy(copied, buffer[:bytesRead])
-		messages <- message.Message{SessionID: session.ID, Body: copied}
+			if bytes.HasPrefix(data, chunkPrefix) {
+				inChunk = true
+				continue
+			}
+			if len(data) < len(chunkPrefix) && bytes.HasPrefix(chunkPrefix, data) {
+				pending = append([]byte(nil), data...)
+				break
+			}
+
+			// A line after the text that may begin a chunk line is held back
+			// with the rest of it on the next pass.
+			text := data
+			if next := bytes.Index(data, chunkStart); next >= 0 {
+				text = data[:next+1]
+			} else if last := bytes.LastIndexByte(data, '\n'); last >= 0 && last < len(data)-1 && bytes.HasPrefix(chunkPrefix, data[last+1:]) {
+				text = data[:last+1]
+			}
+			data = data[len(text):]
+
+			session.UploadedBytes += cost(len(text), size, wire)
+			if session.atLimit(session.UploadedBytes, limit) {
+				session.disconnectAtLimit("Upload limit reached")
+				return
+			}
+
+			messages <- message.Message{SessionID: session.ID, Body: keep(&arena, text)}
+		}
+	}
+}
+
+// keep copies text out of the read buffer into arena, which is allocated a
+// block at a time rather than per message. Kept bodies are capped so an
+// append to one cannot run into the next.
+func keep(arena *[]byte, text []byte) []byte {
+	if len(text) > arenaSize/4 {
+		return append([]byte(nil), text...)
+	}
+	if cap(*arena)-len(*arena) < len(text) {
+		*arena = make([]byte, 0, arenaSize)
 	}
+	start := len(*arena)
+	*arena = append(*arena, text...)
+	return (*arena)[start:len(*arena):len(*arena)]
 }
@@ -1,20 +1,27 @@
 package session
 
 import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
 	"net"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/Arun445/tcp-go/internal/clock"
 	"github.com/Arun445/tcp-go/internal/message"
+	"github.com/Arun445/tcp-go/internal/transport"
 )
 
 func TestSession_HandleRead(t *testing.T) {
 	serverConn, clientConn := net.Pipe()
 
 	session := &Session{
-		ID:   "test-session",
-		Conn: serverConn,
+		ID:        "test-session",
+		Transport: transport.Conn(serverConn),
 	}
 
 	messages := make(chan message.Message, 10)
@@ -57,8 +64,8 @@ func TestSession_HandleRead_Error(t *testing.T) {
 	serverConn, clientConn := net.Pipe()
 
 	session := &Session{
-		ID:   "test-session",
-		Conn: serverConn,
+		ID:        "test-session",
+		Transport: transport.Conn(serverConn),
 	}
 
 	messages := make(chan message.Message, 10)
@@ -92,8 +99,8 @@ func TestSession_HandleRead_Multiple(t *testing.T) {
 	defer clientConn.Close()
 
 	session := &Session{
-		ID:   "test-session",
-		Conn: serverConn,
+		ID:        "test-session",
+		Transport: transport.Conn(serverConn),
 	}
 
 	messages := make(chan message.Message, 10)
@@ -142,8 +149,8 @@ func TestSession_HandleRead_UploadLimit(t *testing.T) {
 	defer clientConn.Close()
 
 	session := &Session{
-		ID:   "test-session",
-		Conn: serverConn,
+		ID:        "test-session",
+		Transport: transport.Conn(serverConn),
 	}
 
 	messages := make(chan message.Message, 10)
@@ -180,6 +187,75 @@ func TestSession_HandleRead_UploadLimit(t *testing.T) {
 	}
 }
 
+func TestSession_HandleRead_TransferChunks(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+
+	session := &Session{
+		ID:            "test-session",
+		Transport:     transport.Conn(serverConn),
+		TransferLimit: 10000,
+	}
+
+	messages := make(chan message.Message, 10)
+	go session.HandleRead(messages, 20)
+
+	chunk := "/chunk 1 0 " + strings.Repeat("QUJD", 500) + "\n"
+	clientConn.Write([]byte("hi\n" + chunk[:10]))
+	clientConn.Write([]byte(chunk[10:]))
+
+	expected := []string{"hi\n", chunk}
+	for _, want := range expected {
+		select {
+		case m := <-messages:
+			if string(m.Body) != want {
+				t.Errorf("Expected %.20q, got %.20q", want, m.Body)
+			}
+		case <-time.After(100 * time.Millisecond):
+			t.Fatal("No message received")
+		}
+	}
+
+	if session.UploadedBytes != 3 {
+		t.Errorf("Expected chunks to bypass the byte limit, uploaded %d", session.UploadedBytes)
+	}
+	if session.TransferUploaded != len(chunk) {
+		t.Errorf("Expected %d transfer bytes, got %d", len(chunk), session.TransferUploaded)
+	}
+}
+
+func TestSession_HandleRead_SplitChunkPrefix(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+
+	// A zero transfer limit leaves chunks uncapped.
+	session := &Session{
+		ID:        "test-session",
+		Transport: transport.Conn(serverConn),
+	}
+
+	messages := make(chan message.Message, 10)
+	go session.HandleRead(messages, 20)
+
+	for _, frame := range []string{"hi\n/chu", "nk 1 0 QUJD\n", "/c", "ool\n"} {
+		clientConn.Write([]byte(frame))
+	}
+
+	for _, want := range []string{"hi\n", "/chunk 1 0 QUJD\n", "/cool\n"} {
+		select {
+		case m := <-messages:
+			if string(m.Body) != want {
+				t.Errorf("Expected %q, got %q", want, m.Body)
+			}
+		case <-time.After(100 * time.Millisecond):
+			t.Fatal("No message received")
+		}
+	}
+	if session.TransferUploaded != len("/chunk 1 0 QUJD\n") {
+		t.Errorf("Expected the split chunk counted as transfer bytes, got %d", session.TransferUploaded)
+	}
+}
+
 func TestSession_HandleRead_UploadLimitExactBoundary(t *testing.T) {
 	byteLimit := 10
 	serverConn, clientConn := net.Pipe()
@@ -187,7 +263,7 @@ func TestSession_HandleRead_UploadLimitExactBoundary(t *testing.T) {
 
 	session := &Session{
 		ID:            "test-session",
-		Conn:          serverConn,
+		Transport:     transport.Conn(serverConn),
 		UploadedBytes: 5,
 	}
 
@@ -231,14 +307,14 @@ func TestSession_HandleWrite(t *testing.T) {
 	defer clientConn.Close()
 
 	session := &Session{
-		ID:       "test-session",
-		Conn:     serverConn,
-		Messages: make(chan []byte, 10),
-		Done:     make(chan struct{}),
+		ID:        "test-session",
+		Transport: transport.Conn(serverConn),
+		Messages:  make(chan *message.Buffer, 10),
+		Done:      make(chan struct{}),
 	}
 
 	testMessage := []byte("Im alive!")
-	session.Messages <- testMessage
+	session.Messages <- message.NewBuffer(testMessage)
 
 	done := make(chan struct{})
 	go func() {
@@ -272,16 +348,16 @@ func TestSession_HandleWrite_Error(t *testing.T) {
 	serverConn, clientConn := net.Pipe()
 
 	session := &Session{
-		ID:       "test-session",
-		Conn:     serverConn,
-		Messages: make(chan []byte, 10),
-		Done:     make(chan struct{}),
+		ID:        "test-session",
+		Transport: transport.Conn(serverConn),
+		Messages:  make(chan *message.Buffer, 10),
+		Done:      make(chan struct{}),
 	}
 
 	clientConn.Close()
 
 	testMessage := []byte("test")
-	session.Messages <- testMessage
+	session.Messages <- message.NewBuffer(testMessage)
 
 	done := make(chan struct{})
 	go func() {
@@ -302,14 +378,14 @@ func TestSession_HandleWrite_DownloadLimit(t *testing.T) {
 	defer clientConn.Close()
 
 	session := &Session{
-		ID:       "test-session",
-		Conn:     serverConn,
-		Messages: make(chan []byte, 10),
-		Done:     make(chan struct{}),
+		ID:        "test-session",
+		Transport: transport.Conn(serverConn),
+		Messages:  make(chan *message.Buffer, 10),
+		Done:      make(chan struct{}),
 	}
 
 	testMessage := []byte("This message is longer than 10 bytes")
-	session.Messages <- testMessage
+	session.Messages <- message.NewBuffer(testMessage)
 
 	limit := 10
 	done := make(chan struct{})
@@ -345,14 +421,14 @@ func TestSession_HandleWrite_DownloadLimitExactBoundary(t *testing.T) {
 
 	session := &Session{
 		ID:              "test-session",
-		Conn:            serverConn,
-		Messages:        make(chan []byte, 10),
+		Transport:       transport.Conn(serverConn),
+		Messages:        make(chan *message.Buffer, 10),
 		Done:            make(chan struct{}),
 		DownloadedBytes: 5,
 	}
 
 	testMessage := []byte("5byte")
-	session.Messages <- testMessage
+	session.Messages <- message.NewBuffer(testMessage)
 
 	done := make(chan struct{})
 	go func() {
@@ -385,10 +461,10 @@ func TestSession_HandleWrite_ChannelClosed(t *testing.T) {
 	defer clientConn.Close()
 
 	session := &Session{
-		ID:       "test-session",
-		Conn:     serverConn,
-		Messages: make(chan []byte, 10),
-		Done:     make(chan struct{}),
+		ID:        "test-session",
+		Transport: transport.Conn(serverConn),
+		Messages:  make(chan *message.Buffer, 10),
+		Done:      make(chan struct{}),
 	}
 
 	close(session.Messages)
@@ -412,10 +488,10 @@ func TestSession_HandleWrite_DoneChannelClosed(t *testing.T) {
 	defer clientConn.Close()
 
 	session := &Session{
-		ID:       "test-session",
-		Conn:     serverConn,
-		Messages: make(chan []byte, 10),
-		Done:     make(chan struct{}),
+		ID:        "test-session",
+		Transport: transport.Conn(serverConn),
+		Messages:  make(chan *message.Buffer, 10),
+		Done:      make(chan struct{}),
 	}
 
 	close(session.Done)
@@ -433,3 +509,343 @@ func TestSession_HandleWrite_DoneChannelClosed(t *testing.T) {
 		t.Error("HandleWrite did not exit after Done channel was closed")
 	}
 }
+
+func TestSession_Disconnect(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+
+	session := &Session{
+		ID:        "test-session",
+		Transport: transport.Conn(serverConn),
+		Messages:  make(chan *message.Buffer, 10),
+		Done:      make(chan struct{}),
+	}
+
+	done := make(chan struct{})
+	go func() {
+		session.HandleWrite(1000)
+		close(done)
+	}()
+
+	go session.Disconnect("Bye")
+
+	buffer := make([]byte, 100)
+	clientConn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	bytesRead, err := clientConn.Read(buffer)
+	if err != nil {
+		t.Fatalf("Failed to read from client connection")
+	}
+	if string(buffer[:bytesRead]) != "Bye\n" {
+		t.Errorf("Expected 'Bye', got %s", string(buffer[:bytesRead]))
+	}
+
+	select {
+	case <-done:
+		// Expected - HandleWrite should exit after the disconnect marker
+	case <-time.After(100 * time.Millisecond):
+		t.Error("HandleWrite did not exit after Disconnect")
+	}
+}
+
+func TestSession_Pipe(t *testing.T) {
+	server, client := transport.Pipe("bot-test")
+	defer client.Close()
+
+	session := &Session{
+		ID:        "bot-test",
+		Transport: server,
+		Messages:  make(chan *message.Buffer, 10),
+		Done:      make(chan struct{}),
+	}
+
+	messages := make(chan message.Message, 10)
+	go session.HandleRead(messages, 1000)
+	go session.HandleWrite(1000)
+
+	client.WriteFrame([]byte("hello\n"))
+	select {
+	case m := <-messages:
+		if string(m.Body) != "hello\n" {
+			t.Errorf("Expected 'hello', got %q", m.Body)
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("No message received")
+	}
+
+	session.Notice("welcome")
+	if frame, err := client.ReadFrame(); err != nil || string(frame) != "welcome\n" {
+		t.Errorf("Expected 'welcome', got %q %v", frame, err)
+	}
+}
+
+func TestSession_HandleWrite_Batch(t *testing.T) {
+	server, client := transport.Pipe("test")
+	defer client.Close()
+
+	session := &Session{
+		ID:        "test-session",
+		Transport: server,
+		Messages:  make(chan *message.Buffer, 10),
+		Done:      make(chan struct{}),
+	}
+
+	// Queued messages go out in one write, up to the one that reaches the
+	// download limit.
+	for _, line := range []string{"one\n", "two\n", "three\n", "four\n"} {
+		session.Messages <- message.NewBuffer([]byte(line))
+	}
+	done := make(chan struct{})
+	go func() {
+		session.HandleWrite(12)
+		close(done)
+	}()
+
+	client.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if frame, err := client.ReadFrame(); err != nil || string(frame) != "one\ntwo\n" {
+		t.Errorf("Expected one batched write, got %q %v", frame, err)
+	}
+	if frame, err := client.ReadFrame(); err != nil || !strings.Contains(string(frame), "Download limit reached") {
+		t.Errorf("Expected download limit message, got %q %v", frame, err)
+	}
+	if session.DownloadedBytes != 8+6 {
+		t.Errorf("Expected 14 downloaded bytes, got %d", session.DownloadedBytes)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(100 * time.Millisecond):
+		t.Error("HandleWrite did not exit after download limit reached")
+	}
+}
+
+func TestSession_HandleWrite_BatchBytes(t *testing.T) {
+	server, client := transport.Pipe("test")
+	defer client.Close()
+
+	session := &Session{
+		ID:         "test-session",
+		Transport:  server,
+		Messages:   make(chan *message.Buffer, 10),
+		Done:       make(chan struct{}),
+		BatchBytes: 8,
+	}
+	defer close(session.Done)
+
+	for _, line := range []string{"one\n", "two\n", "three\n"} {
+		session.Messages <- message.NewBuffer([]byte(line))
+	}
+	go session.HandleWrite(1000)
+
+	client.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	for _, want := range []string{"one\ntwo\n", "three\n"} {
+		if frame, err := client.ReadFrame(); err != nil || string(frame) != want {
+			t.Errorf("Expected %q, got %q %v", want, frame, err)
+		}
+	}
+}
+
+func TestSession_HandleWrite_FlushDelay(t *testing.T) {
+	server, client := transport.Pipe("test")
+	defer client.Close()
+
+	fake := clock.NewFake(time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC))
+	session := &Session{
+		ID:         "test-session",
+		Transport:  server,
+		Messages:   make(chan *message.Buffer),
+		Done:       make(chan struct{}),
+		FlushDelay: 50 * time.Millisecond,
+		Clock:      fake,
+	}
+	defer close(session.Done)
+	go session.HandleWrite(1000)
+
+	// Messages arriving within the delay of the first share its write. The
+	// writer took the second, so it is waiting on the delay of the first.
+	session.Send([]byte("one\n"))
+	session.Send([]byte("two\n"))
+	fake.Advance(40 * time.Millisecond)
+	client.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	if frame, err := client.ReadFrame(); err == nil {
+		t.Errorf("Expected the write to wait for the flush delay, got %q", frame)
+	}
+
+	fake.Advance(10 * time.Millisecond)
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	if frame, err := client.ReadFrame(); err != nil || string(frame) != "one\ntwo\n" {
+		t.Errorf("Expected one delayed write, got %q %v", frame, err)
+	}
+
+	session.Send([]byte("three\n"))
+	// The writer is waiting on the new delay once it took the next message.
+	session.Send([]byte("four\n"))
+	fake.Advance(50 * time.Millisecond)
+	if frame, err := client.ReadFrame(); err != nil || string(frame) != "three\nfour\n" {
+		t.Errorf("Expected a write after the next delay, got %q %v", frame, err)
+	}
+}
+
+func TestSession_WireLimits(t *testing.T) {
+	line := strings.Repeat("all work and no play ", 4) + "\n"
+	for _, wire := range []bool{false, true} {
+		serverPipe, clientPipe := transport.Pipe("test")
+		server, client := transport.Compressible(serverPipe), transport.Compressible(clientPipe)
+		server.Compress(nil, nil)
+		client.Compress(nil, nil)
+
+		session := &Session{
+			ID:         "test-session",
+			Transport:  server,
+			Messages:   make(chan *message.Buffer),
+			Done:       make(chan struct{}),
+			WireLimits: wire,
+		}
+		go session.HandleWrite(4 * len(line))
+
+		received := make(chan string)
+		go func() {
+			var all []byte
+			for {
+				frame, err := client.ReadFrame()
+				if err != nil {
+					received <- string(all)
+					return
+				}
+				all = append(all, frame...)
+			}
+		}()
+
+	send:
+		for i := 0; i < 10; i++ {
+			select {
+			case session.Messages <- message.NewBuffer([]byte(line)):
+			case <-time.After(100 * time.Millisecond):
+				break send
+			}
+		}
+		close(session.Done)
+
+		// Only the compressed lines fit more than four times into the limit.
+		all := <-received
+		delivered := strings.Count(all, line)
+		if wire && delivered <= 4 {
+			t.Errorf("Expected more than 4 compressed lines, got %d", delivered)
+		}
+		if !wire && (delivered != 3 || !strings.Contains(all, "Download limit reached")) {
+			t.Errorf("Expected 3 uncompressed lines and the limit, got %d in %q", delivered, all)
+		}
+	}
+}
+
+func TestSession_HandleRead_WireLimits(t *testing.T) {
+	serverPipe, clientPipe := transport.Pipe("test")
+	server, client := transport.Compressible(serverPipe), transport.Compressible(clientPipe)
+	server.Compress(nil, nil)
+	client.Compress(nil, nil)
+	defer client.Close()
+
+	session := &Session{
+		ID:         "test-session",
+		Transport:  server,
+		Done:       make(chan struct{}),
+		WireLimits: true,
+	}
+	line := strings.Repeat("all work and no play ", 4) + "\n"
+	messages := make(chan message.Message, 10)
+	go session.HandleRead(messages, 4*len(line))
+
+	for i := 0; i < 10; i++ {
+		client.WriteFrame([]byte(line))
+		select {
+		case m := <-messages:
+			if string(m.Body) != line {
+				t.Fatalf("Expected the line, got %q", m.Body)
+			}
+		case <-time.After(100 * time.Millisecond):
+			t.Fatalf("Line %d did not fit into the limit, %d bytes uploaded", i+1, session.UploadedBytes)
+		}
+	}
+}
+
+func TestCost(t *testing.T) {
+	for _, c := range []struct{ n, size, wire, want int }{
+		{10, 0, 0, 10},
+		{10, 100, 20, 2},
+		{10, 100, 1, 1},
+		// Output decompressed from input read earlier is not free.
+		{10, 100, 0, 1},
+	} {
+		if got := cost(c.n, c.size, c.wire); got != c.want {
+			t.Errorf("cost(%d, %d, %d) = %d, want %d", c.n, c.size, c.wire, got, c.want)
+		}
+	}
+}
+
+func BenchmarkSession_HandleWrite(b *testing.B) {
+	for _, delay := range []time.Duration{0, time.Millisecond} {
+		b.Run(fmt.Sprintf("delay=%v", delay), func(b *testing.B) {
+			server, client := transport.Pipe("bench")
+			defer client.Close()
+			writes := &atomic.Int64{}
+			go func() {
+				for {
+					if _, err := client.ReadFrame(); err != nil {
+						return
+					}
+					writes.Add(1)
+				}
+			}()
+
+			session := &Session{
+				ID:         "bench",
+				Transport:  server,
+				Messages:   make(chan *message.Buffer, maxBatch),
+				Done:       make(chan struct{}),
+				BatchBytes: 16 << 10,
+				FlushDelay: delay,
+			}
+			go session.HandleWrite(1 << 62)
+			defer close(session.Done)
+
+			body := []byte("benchmark message\n")
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				session.Send(body)
+			}
+			b.ReportMetric(float64(writes.Load())/float64(b.N), "writes/op")
+		})
+	}
+}
+
+func BenchmarkSession_HandleRead(b *testing.B) {
+	frame := bytes.Repeat([]byte("benchmark message\n"), 8)
+	session := &Session{
+		ID:        "bench",
+		Transport: &frames{frame: frame, left: b.N},
+	}
+	messages := make(chan message.Message, 64)
+	go func() {
+		for range messages {
+		}
+	}()
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(frame)))
+	session.HandleRead(messages, math.MaxInt)
+	close(messages)
+}
+
+// frames is a transport that reads the same frame a number of times.
+type frames struct {
+	transport.Transport
+	frame []byte
+	left  int
+}
+
+func (f *frames) ReadFrame() ([]byte, error) {
+	if f.left == 0 {
+		return nil, io.EOF
+	}
+	f.left--
+	return f.frame, nil
+}
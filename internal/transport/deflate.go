@@ -0,0 +1,170 @@
+package transport
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"io"
+	"sync/atomic"
+)
+
+// ErrCompressed is returned when compression is negotiated twice.
+var ErrCompressed = errors.New("compression already enabled")
+
+// ErrInflated is returned by reads once the input decompressed to more than
+// maxInflation bytes per byte on the connection.
+var ErrInflated = errors.New("compressed input expands too much")
+
+// maxInflation caps how far input may expand. Chat text compresses to about
+// a tenth; DEFLATE allows about a thousandfold.
+const maxInflation = 64
+
+// Compressor is a transport that can switch to DEFLATE once both ends have
+// agreed to, as offered by listeners with compression enabled.
+type Compressor interface {
+	Transport
+	// Compress decompresses everything read from now on, starting with
+	// pending, the input already read past the negotiation, and compresses
+	// everything written after confirm, which is written as it is.
+	Compress(pending, confirm []byte) error
+	// Wire reports how many bytes were read and written on the underlying
+	// transport, which is after compression once it is on.
+	Wire() (read, written int)
+}
+
+// deflate starts out passing frames through. Writes are serialized through
+// lock, a channel holding one token, as the flate writer is not safe for
+// concurrent use and both the reader and the writer of a session write.
+type deflate struct {
+	Transport
+	lock   chan struct{}
+	reader io.ReadCloser
+	writer *flate.Writer
+	out    bytes.Buffer
+	in     raw
+	buffer []byte
+	// inflated counts bytes decompressed, against maxInflation.
+	inflated int64
+	read     atomic.Int64
+	written  atomic.Int64
+}
+
+// raw reads the frames of the underlying transport as one stream.
+type raw struct {
+	t       *deflate
+	pending []byte
+}
+
+// Compressible lets the two ends of t negotiate compression.
+func Compressible(t Transport) Compressor {
+	d := &deflate{Transport: t, lock: make(chan struct{}, 1)}
+	d.in.t = d
+	d.lock <- struct{}{}
+	return d
+}
+
+func (d *deflate) Compress(pending, confirm []byte) error {
+	<-d.lock
+	defer func() { d.lock <- struct{}{} }()
+	if d.writer != nil {
+		return ErrCompressed
+	}
+
+	// Below BestCompression, flate stores short flushed blocks as they
+	// are, and a chat line is usually one short block. Every level costs
+	// about 1MB per connection.
+	writer, err := flate.NewWriter(&d.out, flate.BestCompression)
+	if err != nil {
+		return err
+	}
+	// Nothing else is written until the switch, as the lock is held.
+	if len(confirm) > 0 {
+		if err := d.Transport.WriteFrame(confirm); err != nil {
+			return err
+		}
+		d.written.Add(int64(len(confirm)))
+	}
+	d.writer = writer
+	d.in.pending = append([]byte(nil), pending...)
+	d.reader = flate.NewReader(&d.in)
+	d.buffer = make([]byte, readSize)
+	return nil
+}
+
+func (d *deflate) Wire() (int, int) {
+	return int(d.read.Load()), int(d.written.Load())
+}
+
+func (d *deflate) ReadFrame() ([]byte, error) {
+	if d.reader == nil {
+		frame, err := d.Transport.ReadFrame()
+		d.read.Add(int64(len(frame)))
+		return frame, err
+	}
+	n, err := d.reader.Read(d.buffer)
+	if n > 0 {
+		d.inflated += int64(n)
+		if d.inflated > maxInflation*d.read.Load()+readSize {
+			return nil, ErrInflated
+		}
+		return d.buffer[:n], nil
+	}
+	return nil, err
+}
+
+func (r *raw) Read(p []byte) (int, error) {
+	if len(r.pending) == 0 {
+		frame, err := r.t.Transport.ReadFrame()
+		if err != nil {
+			return 0, err
+		}
+		r.t.read.Add(int64(len(frame)))
+		r.pending = frame
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+func (d *deflate) WriteFrame(frame []byte) error {
+	return d.WriteFrames([][]byte{frame})
+}
+
+// WriteFrames compresses the frames together and flushes them as one
+// frame, so the other end can decompress them without waiting for more.
+func (d *deflate) WriteFrames(frames [][]byte) error {
+	<-d.lock
+	defer func() { d.lock <- struct{}{} }()
+
+	if d.writer == nil {
+		size := 0
+		for _, frame := range frames {
+			size += len(frame)
+		}
+		var err error
+		if len(frames) == 1 {
+			err = d.Transport.WriteFrame(frames[0])
+		} else {
+			err = d.Transport.WriteFrames(frames)
+		}
+		if err == nil {
+			d.written.Add(int64(size))
+		}
+		return err
+	}
+
+	d.out.Reset()
+	for _, frame := range frames {
+		if _, err := d.writer.Write(frame); err != nil {
+			return err
+		}
+	}
+	if err := d.writer.Flush(); err != nil {
+		return err
+	}
+	if err := d.Transport.WriteFrame(d.out.Bytes()); err != nil {
+		return err
+	}
+	d.written.Add(int64(d.out.Len()))
+	return nil
+}
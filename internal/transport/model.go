@@ -0,0 +1,46 @@
+package transport
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// Transport carries frames between the server and one client. On stream
+// transports a frame is whatever one read returned, so lines may be split
+// or joined; message based transports deliver one frame per message.
+type Transport interface {
+	// ReadFrame returns the next frame. It is only valid until the next
+	// call.
+	ReadFrame() ([]byte, error)
+	WriteFrame(frame []byte) error
+	// WriteFrames writes several frames at once, in one system call where
+	// the transport allows it. It may modify frames.
+	WriteFrames(frames [][]byte) error
+	Close() error
+	RemoteAddr() net.Addr
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+}
+
+// conn adapts a net.Conn, including TLS connections. Its read buffer comes
+// from a pool and goes back at the next read, as only the reader uses it.
+type conn struct {
+	net.Conn
+	buffer *[]byte
+}
+
+// pipe is one end of an in-memory transport. Closing either end closes both.
+type pipe struct {
+	in     <-chan []byte
+	out    chan<- []byte
+	done   chan struct{}
+	closed *atomic.Bool
+	name   string
+
+	// Deadlines in Unix nanoseconds, zero when unset.
+	readDeadline  atomic.Int64
+	writeDeadline atomic.Int64
+}
+
+type pipeAddr string
@@ -0,0 +1,170 @@
+package transport
+
+import (
+	"bytes"
+	"crypto/tls"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const readSize = 1024
+
+// pipeQueue is how many frames a pipe holds for a reader that is behind.
+const pipeQueue = 64
+
+var readBuffers = sync.Pool{
+	New: func() any {
+		buffer := make([]byte, readSize)
+		return &buffer
+	},
+}
+
+// Conn adapts a stream connection.
+func Conn(c net.Conn) Transport {
+	return &conn{Conn: c}
+}
+
+// TLS serves a TLS connection over c. The handshake runs on the first read
+// or write.
+func TLS(c net.Conn, config *tls.Config) Transport {
+	return Conn(tls.Server(c, config))
+}
+
+// ReadFrame reads into a pooled buffer. The previous frame is done with by
+// the next call, so its buffer goes back to the pool first and connections
+// share the buffers between reads.
+func (c *conn) ReadFrame() ([]byte, error) {
+	if c.buffer != nil {
+		readBuffers.Put(c.buffer)
+	}
+	c.buffer = readBuffers.Get().(*[]byte)
+	n, err := c.Read(*c.buffer)
+	if n > 0 {
+		return (*c.buffer)[:n], nil
+	}
+	readBuffers.Put(c.buffer)
+	c.buffer = nil
+	return nil, err
+}
+
+func (c *conn) WriteFrame(frame []byte) error {
+	_, err := c.Write(frame)
+	return err
+}
+
+// WriteFrames uses writev on TCP connections. Other connections, such as
+// TLS, get one write per frame.
+func (c *conn) WriteFrames(frames [][]byte) error {
+	buffers := net.Buffers(frames)
+	_, err := buffers.WriteTo(c.Conn)
+	return err
+}
+
+// Pipe returns the two ends of an in-memory transport. Each direction holds
+// up to pipeQueue frames, like a socket buffer; a write waits once it is
+// full. Every write arrives as one frame.
+func Pipe(name string) (Transport, Transport) {
+	a, b := make(chan []byte, pipeQueue), make(chan []byte, pipeQueue)
+	done := make(chan struct{})
+	closed := &atomic.Bool{}
+	return &pipe{in: a, out: b, done: done, closed: closed, name: name},
+		&pipe{in: b, out: a, done: done, closed: closed, name: name}
+}
+
+// ReadFrame returns frames written before the pipe closed ahead of io.EOF.
+func (p *pipe) ReadFrame() ([]byte, error) {
+	select {
+	case frame := <-p.in:
+		return frame, nil
+	default:
+	}
+	timeout, stop := deadline(&p.readDeadline)
+	defer stop()
+
+	select {
+	case frame := <-p.in:
+		return frame, nil
+	case <-p.done:
+		return nil, io.EOF
+	case <-timeout:
+		return nil, os.ErrDeadlineExceeded
+	}
+}
+
+func (p *pipe) WriteFrame(frame []byte) error {
+	return p.send(append([]byte(nil), frame...))
+}
+
+// WriteFrames joins the frames, so the other end reads them as one.
+func (p *pipe) WriteFrames(frames [][]byte) error {
+	return p.send(bytes.Join(frames, nil))
+}
+
+// send hands over a frame the other end may keep.
+func (p *pipe) send(frame []byte) error {
+	if p.closed.Load() {
+		return io.ErrClosedPipe
+	}
+	timeout, stop := deadline(&p.writeDeadline)
+	defer stop()
+
+	select {
+	case p.out <- frame:
+		return nil
+	case <-p.done:
+		return io.ErrClosedPipe
+	case <-timeout:
+		return os.ErrDeadlineExceeded
+	}
+}
+
+func (p *pipe) Close() error {
+	if p.closed.CompareAndSwap(false, true) {
+		close(p.done)
+	}
+	return nil
+}
+
+func (p *pipe) RemoteAddr() net.Addr {
+	return pipeAddr(p.name)
+}
+
+func (p *pipe) SetReadDeadline(t time.Time) error {
+	p.readDeadline.Store(unixNano(t))
+	return nil
+}
+
+func (p *pipe) SetWriteDeadline(t time.Time) error {
+	p.writeDeadline.Store(unixNano(t))
+	return nil
+}
+
+// deadline returns a channel that fires at the stored deadline, or nil when
+// none is set.
+func deadline(at *atomic.Int64) (<-chan time.Time, func() bool) {
+	nanos := at.Load()
+	if nanos == 0 {
+		return nil, func() bool { return false }
+	}
+	timer := time.NewTimer(time.Until(time.Unix(0, nanos)))
+	return timer.C, timer.Stop
+}
+
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func (addr pipeAddr) Network() string {
+	return "pipe"
+}
+
+func (addr pipeAddr) String() string {
+	return string(addr)
+}
@@ -0,0 +1,207 @@
+package transport
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPipe_Frames(t *testing.T) {
+	server, client := Pipe("bot-echo")
+	defer server.Close()
+
+	go func() {
+		client.WriteFrame([]byte("hello\n"))
+		client.WriteFrame([]byte("world\n"))
+	}()
+
+	for _, want := range []string{"hello\n", "world\n"} {
+		frame, err := server.ReadFrame()
+		if err != nil {
+			t.Fatalf("Failed to read frame: %v", err)
+		}
+		if string(frame) != want {
+			t.Errorf("Expected %q, got %q", want, frame)
+		}
+	}
+
+	go client.WriteFrames([][]byte{[]byte("one\n"), []byte("two\n")})
+	if frame, err := server.ReadFrame(); err != nil || string(frame) != "one\ntwo\n" {
+		t.Errorf("Expected frames joined into one, got %q %v", frame, err)
+	}
+
+	if addr := server.RemoteAddr().String(); addr != "bot-echo" {
+		t.Errorf("Expected remote address bot-echo, got %s", addr)
+	}
+}
+
+func TestPipe_Close(t *testing.T) {
+	server, client := Pipe("test")
+
+	read := make(chan error)
+	go func() {
+		_, err := server.ReadFrame()
+		read <- err
+	}()
+
+	client.Close()
+	select {
+	case err := <-read:
+		if err != io.EOF {
+			t.Errorf("Expected EOF after close, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Read did not return after close")
+	}
+
+	if err := server.WriteFrame([]byte("late\n")); err != io.ErrClosedPipe {
+		t.Errorf("Expected closed pipe error, got %v", err)
+	}
+
+	// Frames queued before the close are still read.
+	server, client = Pipe("test")
+	client.WriteFrame([]byte("bye\n"))
+	client.Close()
+	if frame, err := server.ReadFrame(); err != nil || string(frame) != "bye\n" {
+		t.Errorf("Expected the queued frame, got %q %v", frame, err)
+	}
+	if _, err := server.ReadFrame(); err != io.EOF {
+		t.Errorf("Expected EOF after the queued frames, got %v", err)
+	}
+}
+
+func TestPipe_Deadline(t *testing.T) {
+	server, client := Pipe("test")
+	defer server.Close()
+
+	server.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err := server.ReadFrame(); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Expected deadline error, got %v", err)
+	}
+
+	// Writes are queued up to pipeQueue frames, then wait for the reader.
+	client.SetWriteDeadline(time.Now().Add(50 * time.Millisecond))
+	for i := 0; i < pipeQueue; i++ {
+		if err := client.WriteFrame([]byte("queued\n")); err != nil {
+			t.Fatalf("Expected write %d to be queued, got %v", i, err)
+		}
+	}
+	if err := client.WriteFrame([]byte("nobody reads\n")); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Expected deadline error, got %v", err)
+	}
+
+	server.SetReadDeadline(time.Time{})
+	client.SetWriteDeadline(time.Time{})
+	for i := 0; i < pipeQueue; i++ {
+		server.ReadFrame()
+	}
+	go client.WriteFrame([]byte("again\n"))
+	if frame, err := server.ReadFrame(); err != nil || string(frame) != "again\n" {
+		t.Errorf("Expected frame after clearing deadlines, got %q %v", frame, err)
+	}
+}
+
+func TestConn(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	server := Conn(serverConn)
+	defer clientConn.Close()
+
+	go clientConn.Write([]byte("ping\n"))
+	frame, err := server.ReadFrame()
+	if err != nil || string(frame) != "ping\n" {
+		t.Errorf("Expected ping, got %q %v", frame, err)
+	}
+
+	go server.WriteFrame([]byte("pong\n"))
+	buffer := make([]byte, 16)
+	n, _ := clientConn.Read(buffer)
+	if string(buffer[:n]) != "pong\n" {
+		t.Errorf("Expected pong, got %q", buffer[:n])
+	}
+
+	go server.WriteFrames([][]byte{[]byte("one\n"), []byte("two\n")})
+	received := make([]byte, 0, 8)
+	for len(received) < 8 {
+		n, err := clientConn.Read(buffer)
+		if err != nil {
+			t.Fatalf("Failed to read frames: %v", err)
+		}
+		received = append(received, buffer[:n]...)
+	}
+	if string(received) != "one\ntwo\n" {
+		t.Errorf("Expected both frames, got %q", received)
+	}
+
+	server.Close()
+	if _, err := server.ReadFrame(); err == nil {
+		t.Error("Expected read to fail after close")
+	}
+}
+
+func TestCompressible(t *testing.T) {
+	serverPipe, clientPipe := Pipe("test")
+	server, client := Compressible(serverPipe), Compressible(clientPipe)
+	defer server.Close()
+
+	go client.WriteFrame([]byte("COMPRESS deflate\n"))
+	if frame, err := server.ReadFrame(); err != nil || string(frame) != "COMPRESS deflate\n" {
+		t.Fatalf("Expected frames to pass through before negotiation, got %q %v", frame, err)
+	}
+	if err := server.Compress(nil, nil); err != nil {
+		t.Fatalf("Failed to enable compression: %v", err)
+	}
+	if err := client.Compress(nil, nil); err != nil {
+		t.Fatalf("Failed to enable compression: %v", err)
+	}
+	if err := server.Compress(nil, nil); err != ErrCompressed {
+		t.Errorf("Expected ErrCompressed, got %v", err)
+	}
+
+	line := strings.Repeat("the quick brown fox jumps over the lazy dog ", 10) + "\n"
+	for i := 0; i < 3; i++ {
+		go server.WriteFrames([][]byte{[]byte(line), []byte(line)})
+		var received []byte
+		for len(received) < 2*len(line) {
+			frame, err := client.ReadFrame()
+			if err != nil {
+				t.Fatalf("Failed to read compressed frame: %v", err)
+			}
+			received = append(received, frame...)
+		}
+		if string(received) != line+line {
+			t.Fatalf("Expected the lines back, got %q", received)
+		}
+	}
+
+	_, written := server.Wire()
+	read, _ := client.Wire()
+	if read != written || written >= 6*len(line)/4 {
+		t.Errorf("Expected a quarter of %d bytes on the wire, read %d of %d written", 6*len(line), read, written)
+	}
+}
+
+func TestCompressible_Inflation(t *testing.T) {
+	serverPipe, clientPipe := Pipe("test")
+	server, client := Compressible(serverPipe), Compressible(clientPipe)
+	defer server.Close()
+	server.Compress(nil, []byte("Compression enabled\n"))
+	if frame, err := client.ReadFrame(); err != nil || string(frame) != "Compression enabled\n" {
+		t.Fatalf("Expected the confirmation uncompressed, got %q %v", frame, err)
+	}
+	client.Compress(nil, nil)
+
+	// A megabyte of zeros compresses to about a kilobyte.
+	go client.WriteFrame(make([]byte, 1<<20))
+	for {
+		if _, err := server.ReadFrame(); err != nil {
+			if err != ErrInflated {
+				t.Errorf("Expected ErrInflated, got %v", err)
+			}
+			return
+		}
+	}
+}